@@ -0,0 +1,176 @@
+package gopher_fetch
+
+import (
+	"sync"
+	"time"
+)
+
+// 默认的测量吞吐量并调整并发数的间隔
+const defaultAdaptiveInterval = 2 * time.Second
+
+// 默认的继续增加并发数所需的吞吐量提升比例
+const defaultAdaptiveThreshold = 0.1
+
+// AdaptiveConcurrency 自适应并发配置
+// 开始下载时只使用最小并发数，此后每隔一段时间测量一次总吞吐量，吞吐量仍在提升时增加一个并发，
+// 遇到服务器限流（状态码429、503）或者连接被重置时，并发数减半
+type AdaptiveConcurrency struct {
+	// 最小并发数，也是开始下载时的并发数
+	Min int `json:"min"`
+	// 最大并发数，也是分片的数量
+	Max int `json:"max"`
+	// 测量吞吐量并调整并发数的间隔，为0时使用默认值2s
+	Interval time.Duration `json:"interval"`
+	// 吞吐量相比上一次测量提升超过该比例时才继续增加并发数，为0时使用默认值0.1
+	Threshold float64 `json:"threshold"`
+}
+
+// 自适应并发限制器，限制同时下载的分片数量不超过当前的并发上限
+type concurrencyLimiter struct {
+	// 自适应并发配置
+	config AdaptiveConcurrency
+	// 当前的并发上限
+	limit int
+	// 当前正在下载的分片数量
+	active int
+	// 限制器是否已关闭，关闭后不再阻塞等待的分片
+	closed bool
+	// 上一次降低并发数的时间，降低后的一段时间内不增加并发数
+	lastBackoff time.Time
+	// 上一次测量的已下载大小
+	lastSize int64
+	// 上一次测量的时间
+	lastTime time.Time
+	// 上一次测量的吞吐量，单位：字节/毫秒
+	lastThroughput float64
+	// 调整并发上限时输出日志的日志对象
+	logger *fetchLogger
+	// 保护以上状态的锁
+	lock sync.Mutex
+	// 等待并发名额的条件变量
+	cond *sync.Cond
+}
+
+// 创建一个自适应并发限制器
+//
+//   - config 自适应并发配置
+//   - log 输出日志的日志对象
+func newConcurrencyLimiter(config AdaptiveConcurrency, log *fetchLogger) *concurrencyLimiter {
+	if config.Min < 1 {
+		config.Min = 1
+	}
+	if config.Max < config.Min {
+		config.Max = config.Min
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultAdaptiveInterval
+	}
+	if config.Threshold <= 0 {
+		config.Threshold = defaultAdaptiveThreshold
+	}
+	limiter := &concurrencyLimiter{
+		config: config,
+		limit:  config.Min,
+		logger: log,
+	}
+	limiter.cond = sync.NewCond(&limiter.lock)
+	return limiter
+}
+
+// 获取一个并发名额，若当前下载的分片数量已达到上限则阻塞等待
+//
+// 获取成功返回true，限制器已关闭返回false
+func (limiter *concurrencyLimiter) acquire() bool {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	for !limiter.closed && limiter.active >= limiter.limit {
+		limiter.cond.Wait()
+	}
+	if limiter.closed {
+		return false
+	}
+	limiter.active++
+	return true
+}
+
+// 归还一个并发名额
+func (limiter *concurrencyLimiter) release() {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.active--
+	limiter.cond.Broadcast()
+}
+
+// 关闭限制器，唤醒全部等待的分片
+func (limiter *concurrencyLimiter) close() {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.closed = true
+	limiter.cond.Broadcast()
+}
+
+// 获取当前的并发上限
+func (limiter *concurrencyLimiter) currentLimit() int {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	return limiter.limit
+}
+
+// 服务器限流或者过载时，将并发上限减半
+func (limiter *concurrencyLimiter) backoff() {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.lastBackoff = time.Now()
+	limiter.lastThroughput = 0
+	limit := max(limiter.limit/2, limiter.config.Min)
+	if limit != limiter.limit {
+		limiter.limit = limit
+		limiter.logger.warn(msgConcurrencyLimitChanged, field("limit", limit), fieldReason(msgReasonThrottled))
+	}
+}
+
+// 测量一次吞吐量，若吞吐量仍在提升，则增加一个并发
+//
+//   - downloadSize 当前的已下载大小（字节）
+func (limiter *concurrencyLimiter) adjust(downloadSize int64) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(limiter.lastTime)
+	throughput := float64(downloadSize-limiter.lastSize) / (float64(elapsed) / float64(time.Millisecond))
+	first := limiter.lastTime.IsZero()
+	limiter.lastSize, limiter.lastTime = downloadSize, now
+	// 第一次测量只记录基准，降低并发数后的一段时间内不增加并发数
+	if first || now.Sub(limiter.lastBackoff) < 2*limiter.config.Interval {
+		return
+	}
+	// 并发名额未用满时，增加并发数没有意义
+	if limiter.active < limiter.limit || limiter.limit >= limiter.config.Max {
+		limiter.lastThroughput = throughput
+		return
+	}
+	if throughput > limiter.lastThroughput*(1+limiter.config.Threshold) {
+		limiter.limit++
+		limiter.logger.info(msgConcurrencyLimitChanged, field("limit", limiter.limit), fieldReason(msgReasonThroughputImproved))
+		limiter.cond.Broadcast()
+	}
+	limiter.lastThroughput = throughput
+}
+
+// 定时测量吞吐量并调整并发上限，直到 stop 被关闭
+//
+//   - getDownloadSize 获取当前已下载大小的函数
+//   - stop 停止信号
+func (limiter *concurrencyLimiter) run(getDownloadSize func() int64, stop <-chan struct{}) {
+	ticker := time.NewTicker(limiter.config.Interval)
+	defer ticker.Stop()
+	limiter.adjust(getDownloadSize())
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			limiter.adjust(getDownloadSize())
+		}
+	}
+}
\ No newline at end of file
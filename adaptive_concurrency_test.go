@@ -0,0 +1,184 @@
+package gopher_fetch
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试自适应并发限制器的增减规则
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter := newConcurrencyLimiter(AdaptiveConcurrency{Min: 2, Max: 3, Interval: 10 * time.Millisecond}, logger)
+	limiter.acquire()
+	limiter.acquire()
+	// 吞吐量提升且名额用满时增加并发
+	limiter.adjust(0)
+	time.Sleep(20 * time.Millisecond)
+	limiter.adjust(1000)
+	if limiter.limit != 3 {
+		t.Fatalf("并发上限未增加：%d", limiter.limit)
+	}
+	// 达到最大并发数后不再增加
+	limiter.acquire()
+	time.Sleep(20 * time.Millisecond)
+	limiter.adjust(100000)
+	if limiter.limit != 3 {
+		t.Fatalf("并发上限超过最大值：%d", limiter.limit)
+	}
+	// 限流时减半，但不低于最小并发数
+	limiter.backoff()
+	if limiter.limit != 2 {
+		t.Fatalf("并发上限未减半：%d", limiter.limit)
+	}
+	// 减半后的一段时间内不增加并发
+	time.Sleep(10 * time.Millisecond)
+	limiter.adjust(1000000)
+	if limiter.limit != 2 {
+		t.Fatalf("限流后立即增加了并发：%d", limiter.limit)
+	}
+	// 关闭后不再阻塞
+	done := make(chan bool)
+	go func() {
+		done <- limiter.acquire()
+	}()
+	limiter.close()
+	if <-done {
+		t.Error("关闭后仍获取到了并发名额！")
+	}
+}
+
+// 缓慢写入响应体的响应写入器
+type slowResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Write 每次写入前等待一段时间
+func (writer *slowResponseWriter) Write(data []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return writer.ResponseWriter.Write(data)
+}
+
+// 测试服务器限流时的退避时间
+func TestThrottleBackoff(t *testing.T) {
+	backoff := GlobalConfig.ThrottleBackoff
+	GlobalConfig.ThrottleBackoff = time.Second
+	defer func() {
+		GlobalConfig.ThrottleBackoff = backoff
+	}()
+	// 未给出 Retry-After 时每次翻倍，且不超过最大值
+	throttled := &StatusCodeError{StatusCode: http.StatusServiceUnavailable}
+	for attempt, expect := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 10: maxThrottleBackoff} {
+		if delay := throttleBackoff(throttled, attempt); delay != expect {
+			t.Errorf("第%d次退避时间不正确：%s", attempt, delay)
+		}
+	}
+	// 给出 Retry-After 时以其为准
+	for value, expect := range map[string]time.Duration{"120": 2 * time.Minute, "-1": 0, "abc": 0} {
+		response := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{value}}}
+		if e := newStatusCodeError(response); e.RetryAfter != expect {
+			t.Errorf("Retry-After：%s 解析结果不正确：%s", value, e.RetryAfter)
+		}
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	e := newStatusCodeError(&http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{date}}})
+	if e.RetryAfter <= 55*time.Second || e.RetryAfter > time.Minute {
+		t.Errorf("Retry-After：%s 解析结果不正确：%s", date, e.RetryAfter)
+	}
+	if delay := throttleBackoff(e, 1); delay != e.RetryAfter {
+		t.Errorf("未使用 Retry-After 给出的退避时间：%s", delay)
+	}
+}
+
+// 测试自适应并发下载，服务器在同时请求过多时返回503，限流不计入重试次数，且状态中的并发数为当前的并发上限
+func TestParallelGetTask_Adaptive(t *testing.T) {
+	backoff := GlobalConfig.ThrottleBackoff
+	notifyDuration := GlobalConfig.StatusNotifyDuration
+	GlobalConfig.ThrottleBackoff = 5 * time.Millisecond
+	GlobalConfig.StatusNotifyDuration = 0
+	defer func() {
+		GlobalConfig.ThrottleBackoff = backoff
+		GlobalConfig.StatusNotifyDuration = notifyDuration
+	}()
+	content := make([]byte, 4*1024*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	var active, peak, rejected atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet && request.Header.Get("Range") != "bytes=0-0" {
+			current := active.Add(1)
+			defer active.Add(-1)
+			if current > 3 {
+				rejected.Add(1)
+				writer.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			for old := peak.Load(); current > old && !peak.CompareAndSwap(old, current); old = peak.Load() {
+			}
+		}
+		http.ServeContent(&slowResponseWriter{writer}, request, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewAdaptiveParallelGetTask(server.URL, filePath, "", 0, 1, 8)
+	task.Adaptive.Interval = 20 * time.Millisecond
+	var maxConcurrency atomic.Int32
+	task.SubscribeStatus(func(status *TaskStatus) {
+		if !status.IsShutdown && int32(status.Concurrency) > maxConcurrency.Load() {
+			maxConcurrency.Store(int32(status.Concurrency))
+		}
+	})
+	e := task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	if peak.Load() < 2 {
+		t.Errorf("并发数未增加：%d", peak.Load())
+	}
+	if maxConcurrency.Load() < 2 || maxConcurrency.Load() > 8 {
+		t.Errorf("状态中的并发上限不正确：%d", maxConcurrency.Load())
+	}
+	t.Logf("最大并发数：%d，被拒绝的请求数：%d", peak.Load(), rejected.Load())
+}
+
+// 测试服务器持续限流时，限流重试不计入重试次数
+func TestParallelGetTask_Throttle(t *testing.T) {
+	backoff := GlobalConfig.ThrottleBackoff
+	GlobalConfig.ThrottleBackoff = time.Millisecond
+	defer func() {
+		GlobalConfig.ThrottleBackoff = backoff
+	}()
+	content := make([]byte, 64*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	// 前若干次下载请求均返回429，次数超过最大重试次数
+	var throttled atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet && request.Header.Get("Range") != "bytes=0-0" && throttled.Add(1) <= int32(GlobalConfig.Retry*2) {
+			writer.Header().Set("Retry-After", "0")
+			writer.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		http.ServeContent(writer, request, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewParallelGetTask(server.URL, filePath, "", 0, 2)
+	var retried atomic.Int32
+	task.SubscribeEvent(func(event TaskEvent) {
+		retried.Add(1)
+	}, EventShardRetrying)
+	if e := task.Run(); e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	if retried.Load() != 0 {
+		t.Errorf("限流重试被计入了重试次数：%d", retried.Load())
+	}
+}
\ No newline at end of file
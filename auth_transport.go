@@ -0,0 +1,304 @@
+package gopher_fetch
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// 一次请求最多连续发送的次数，包括首次请求、响应质询的请求与刷新认证信息后的请求
+const maxAuthAttempts = 3
+
+// 重新发送请求前，最多读取并丢弃的401响应体大小，使连接可以被复用
+const maxDiscardBodySize = 64 * 1024
+
+// 服务器在 WWW-Authenticate 响应头中给出的认证质询
+type authChallenge struct {
+	// 认证方式，小写，例如：basic digest
+	scheme string
+	// 质询参数，键为小写
+	params map[string]string
+}
+
+// 主机最近一次的认证质询，之后发往该主机的请求会直接按照该质询发送认证信息，不必每次都先收到401
+type cachedChallenge struct {
+	// 认证质询
+	challenge *authChallenge
+	// Digest认证已使用该质询随机数的次数
+	nonceCount uint32
+}
+
+// 按照认证信息提供者为每个请求（包括重定向后的请求）添加认证信息的传输层，并处理服务器的401响应
+type authTransport struct {
+	// 实际发送请求的传输层
+	base *http.Transport
+	// 各主机最近一次的认证质询，键为 协议://主机:端口
+	challenges map[string]*cachedChallenge
+	// 保护认证质询的锁
+	lock sync.Mutex
+}
+
+// 创建处理认证的传输层
+//
+//   - base 实际发送请求的传输层
+func newAuthTransport(base *http.Transport) *authTransport {
+	return &authTransport{
+		base:       base,
+		challenges: make(map[string]*cachedChallenge),
+	}
+}
+
+// CloseIdleConnections 关闭实际传输层的空闲连接
+func (transport *authTransport) CloseIdleConnections() {
+	transport.base.CloseIdleConnections()
+}
+
+// RoundTrip 发送请求，收到401时按照服务器的质询使用Basic或者Digest认证重新发送，或者刷新认证信息后重新发送
+func (transport *authTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	provider := credentialProviderFrom(request.Context())
+	if provider == nil {
+		return transport.base.RoundTrip(request)
+	}
+	credential, e := requestCredential(provider, request)
+	if e != nil {
+		return nil, e
+	}
+	if credential == nil {
+		return transport.base.RoundTrip(request)
+	}
+	key := request.URL.Scheme + "://" + request.URL.Host
+	for attempt := 1; ; attempt++ {
+		// 传输层不能修改原有请求
+		outgoing := request.Clone(request.Context())
+		sent := transport.authorize(outgoing, key, credential)
+		response, e := transport.base.RoundTrip(outgoing)
+		if e != nil || response.StatusCode != http.StatusUnauthorized || attempt >= maxAuthAttempts {
+			return response, e
+		}
+		// 未按照质询发送过认证信息、质询已变化或者服务器表示随机数已过期时，按照新的质询重新发送
+		retry := false
+		if credential.Token == "" {
+			challenge := selectChallenge(parseChallenges(response.Header.Values("WWW-Authenticate")))
+			if challenge != nil && (sent == nil || challenge.scheme != sent.scheme || challenge.params["nonce"] != sent.params["nonce"] || strings.EqualFold(challenge.params["stale"], "true")) {
+				transport.storeChallenge(key, challenge)
+				retry = true
+			}
+		}
+		// 否则认证信息被拒绝，尝试刷新
+		if !retry {
+			refresher, ok := provider.(CredentialRefresher)
+			if !ok {
+				return response, nil
+			}
+			refreshed, e := refresher.Refresh(request.URL, credential)
+			if e != nil {
+				_ = response.Body.Close()
+				return nil, fmt.Errorf("刷新认证信息失败！%w", e)
+			}
+			if refreshed == nil {
+				return response, nil
+			}
+			credential = refreshed
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, maxDiscardBodySize))
+		_ = response.Body.Close()
+	}
+}
+
+// 为请求添加认证信息，令牌直接发送，用户名与密码按照该主机最近一次的质询发送，尚未收到质询时不发送
+//
+//   - request 将要发送的请求
+//   - key 请求主机的键
+//   - credential 认证信息
+//
+// 返回发送认证信息时使用的质询，未使用质询时返回nil
+func (transport *authTransport) authorize(request *http.Request, key string, credential *Credential) *authChallenge {
+	if credential.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+credential.Token)
+		return nil
+	}
+	transport.lock.Lock()
+	cached, ok := transport.challenges[key]
+	var nonceCount uint32
+	if ok {
+		cached.nonceCount++
+		nonceCount = cached.nonceCount
+	}
+	transport.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	switch cached.challenge.scheme {
+	case "basic":
+		request.SetBasicAuth(credential.Username, credential.Password)
+	case "digest":
+		request.Header.Set("Authorization", digestAuthorization(cached.challenge, nonceCount, credential, request.Method, request.URL.RequestURI()))
+	}
+	return cached.challenge
+}
+
+// 保存主机最近一次的认证质询
+//
+//   - key 请求主机的键
+//   - challenge 认证质询
+func (transport *authTransport) storeChallenge(key string, challenge *authChallenge) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	transport.challenges[key] = &cachedChallenge{challenge: challenge}
+}
+
+// 解析 WWW-Authenticate 响应头中的全部认证质询，一个响应头中可以包含以逗号分隔的多个质询
+//
+//   - headers 全部 WWW-Authenticate 响应头的值
+func parseChallenges(headers []string) []*authChallenge {
+	var result []*authChallenge
+	for _, header := range headers {
+		var current *authChallenge
+		rest := header
+		for {
+			rest = strings.TrimLeft(rest, " \t,")
+			if rest == "" {
+				break
+			}
+			end := strings.IndexAny(rest, " \t,=")
+			if end < 0 {
+				end = len(rest)
+			}
+			token := rest[:end]
+			rest = strings.TrimLeft(rest[end:], " \t")
+			// 记号后紧跟等号表示当前质询的参数，否则表示新的质询
+			if current != nil && strings.HasPrefix(rest, "=") {
+				var value string
+				value, rest = parseChallengeValue(strings.TrimLeft(rest[1:], " \t"))
+				current.params[strings.ToLower(token)] = value
+				continue
+			}
+			current = &authChallenge{scheme: strings.ToLower(token), params: make(map[string]string)}
+			result = append(result, current)
+		}
+	}
+	return result
+}
+
+// 解析质询参数的值，支持双引号包围并以反斜杠转义的值
+//
+//   - text 以参数值开头的文本
+//
+// 返回参数值与剩余的文本
+func parseChallengeValue(text string) (string, string) {
+	if !strings.HasPrefix(text, "\"") {
+		end := strings.IndexAny(text, " \t,")
+		if end < 0 {
+			return text, ""
+		}
+		return text[:end], text[end:]
+	}
+	var value strings.Builder
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			if i+1 < len(text) {
+				i++
+				value.WriteByte(text[i])
+			}
+		case '"':
+			return value.String(), text[i+1:]
+		default:
+			value.WriteByte(text[i])
+		}
+	}
+	return value.String(), ""
+}
+
+// 获取Digest认证算法对应的摘要函数，不支持的算法返回nil
+//
+//   - algorithm 质询中的算法，为空表示MD5
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+// 判断Digest质询是否支持qop=auth，未给出qop的质询按照RFC 2069处理
+//
+//   - challenge Digest质询
+func digestQopAuth(challenge *authChallenge) bool {
+	for _, qop := range strings.Split(challenge.params["qop"], ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			return true
+		}
+	}
+	return false
+}
+
+// 从服务器给出的质询中选择支持的一个，Digest优先于Basic，均不支持时返回nil
+//
+//   - challenges 全部认证质询
+func selectChallenge(challenges []*authChallenge) *authChallenge {
+	var basic *authChallenge
+	for _, challenge := range challenges {
+		switch challenge.scheme {
+		case "digest":
+			if digestHash(challenge.params["algorithm"]) != nil && (challenge.params["qop"] == "" || digestQopAuth(challenge)) {
+				return challenge
+			}
+		case "basic":
+			if basic == nil {
+				basic = challenge
+			}
+		}
+	}
+	return basic
+}
+
+// 按照Digest质询计算请求的 Authorization 请求头，参考RFC 7616
+//
+//   - challenge Digest质询
+//   - nonceCount 该质询随机数的使用次数
+//   - credential 认证信息
+//   - method 请求方法
+//   - uri 请求路径
+func digestAuthorization(challenge *authChallenge, nonceCount uint32, credential *Credential, method, uri string) string {
+	newHash := digestHash(challenge.params["algorithm"])
+	digest := func(parts ...string) string {
+		h := newHash()
+		h.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	realm, nonce := challenge.params["realm"], challenge.params["nonce"]
+	cnonceBytes := make([]byte, 16)
+	_, _ = rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+	nc := fmt.Sprintf("%08x", nonceCount)
+	ha1 := digest(credential.Username, realm, credential.Password)
+	if strings.HasSuffix(strings.ToUpper(challenge.params["algorithm"]), "-SESS") {
+		ha1 = digest(ha1, nonce, cnonce)
+	}
+	ha2 := digest(method, uri)
+	quote := strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace
+	var header strings.Builder
+	_, _ = fmt.Fprintf(&header, "Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\"", quote(credential.Username), quote(realm), quote(nonce), quote(uri))
+	if algorithm := challenge.params["algorithm"]; algorithm != "" {
+		_, _ = fmt.Fprintf(&header, ", algorithm=%s", algorithm)
+	}
+	if digestQopAuth(challenge) {
+		_, _ = fmt.Fprintf(&header, ", qop=auth, nc=%s, cnonce=\"%s\", response=\"%s\"", nc, cnonce, digest(ha1, nonce, nc, cnonce, "auth", ha2))
+	} else {
+		_, _ = fmt.Fprintf(&header, ", response=\"%s\"", digest(ha1, nonce, ha2))
+	}
+	if opaque, ok := challenge.params["opaque"]; ok {
+		_, _ = fmt.Fprintf(&header, ", opaque=\"%s\"", quote(opaque))
+	}
+	return header.String()
+}
\ No newline at end of file
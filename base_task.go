@@ -1,37 +1,218 @@
 package gopher_fetch
 
 import (
+	"context"
+	"errors"
 	"gitee.com/swsk33/gopher-notify"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // 基本的下载任务对象
 type baseTask struct {
 	// 配置性质属性
-	// 文件的下载链接
-	Url string `json:"url"`
+	// 文件的下载链接，下载过程中可能被刷新，需持有 urlLock 访问，外部可通过 Url 方法获取
+	url string
+	// 探测服务器时重定向后最终的下载地址，未发生重定向时为空，需持有 urlLock 访问
+	resolvedUrl string
+	// 刷新下载地址的回调函数，为nil表示不刷新
+	urlRefresher UrlRefresher
+	// 当前下载地址的过期时间，零值表示未知
+	urlExpiresAt time.Time
+	// 下载地址变化后的回调函数，在释放 urlLock 后调用，多线程下载任务用于更新各分片的下载地址，可以为nil
+	urlChanged func()
+	// 正在调用刷新回调函数时不为nil，刷新完成后关闭，其它被拒绝的请求等待其关闭后使用刷新的结果
+	urlRefreshing chan struct{}
+	// 保护下载地址、最终地址、过期时间以及刷新状态的锁
+	urlLock sync.Mutex
 	// 下载文件位置
 	FilePath string `json:"filePath"`
-	// 下载进度记录文件位置
+	// 是否以时间戳模式下载
+	timestamping bool
+	// 时间戳模式下最近一次探测服务器的条件探测，未探测时为nil
+	conditional *conditionalProbe
+	// 自动选择下载方式时探测服务器的结果，首次获取文件大小时直接使用，之后为nil
+	probed *probeResult
+	// 下载进度记录文件位置，即任务进度在进度存储中的键
 	processFile string
+	// 保存下载进度的进度存储
+	progressStore ProgressStore
 	// 是否从进度文件恢复的任务
 	isRecover bool
+	// 任务类型，保存进度文件时记录
+	kind taskKind
+	// 任务的创建时间，从进度文件恢复的任务保持原有的创建时间
+	createdAt time.Time
 	// 状态性质属性
-	// 下载文件的总大小（字节）
+	// 下载文件的总大小（字节），为-1表示大小未知，大小未知的文件下载完成后才会被设定，下载过程中需持有 sizeLock 访问
 	TotalSize int64 `json:"totalSize"`
-	// 已下载部分的大小（字节）
-	DownloadSize int64 `json:"downloadSize"`
+	// 保护 TotalSize 的锁，下载协程设定大小时，状态发布与进度保存协程可能同时读取
+	sizeLock sync.Mutex
+	// 已下载部分的大小（字节），下载过程中会被多个协程并发读写，可通过 DownloadSize 方法获取
+	downloadSize atomic.Int64
 	// 任务是否下载完成
-	taskDone bool
+	taskDone atomic.Bool
 	// 任务重试次数
 	retryCount int
+	// 任务是否正在运行
+	running atomic.Bool
+	// 下载请求的连接统计
+	connections connectionCounter
+	// 该任务的http请求客户端提供者，为nil表示使用全局客户端
+	client *clientProvider
+	// 该任务的代理池，为nil表示不使用代理池
+	proxyPool *ProxyPool
+	// 该任务的认证信息提供者，为nil表示使用全局的提供者
+	credentials CredentialProvider
 	// 用户订阅进度变化的观察者主题
 	statusSubject *gopher_notify.Subject[*TaskStatus]
+	// 用户订阅生命周期事件的观察者主题
+	eventSubject *gopher_notify.Subject[TaskEvent]
+	// 该任务的日志对象
+	logger *fetchLogger
 }
 
-// 创建空白文件，需要在获取长度后调用
+// 获取下载文件的总大小
+func (task *baseTask) totalSize() int64 {
+	task.sizeLock.Lock()
+	defer task.sizeLock.Unlock()
+	return task.TotalSize
+}
+
+// 设定下载文件的总大小
+//
+//   - size 文件的总大小（字节），为-1表示大小未知
+func (task *baseTask) setTotalSize(size int64) {
+	task.sizeLock.Lock()
+	defer task.sizeLock.Unlock()
+	task.TotalSize = size
+}
+
+// 创建空白文件，需要在获取长度后调用，文件大小未知时不进行预分配
 func (task *baseTask) createFile() error {
-	return createBlankFile(task.FilePath, task.TotalSize)
+	size := task.totalSize()
+	if size < 0 {
+		return createBlankFile(task.FilePath, 0, task.logger)
+	}
+	e := createBlankFile(task.FilePath, size, task.logger)
+	if e != nil {
+		return e
+	}
+	task.publishEvent(&FilePreallocatedEvent{
+		FilePath: task.FilePath,
+		Size:     size,
+	})
+	return nil
+}
+
+// 保存一次进度文件，并发布对应的保存结果事件
+// 先获取任务快照，再将下载文件已写入的内容同步至磁盘，最后以原子方式写入进度文件，保证进度文件记录的数据均已落盘
+//
+//   - takeSnapshot 获取任务快照的函数
+func (task *baseTask) saveCheckpoint(takeSnapshot func() any) {
+	if task.processFile == "" {
+		return
+	}
+	downloadSize := task.downloadSize.Load()
+	snapshot := takeSnapshot()
+	e := syncFile(task.FilePath)
+	if e == nil {
+		e = saveProcessFile(task.progressStore, task.processFile, task.kind, task.createdAt, snapshot)
+	}
+	if e != nil {
+		task.logger.error(msgSaveProcessError, fieldPath(task.processFile), fieldError(e))
+		task.publishEvent(&CheckpointFailedEvent{
+			ProcessFile: task.processFile,
+			Error:       e,
+		})
+		return
+	}
+	task.publishEvent(&CheckpointSavedEvent{
+		ProcessFile:  task.processFile,
+		DownloadSize: downloadSize,
+	})
+}
+
+// 下载任务未完成并结束时，若进度存储延迟写入进度，则立即写入最后一次保存的进度
+func (task *baseTask) flushProgress() {
+	flusher, ok := task.progressStore.(ProgressFlusher)
+	if task.processFile == "" || !ok {
+		return
+	}
+	if e := flusher.Flush(); e != nil {
+		task.logger.error(msgSaveProcessError, fieldPath(task.processFile), fieldError(e))
+	}
+}
+
+// 从进度文件信封恢复任务的元信息
+//
+//   - envelope 读取到的进度文件信封
+func (task *baseTask) restoreEnvelope(envelope *processFileEnvelope) {
+	task.isRecover = true
+	// 从旧格式升级而来的进度文件没有记录创建时间
+	if !envelope.CreatedAt.IsZero() {
+		task.createdAt = envelope.CreatedAt
+	}
+}
+
+// 删除该任务的进度文件，下载完成后调用
+func (task *baseTask) removeProcessFile() {
+	if task.processFile == "" {
+		return
+	}
+	e := task.progressStore.Delete(task.processFile)
+	if e != nil {
+		task.logger.warn(msgRemoveProcessError, fieldPath(task.processFile), fieldError(e))
+	}
+}
+
+// 发布一个生命周期事件，通知全部事件订阅者
+//
+//   - event 发布的事件
+func (task *baseTask) publishEvent(event TaskEvent) {
+	task.eventSubject.UpdateAndNotify(event, false)
+}
+
+// 发布任务执行结束的事件
+//
+//   - e 任务执行返回的错误，为nil表示任务下载完成
+//   - startTime 本次任务开始执行的时间
+func (task *baseTask) publishFinishEvent(e error, startTime time.Time) {
+	if errors.Is(e, ErrNotModified) {
+		task.publishEvent(&TaskNotModifiedEvent{FilePath: task.FilePath})
+		return
+	}
+	if e != nil {
+		task.publishEvent(&TaskFailedEvent{Error: e})
+		return
+	}
+	task.publishEvent(&TaskCompletedEvent{
+		FilePath:  task.FilePath,
+		TotalSize: task.totalSize(),
+		Elapsed:   time.Since(startTime),
+	})
+}
+
+// SetLogger 为该下载任务单独设定日志输出，请在调用 Run 方法之前调用
+//
+//   - target 自定义的日志输出对象，传入nil表示使用全局日志输出
+func (task *baseTask) SetLogger(target Logger) {
+	task.logger = &fetchLogger{
+		target: target,
+		fields: []LogField{fieldUrl(task.Url())},
+	}
+}
+
+// SetProgressStore 为该下载任务单独设定进度存储，请在调用 Run 方法之前调用
+//
+//   - store 进度存储对象，传入nil表示使用全局的默认进度存储
+func (task *baseTask) SetProgressStore(store ProgressStore) {
+	if store == nil {
+		store = globalProgressStore
+	}
+	task.progressStore = store
 }
 
 // CheckFile 检查文件摘要值，请在调用 Run 方法并下载完成后再调用该函数
@@ -41,7 +222,13 @@ func (task *baseTask) createFile() error {
 //
 // 当下载的文件摘要值和excepted相同时，返回true
 func (task *baseTask) CheckFile(algorithm, excepted string) (bool, error) {
-	return computeFileChecksum(task.FilePath, algorithm, excepted)
+	return computeFileChecksum(task.FilePath, algorithm, excepted, task.logger)
+}
+
+// DownloadSize 获取该任务已下载部分的大小（字节），下载过程中同样可以安全调用
+// 该方法替代了1.8.0及之前版本的 DownloadSize 字段，该字段在下载过程中被并发修改，无法安全读取
+func (task *baseTask) DownloadSize() int64 {
+	return task.downloadSize.Load()
 }
 
 // SubscribeStatus 订阅该下载任务的实时下载状态
@@ -51,8 +238,111 @@ func (task *baseTask) CheckFile(algorithm, excepted string) (bool, error) {
 func (task *baseTask) SubscribeStatus(lookup func(status *TaskStatus)) {
 	// 注册观察者
 	task.statusSubject.Register(&taskObserver{
+		estimator:         newDefaultSpeedEstimator(task.downloadSize.Load()),
+		subscribeFunction: lookup,
+	})
+}
+
+// SubscribeEvent 订阅该下载任务的生命周期事件，请在调用 Run 方法之前调用
+//
+//   - lookup 事件回调函数，在发布事件的协程中被同步调用，请勿在其中执行耗时操作，其参数：
+//     event 发生的事件，可通过类型断言转换为具体的事件类型，例如 *ShardRetryingEvent
+//   - types 需要订阅的事件类型，例如 gopher_fetch.EventShardRetrying ，不传入表示订阅全部事件
+func (task *baseTask) SubscribeEvent(lookup func(event TaskEvent), types ...EventType) {
+	typeSet := make(map[EventType]bool)
+	for _, eventType := range types {
+		typeSet[eventType] = true
+	}
+	task.eventSubject.Register(&eventObserver{
+		types:             typeSet,
 		subscribeFunction: lookup,
-		lastSize:          task.DownloadSize,
-		lastNotifyTime:    time.Now(),
 	})
+}
+
+// 返回该任务发送请求使用的上下文，携带任务的http请求客户端、代理池、认证信息提供者与连接统计
+func (task *baseTask) requestContext() context.Context {
+	ctx := withOriginUrl(withConnectionCounter(context.Background(), &task.connections), task.Url)
+	return withCredentialProvider(withProxyPool(withClientProvider(ctx, task.client), task.proxyPool), task.credentials)
+}
+
+// 获取该任务的http请求客户端提供者，不存在时创建，未覆盖的配置使用全局配置
+func (task *baseTask) clientProvider() *clientProvider {
+	if task.client == nil {
+		task.client = &clientProvider{parent: globalClient}
+	}
+	return task.client
+}
+
+// 使用已创建的http请求客户端提供者，用于自动选择下载方式时复用探测服务器的客户端
+func (task *baseTask) useClientProvider(provider *clientProvider) {
+	task.client = provider
+}
+
+// SetProxy 设定该任务的代理服务器配置，覆盖全局的代理配置，需要在开始下载之前调用，配置有误时返回错误且不修改当前配置
+//
+//   - config 代理服务器配置，传入nil表示使用全局配置，传入空的配置对象表示该任务不使用代理
+func (task *baseTask) SetProxy(config *ProxyConfig) error {
+	if config == nil {
+		task.clientProvider().setProxy(nil)
+		return nil
+	}
+	setting, e := compileProxyConfig(config)
+	if e != nil {
+		return e
+	}
+	task.clientProvider().setProxy(setting)
+	return nil
+}
+
+// SetProxyPool 设定该任务使用的代理池，每个请求都会从代理池选择代理服务器，优先于代理服务器配置，需要在开始下载之前调用
+//
+//   - pool 代理池，传入nil表示不使用代理池
+func (task *baseTask) SetProxyPool(pool *ProxyPool) {
+	task.proxyPool = pool
+}
+
+// SetCredentialProvider 设定该任务的认证信息提供者，覆盖全局的认证信息提供者，需要在开始下载之前调用
+//
+//   - provider 认证信息提供者，传入nil表示使用全局的提供者
+func (task *baseTask) SetCredentialProvider(provider CredentialProvider) {
+	task.credentials = provider
+}
+
+// SetTLSConfig 设定该任务的TLS配置，覆盖全局的TLS配置，需要在开始下载之前调用，配置有误时返回错误且不修改当前配置
+//
+//   - config TLS配置，传入nil表示使用全局配置
+func (task *baseTask) SetTLSConfig(config *TLSConfig) error {
+	if config == nil {
+		task.clientProvider().setTLSConfig(nil)
+		return nil
+	}
+	tlsConfig, e := buildTLSConfig(config, task.logger)
+	if e != nil {
+		return e
+	}
+	task.clientProvider().setTLSConfig(tlsConfig)
+	return nil
+}
+
+// SetDialer 设定该任务建立连接的配置，覆盖全局的建立连接配置，需要在开始下载之前调用，配置有误时返回错误且不修改当前配置
+//
+//   - config 建立连接的配置，传入nil表示使用全局配置
+func (task *baseTask) SetDialer(config *DialerConfig) error {
+	if config == nil {
+		task.clientProvider().setDialer(nil)
+		return nil
+	}
+	setting, e := compileDialerConfig(config)
+	if e != nil {
+		return e
+	}
+	task.clientProvider().setDialer(setting)
+	return nil
+}
+
+// SetCookieJar 设定该任务的Cookie存储，覆盖全局的Cookie存储，需要在开始下载之前调用
+//
+//   - jar Cookie存储，传入nil表示使用全局配置
+func (task *baseTask) SetCookieJar(jar http.CookieJar) {
+	task.clientProvider().setCookieJar(newCookieJarSetting(jar))
 }
\ No newline at end of file
@@ -26,9 +26,10 @@ const (
 //   - filePath 要计算的文件路径
 //   - algorithm 摘要算法名称，支持： gopher_fetch.ChecksumMd5 gopher_fetch.ChecksumSha1 gopher_fetch.ChecksumSha256
 //   - excepted 期望的摘要值，16进制字符串，不区分大小写
+//   - log 输出日志的日志对象
 //
 // 当文件的摘要值和excepted相同时，返回true
-func computeFileChecksum(filePath, algorithm, excepted string) (bool, error) {
+func computeFileChecksum(filePath, algorithm, excepted string, log *fetchLogger) (bool, error) {
 	// 打开文件
 	file, e := os.Open(filePath)
 	if e != nil {
@@ -52,14 +53,12 @@ func computeFileChecksum(filePath, algorithm, excepted string) (bool, error) {
 	// 计算摘要
 	_, e = io.Copy(hashChecker, file)
 	if e != nil {
-		logger.ErrorLine("计算文件摘要出错！")
+		log.error(msgChecksumError, fieldPath(filePath), fieldError(e))
 		return false, e
 	}
 	// 对比
 	fileHash := strings.ToLower(fmt.Sprintf("%x", hashChecker.Sum(nil)))
 	exceptedLower := strings.ToLower(excepted)
-	logger.InfoLine("计算摘要完成！")
-	logger.Info("期望：%s\n", exceptedLower)
-	logger.Info("实际：%s\n", fileHash)
+	log.info(msgChecksumDone, fieldPath(filePath), field("algorithm", algorithm), field("expected", exceptedLower), field("actual", fileHash))
 	return fileHash == exceptedLower, nil
 }
\ No newline at end of file
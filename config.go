@@ -2,22 +2,111 @@ package gopher_fetch
 
 import "time"
 
+// HTTPVersion 下载请求使用的HTTP协议版本
+type HTTPVersion int
+
+// HTTP协议版本常量
+const (
+	// HTTPVersionAuto 优先使用HTTP/2，服务器通过TLS协商支持HTTP/2时，全部分片的请求复用同一个连接，否则使用HTTP/1.1
+	HTTPVersionAuto HTTPVersion = iota
+	// HTTPVersion1 强制使用HTTP/1.1，每个分片使用独立的连接，适用于限制单个连接并发流数量的服务器
+	HTTPVersion1
+)
+
+// 服务器限流后退避等待的最长时间，不限制 Retry-After 响应头给出的等待时间
+const maxThrottleBackoff = 30 * time.Second
+
+// 默认的保存进度文件间隔时间
+const defaultCheckpointInterval = 350 * time.Millisecond
+
 // FetchConfig 全局下载配置
 type FetchConfig struct {
 	// 每个分片的最大重试次数
 	Retry int
+	// 服务器限流（状态码429、503）或者连接被重置时，每个分片最多连续退避重试的次数，这些重试不计入 Retry ，超过后按普通错误重试
+	ThrottleRetry int
+	// 服务器限流后首次退避的等待时间，此后每次翻倍，最长不超过30s，服务器给出 Retry-After 响应头时以其为准
+	ThrottleBackoff time.Duration
 	// 请求头的UserAgent
 	UserAgent string
 	// 发送下载请求时，自定义的附加请求头
 	Headers map[string]string
 	// 监听分片任务下载状态时，每次监听的间隔时间
 	StatusNotifyDuration time.Duration
+	// 计算下载速度时的滑动窗口长度，若小于等于0则只使用最近一次状态变化的间隔
+	SpeedWindow time.Duration
+	// 下载速度指数移动平均的平滑系数，取值范围(0, 1]，越小则速度变化越平滑，设为1表示不进行平滑
+	SpeedSmoothing float64
+	// 下载时保存进度文件的间隔时间，小于等于0时使用默认的350ms
+	CheckpointInterval time.Duration
+	// 建立TCP连接的超时时间，设为0表示不限制
+	DialTimeout time.Duration
+	// TLS握手的超时时间，设为0表示不限制
+	TLSHandshakeTimeout time.Duration
+	// 发送请求后等待响应头的超时时间，不包括读取响应体的时间，设为0表示不限制
+	ResponseHeaderTimeout time.Duration
+	// 下载前探测服务器（获取文件大小、探测是否支持分片获取）的总超时时间，设为0表示不限制
+	ProbeTimeout time.Duration
+	// 是否禁用连接复用，禁用后每个请求都会建立新的连接
+	DisableKeepAlives bool
+	// 每个主机的最大连接数，包括正在使用的与空闲的连接，设为0表示不限制
+	MaxConnsPerHost int
+	// 每个主机在连接池中保留的最大空闲连接数
+	MaxIdleConnsPerHost int
+	// 下载请求使用的HTTP协议版本
+	HTTPVersion HTTPVersion
+	// 空闲读取超时，下载连接超过该时间未读取到任何数据时视为停滞，将中断并重试，设为0表示不检查
+	IdleReadTimeout time.Duration
+	// 最低下载速度，单位：字节/秒，每个下载连接在 MinSpeedWindow 时间内的平均速度低于该值时视为停滞，将中断并重试，设为0表示不检查
+	MinSpeed int64
+	// 计算最低下载速度的时间窗口
+	MinSpeedWindow time.Duration
+	// 多线程下载时，若其余分片均已完成，而最后一个分片在该时间后仍未完成，则对其剩余部分发送一个对冲请求，两者先完成者生效，设为0表示不进行对冲
+	HedgeAfter time.Duration
+	// 下载地址设定了过期时间与刷新回调时，在过期前多久提前刷新下载地址
+	UrlRefreshAhead time.Duration
+	// 每个请求最多跟随的重定向次数，超过时请求失败并返回 ErrTooManyRedirects ，设为0表示不允许重定向
+	MaxRedirects int
+	// 允许重定向至的主机名匹配模式，使用 path.Match 的语法，例如：*.example.com ，为空表示允许重定向至任意主机
+	// 重定向至原请求的主机总是被允许，重定向至其它主机时返回 ErrRedirectNotAllowed
+	RedirectHosts []string
+	// 重定向至其它主机后仍然发送的自定义请求头名称，自定义请求头即 Headers 中的请求头，未列出的自定义请求头只会发送给原请求的主机
+	CrossHostHeaders []string
 }
 
 // GlobalConfig 全局下载配置对象
 var GlobalConfig = &FetchConfig{
-	Retry:                5,
-	UserAgent:            "GopherFetch/1.8.0",
-	Headers:              make(map[string]string),
-	StatusNotifyDuration: 300 * time.Millisecond,
+	Retry:                 5,
+	ThrottleRetry:         20,
+	ThrottleBackoff:       500 * time.Millisecond,
+	UserAgent:             "GopherFetch/" + libraryVersion,
+	Headers:               make(map[string]string),
+	StatusNotifyDuration:  300 * time.Millisecond,
+	SpeedWindow:           3 * time.Second,
+	SpeedSmoothing:        0.3,
+	CheckpointInterval:    defaultCheckpointInterval,
+	DialTimeout:           30 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+	ProbeTimeout:          60 * time.Second,
+	DisableKeepAlives:     false,
+	MaxConnsPerHost:       0,
+	MaxIdleConnsPerHost:   16,
+	HTTPVersion:           HTTPVersionAuto,
+	IdleReadTimeout:       60 * time.Second,
+	MinSpeed:              0,
+	MinSpeedWindow:        30 * time.Second,
+	HedgeAfter:            0,
+	UrlRefreshAhead:       30 * time.Second,
+	MaxRedirects:          10,
+	RedirectHosts:         nil,
+	CrossHostHeaders:      nil,
+}
+
+// 获取保存进度文件的间隔时间，配置的间隔小于等于0时使用默认间隔，避免持续保存进度文件
+func (config *FetchConfig) checkpointInterval() time.Duration {
+	if config.CheckpointInterval <= 0 {
+		return defaultCheckpointInterval
+	}
+	return config.CheckpointInterval
 }
\ No newline at end of file
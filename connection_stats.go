@@ -0,0 +1,84 @@
+package gopher_fetch
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ConnectionStats 下载任务发送请求时的连接统计，包括下载前探测服务器的请求
+type ConnectionStats struct {
+	// 收到响应的请求总数
+	Requests int64
+	// 新建立的连接数
+	NewConnections int64
+	// 复用连接池中已有连接的请求数
+	ReusedConnections int64
+	// 使用HTTP/2协议的请求数，这些请求可能复用同一个连接
+	HTTP2Requests int64
+}
+
+// 连接统计计数器，会被多个分片协程并发访问，因此均使用原子类型
+type connectionCounter struct {
+	// 收到响应的请求总数
+	requests atomic.Int64
+	// 新建立的连接数
+	newConnections atomic.Int64
+	// 复用连接的请求数
+	reusedConnections atomic.Int64
+	// 使用HTTP/2协议的请求数
+	http2Requests atomic.Int64
+}
+
+// 记录一次获取连接
+//
+//   - reused 是否复用了已有连接
+func (counter *connectionCounter) gotConn(reused bool) {
+	if counter == nil {
+		return
+	}
+	if reused {
+		counter.reusedConnections.Add(1)
+	} else {
+		counter.newConnections.Add(1)
+	}
+}
+
+// 记录一次收到响应的请求
+//
+//   - protoMajor 响应的HTTP协议主版本号
+func (counter *connectionCounter) gotResponse(protoMajor int) {
+	if counter == nil {
+		return
+	}
+	counter.requests.Add(1)
+	if protoMajor == 2 {
+		counter.http2Requests.Add(1)
+	}
+}
+
+// 获取当前的连接统计
+func (counter *connectionCounter) stats() ConnectionStats {
+	return ConnectionStats{
+		Requests:          counter.requests.Load(),
+		NewConnections:    counter.newConnections.Load(),
+		ReusedConnections: counter.reusedConnections.Load(),
+		HTTP2Requests:     counter.http2Requests.Load(),
+	}
+}
+
+// 上下文中连接统计计数器的键类型
+type connectionCounterKey struct{}
+
+// 返回一个携带连接统计计数器的上下文，使用该上下文发送的请求都会被计入
+//
+//   - ctx 父上下文
+//   - counter 连接统计计数器
+func withConnectionCounter(ctx context.Context, counter *connectionCounter) context.Context {
+	return context.WithValue(ctx, connectionCounterKey{}, counter)
+}
+
+// 从上下文中获取连接统计计数器，不存在时返回nil
+func connectionCounterFrom(ctx context.Context) *connectionCounter {
+	counter, _ := ctx.Value(connectionCounterKey{}).(*connectionCounter)
+	return counter
+}
\ No newline at end of file
@@ -0,0 +1,39 @@
+package gopher_fetch
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试分片依次下载时的连接复用统计
+func TestParallelGetTask_Connections(t *testing.T) {
+	disableKeepAlives := GlobalConfig.DisableKeepAlives
+	defer func() {
+		GlobalConfig.DisableKeepAlives = disableKeepAlives
+	}()
+	server, content := newTestFileServer(t, 256*1024)
+	for _, disable := range []bool{false, true} {
+		GlobalConfig.DisableKeepAlives = disable
+		filePath := filepath.Join(t.TempDir(), "file.bin")
+		// 并发数固定为1，使分片依次下载
+		task := NewAdaptiveParallelGetTask(server.URL, filePath, "", 0, 1, 4)
+		task.Adaptive.Interval = time.Hour
+		e := task.Run()
+		if e != nil {
+			t.Fatal(e)
+		}
+		checkDownloadedFile(t, filePath, content)
+		// 包括获取文件大小的HEAD请求
+		stats := task.Status().Connections
+		if stats.Requests != 5 || stats.NewConnections+stats.ReusedConnections != 5 {
+			t.Fatalf("连接统计不正确：%+v", stats)
+		}
+		if disable && stats.ReusedConnections != 0 {
+			t.Errorf("禁用连接复用时仍复用了连接：%+v", stats)
+		}
+		if !disable && stats.ReusedConnections < 4 {
+			t.Errorf("未复用连接：%+v", stats)
+		}
+	}
+}
\ No newline at end of file
@@ -0,0 +1,306 @@
+package gopher_fetch
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Netscape格式Cookie文件中，表示HttpOnly的Cookie的行前缀
+const httpOnlyPrefix = "#HttpOnly_"
+
+// Cookie存储，以指针的形式保存在客户端配置中，使其可以比较
+type cookieJarSetting struct {
+	// Cookie存储对象
+	jar http.CookieJar
+}
+
+// CookieJar 支持从Netscape格式的cookies.txt文件导入以及保存回文件的Cookie存储，实现了 http.CookieJar 接口
+// 服务器在响应（包括重定向）中设定或者更新的Cookie会被自动保存，并在之后的请求中发送，可以被多个下载任务共享
+type CookieJar struct {
+	// 实际存储与匹配Cookie的对象
+	jar *cookiejar.Jar
+	// 全部Cookie的记录，用于保存至文件，键为 域名;路径;名称，只记录被实际存储对象接受的Cookie
+	records map[string]*cookieRecord
+	// 保护记录的锁，设定Cookie时同时持有，保证实际存储对象与记录的更新顺序一致
+	lock sync.Mutex
+}
+
+// 一个Cookie的记录，字段对应Netscape格式Cookie文件的各列
+type cookieRecord struct {
+	// 域名，不包含开头的点
+	domain string
+	// 是否同时适用于子域名，为false表示只适用于该主机
+	includeSubdomains bool
+	// 路径
+	path string
+	// 是否只通过HTTPS发送
+	secure bool
+	// 是否为HttpOnly
+	httpOnly bool
+	// 过期时间，零值表示会话Cookie
+	expires time.Time
+	// 名称
+	name string
+	// 值
+	value string
+}
+
+// NewCookieJar 创建一个空的Cookie存储，使用公共后缀列表拒绝为 com 、 co.uk 等公共后缀设定的Cookie
+func NewCookieJar() *CookieJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &CookieJar{
+		jar:     jar,
+		records: make(map[string]*cookieRecord),
+	}
+}
+
+// LoadCookieJar 创建Cookie存储，并从Netscape格式的cookies.txt文件导入Cookie
+//
+//   - file Cookie文件路径
+func LoadCookieJar(file string) (*CookieJar, error) {
+	jar := NewCookieJar()
+	e := jar.Load(file)
+	if e != nil {
+		return nil, e
+	}
+	return jar, nil
+}
+
+// 获取Cookie在未指定路径时的默认路径，即请求路径的目录部分
+//
+//   - requestPath 请求路径
+func defaultCookiePath(requestPath string) string {
+	if requestPath == "" || requestPath[0] != '/' {
+		return "/"
+	}
+	index := strings.LastIndex(requestPath, "/")
+	if index == 0 {
+		return "/"
+	}
+	return requestPath[:index]
+}
+
+// SetCookies 保存服务器为该地址设定的Cookie，实现 http.CookieJar 接口
+//
+//   - u 请求地址
+//   - cookies 服务器设定的Cookie
+func (jar *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	jar.lock.Lock()
+	defer jar.lock.Unlock()
+	jar.jar.SetCookies(u, cookies)
+	host := strings.ToLower(u.Hostname())
+	now := time.Now()
+	for _, cookie := range cookies {
+		record := &cookieRecord{
+			domain:   host,
+			path:     cookie.Path,
+			secure:   cookie.Secure,
+			httpOnly: cookie.HttpOnly,
+			name:     cookie.Name,
+			value:    cookie.Value,
+		}
+		if domain := strings.TrimPrefix(strings.ToLower(cookie.Domain), "."); domain != "" && domain != host {
+			// 与请求主机不匹配的域名会被丢弃，与 cookiejar 的行为保持一致
+			if !strings.HasSuffix(host, "."+domain) || net.ParseIP(host) != nil {
+				continue
+			}
+			record.domain = domain
+			record.includeSubdomains = true
+		} else if domain != "" && net.ParseIP(host) == nil && !isPublicSuffix(host) {
+			// 域名为公共后缀本身时，只适用于该主机
+			record.includeSubdomains = true
+		}
+		if record.path == "" || record.path[0] != '/' {
+			record.path = defaultCookiePath(u.Path)
+		}
+		if cookie.MaxAge > 0 {
+			record.expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		} else if !cookie.Expires.IsZero() {
+			record.expires = cookie.Expires
+		}
+		key := record.domain + ";" + record.path + ";" + record.name
+		if cookie.MaxAge < 0 || (!record.expires.IsZero() && !record.expires.After(now)) {
+			delete(jar.records, key)
+			continue
+		}
+		// 被实际存储对象拒绝的Cookie不记录，例如域名为公共后缀的Cookie
+		if !jar.accepted(record) {
+			continue
+		}
+		jar.records[key] = record
+	}
+}
+
+// 判断一个Cookie记录是否被实际存储对象接受，即请求其域名与路径时会发送该Cookie，需持有锁调用
+//
+//   - record Cookie记录
+func (jar *CookieJar) accepted(record *cookieRecord) bool {
+	host := record.domain
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	for _, cookie := range jar.jar.Cookies(&url.URL{Scheme: "https", Host: host, Path: record.path}) {
+		if cookie.Name == record.name && cookie.Value == record.value {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断一个域名是否为公共后缀，例如 com 、 co.uk
+//
+//   - domain 域名
+func isPublicSuffix(domain string) bool {
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	return suffix == domain
+}
+
+// Cookies 获取请求该地址时应发送的Cookie，实现 http.CookieJar 接口
+//
+//   - u 请求地址
+func (jar *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return jar.jar.Cookies(u)
+}
+
+// Load 从Netscape格式的cookies.txt文件（curl、wget与浏览器扩展导出的格式）导入Cookie，已过期的Cookie会被忽略
+//
+//   - file Cookie文件路径
+func (jar *CookieJar) Load(file string) error {
+	content, e := os.ReadFile(file)
+	if e != nil {
+		return fmt.Errorf("读取Cookie文件%s失败！%w", file, e)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	now := time.Now()
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// 共7列：域名、是否包含子域名、路径、是否仅HTTPS、过期时间、名称、值，值为空时可能缺少最后一列
+		columns := strings.Split(line, "\t")
+		if len(columns) == 6 {
+			columns = append(columns, "")
+		}
+		if len(columns) != 7 {
+			return fmt.Errorf("Cookie文件%s第%d行格式错误，应为以制表符分隔的7列！", file, lineNumber)
+		}
+		expiresUnix, e := strconv.ParseInt(columns[4], 10, 64)
+		if e != nil {
+			return fmt.Errorf("Cookie文件%s第%d行的过期时间%s格式错误！", file, lineNumber, columns[4])
+		}
+		var expires time.Time
+		if expiresUnix > 0 {
+			expires = time.Unix(expiresUnix, 0)
+			if !expires.After(now) {
+				continue
+			}
+		}
+		domain := strings.TrimPrefix(strings.ToLower(columns[0]), ".")
+		if domain == "" {
+			return fmt.Errorf("Cookie文件%s第%d行的域名为空！", file, lineNumber)
+		}
+		secure := strings.EqualFold(columns[3], "TRUE")
+		cookie := &http.Cookie{
+			Name:     columns[5],
+			Value:    columns[6],
+			Path:     columns[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+			Expires:  expires,
+		}
+		if strings.EqualFold(columns[1], "TRUE") {
+			cookie.Domain = domain
+		}
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: domain, Path: columns[2]}, []*http.Cookie{cookie})
+	}
+	return scanner.Err()
+}
+
+// Save 将当前全部未过期的Cookie保存为Netscape格式的cookies.txt文件，可被curl、wget或者 Load 方法读取
+// 会话Cookie的过期时间记为0，先写入临时文件再替换原文件，原文件会保留为.bak后缀的备份文件
+//
+//   - file Cookie文件路径
+func (jar *CookieJar) Save(file string) error {
+	jar.lock.Lock()
+	records := make([]*cookieRecord, 0, len(jar.records))
+	now := time.Now()
+	for key, record := range jar.records {
+		if !record.expires.IsZero() && !record.expires.After(now) {
+			delete(jar.records, key)
+			continue
+		}
+		records = append(records, record)
+	}
+	jar.lock.Unlock()
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].domain != records[j].domain {
+			return records[i].domain < records[j].domain
+		}
+		if records[i].path != records[j].path {
+			return records[i].path < records[j].path
+		}
+		return records[i].name < records[j].name
+	})
+	var content bytes.Buffer
+	content.WriteString("# Netscape HTTP Cookie File\n")
+	content.WriteString("# Generated by GopherFetch/" + libraryVersion + "\n\n")
+	boolColumn := map[bool]string{true: "TRUE", false: "FALSE"}
+	for _, record := range records {
+		domain := record.domain
+		if record.includeSubdomains {
+			domain = "." + domain
+		}
+		if record.httpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expires int64
+		if !record.expires.IsZero() {
+			expires = record.expires.Unix()
+		}
+		_, _ = fmt.Fprintf(&content, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, boolColumn[record.includeSubdomains], record.path, boolColumn[record.secure], expires, record.name, record.value)
+	}
+	// Cookie可能包含登录凭据，因此只允许当前用户读写，以原子方式写入，避免写入中断时丢失原有的Cookie
+	e := writeFileAtomic(content.Bytes(), file, 0600)
+	if e != nil {
+		return fmt.Errorf("保存Cookie文件%s失败！%w", file, e)
+	}
+	return nil
+}
+
+// 创建Cookie存储对应的配置对象，为nil时返回nil
+//
+//   - jar Cookie存储
+func newCookieJarSetting(jar http.CookieJar) *cookieJarSetting {
+	if jar == nil {
+		return nil
+	}
+	return &cookieJarSetting{jar: jar}
+}
+
+// ConfigSetCookieJar 设定全局的Cookie存储，全部下载请求（包括探测服务器的请求与重定向）都会发送并保存其中的Cookie
+//
+//   - jar Cookie存储，可以是 NewCookieJar 、 LoadCookieJar 创建的对象或者其它 http.CookieJar 实现，传入nil表示不处理Cookie
+func ConfigSetCookieJar(jar http.CookieJar) {
+	globalClient.setCookieJar(newCookieJarSetting(jar))
+}
\ No newline at end of file
@@ -0,0 +1,160 @@
+package gopher_fetch
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试Netscape格式Cookie文件的导入与保存
+func TestCookieJar_LoadSave(t *testing.T) {
+	expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	content := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		"",
+		".example.com\tTRUE\t/\tFALSE\t" + expires + "\tdomain\td",
+		"files.example.com\tFALSE\t/private\tTRUE\t0\tsession\ts",
+		"#HttpOnly_files.example.com\tFALSE\t/\tFALSE\t" + expires + "\thttpOnly\th",
+		"files.example.com\tFALSE\t/\tFALSE\t1\texpired\te",
+		"files.example.com\tFALSE\t/\tFALSE\t" + expires + "\tempty",
+	}, "\r\n")
+	file := filepath.Join(t.TempDir(), "cookies.txt")
+	if e := os.WriteFile(file, []byte(content), 0600); e != nil {
+		t.Fatal(e)
+	}
+	jar, e := LoadCookieJar(file)
+	if e != nil {
+		t.Fatal(e)
+	}
+	check := func(jar *CookieJar, requestUrl string, excepted ...string) {
+		u, _ := url.Parse(requestUrl)
+		var actual []string
+		for _, cookie := range jar.Cookies(u) {
+			actual = append(actual, cookie.Name+"="+cookie.Value)
+		}
+		if strings.Join(actual, ";") != strings.Join(excepted, ";") {
+			t.Errorf("%s 的Cookie不正确：%v，应为%v", requestUrl, actual, excepted)
+		}
+	}
+	check(jar, "https://files.example.com/private/a.bin", "session=s", "domain=d", "httpOnly=h", "empty=")
+	check(jar, "http://files.example.com/private/a.bin", "domain=d", "httpOnly=h", "empty=")
+	check(jar, "http://cdn.example.com/a.bin", "domain=d")
+	check(jar, "http://other.example.org/a.bin")
+	// 服务器删除与更新Cookie
+	u, _ := url.Parse("http://files.example.com/a.bin")
+	jar.SetCookies(u, []*http.Cookie{{Name: "httpOnly", MaxAge: -1}, {Name: "domain", Value: "d2", Domain: "example.com", Path: "/"}})
+	check(jar, "http://files.example.com/a.bin", "domain=d2", "empty=")
+	// 保存后重新导入
+	saved := filepath.Join(t.TempDir(), "saved.txt")
+	if e = jar.Save(saved); e != nil {
+		t.Fatal(e)
+	}
+	// 再次保存时以原子方式替换原文件，且只允许当前用户读写
+	if e = jar.Save(saved); e != nil {
+		t.Fatal(e)
+	}
+	if info, e := os.Stat(saved); e != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0600) {
+		t.Errorf("保存的Cookie文件权限不正确：%v %v", info, e)
+	}
+	if _, e = os.Stat(saved + tempFileSuffix); !os.IsNotExist(e) {
+		t.Error("保存Cookie文件后临时文件未被删除！")
+	}
+	reloaded, e := LoadCookieJar(saved)
+	if e != nil {
+		t.Fatal(e)
+	}
+	check(reloaded, "https://files.example.com/private/a.bin", "session=s", "domain=d2", "empty=")
+	check(reloaded, "http://cdn.example.com/a.bin", "domain=d2")
+	// 错误的文件
+	for _, invalid := range []string{"example.com\tTRUE\t/\n", "example.com\tTRUE\t/\tFALSE\tnever\tname\tvalue\n", "\tTRUE\t/\tFALSE\t0\tname\tvalue\n"} {
+		if e = os.WriteFile(file, []byte(invalid), 0600); e != nil {
+			t.Fatal(e)
+		}
+		if _, e = LoadCookieJar(file); e == nil {
+			t.Errorf("错误的Cookie文件未返回错误：%q", invalid)
+		}
+	}
+}
+
+// 测试为公共后缀设定的Cookie被拒绝，且保存的内容与实际存储一致
+func TestCookieJar_PublicSuffix(t *testing.T) {
+	jar := NewCookieJar()
+	u, _ := url.Parse("https://files.example.co.uk/a.bin")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "suffix", Value: "s", Domain: "co.uk", Path: "/"},
+		{Name: "domain", Value: "d", Domain: "example.co.uk", Path: "/"},
+	})
+	other, _ := url.Parse("https://other.co.uk/a.bin")
+	if cookies := jar.Cookies(other); len(cookies) != 0 {
+		t.Errorf("为公共后缀设定的Cookie未被拒绝：%v", cookies)
+	}
+	saved := filepath.Join(t.TempDir(), "saved.txt")
+	if e := jar.Save(saved); e != nil {
+		t.Fatal(e)
+	}
+	content, e := os.ReadFile(saved)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if strings.Contains(string(content), "suffix") || !strings.Contains(string(content), ".example.co.uk\tTRUE\t/\tFALSE\t0\tdomain\td") {
+		t.Errorf("保存的Cookie与实际存储不一致：\n%s", content)
+	}
+}
+
+// 测试下载需要会话Cookie的文件，且服务器在重定向时更新Cookie
+func TestMonoGetTask_CookieJar(t *testing.T) {
+	server, content := newWrappedFileServer(t, 64*1024, func(fileHandler http.Handler) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			token, e := request.Cookie("token")
+			switch request.URL.Path {
+			case "/download":
+				if e != nil || (token.Value != "old" && token.Value != "new") {
+					writer.WriteHeader(http.StatusForbidden)
+					return
+				}
+				http.SetCookie(writer, &http.Cookie{Name: "token", Value: "new", Path: "/", MaxAge: 3600})
+				http.Redirect(writer, request, "/file.bin", http.StatusFound)
+			case "/file.bin":
+				if e != nil || token.Value != "new" {
+					writer.WriteHeader(http.StatusForbidden)
+					return
+				}
+				fileHandler.ServeHTTP(writer, request)
+			}
+		}
+	})
+	serverUrl, _ := url.Parse(server.URL)
+	cookieFile := filepath.Join(t.TempDir(), "cookies.txt")
+	e := os.WriteFile(cookieFile, []byte(serverUrl.Hostname()+"\tFALSE\t/\tFALSE\t0\ttoken\told\n"), 0600)
+	if e != nil {
+		t.Fatal(e)
+	}
+	jar, e := LoadCookieJar(cookieFile)
+	if e != nil {
+		t.Fatal(e)
+	}
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewMonoGetTask(server.URL+"/download", filePath, "")
+	task.SetCookieJar(jar)
+	if e = task.Run(); e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	// 更新后的Cookie应被保存
+	if e = jar.Save(cookieFile); e != nil {
+		t.Fatal(e)
+	}
+	saved, e := os.ReadFile(cookieFile)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !strings.Contains(string(saved), "\ttoken\tnew\n") || strings.Contains(string(saved), "\ttoken\told\n") {
+		t.Errorf("保存的Cookie不正确：\n%s", saved)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,216 @@
+package gopher_fetch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Credential 访问某个地址时使用的认证信息
+type Credential struct {
+	// 用户名，与密码一起用于HTTP Basic或者Digest认证，具体使用哪种方式由服务器返回的质询决定
+	Username string
+	// 密码
+	Password string
+	// 令牌，不为空时以 Authorization: Bearer 令牌 的形式在每个请求中发送，优先于用户名与密码
+	Token string
+}
+
+// CredentialProvider 认证信息提供者，每个请求（包括探测服务器的请求与重定向后的请求）发送前都会按照请求地址获取认证信息
+// 会被多个分片并发调用，实现需要保证并发安全
+type CredentialProvider interface {
+	// Credential 获取访问该地址时使用的认证信息，返回nil表示不进行认证，返回错误时请求失败
+	Credential(u *url.URL) (*Credential, error)
+}
+
+// CredentialRefresher 支持刷新认证信息的认证信息提供者
+// 服务器以401拒绝认证信息时会调用 Refresh 获取新的认证信息并重新发送请求，使长时间运行的分片可以在令牌过期后继续下载
+type CredentialRefresher interface {
+	CredentialProvider
+	// Refresh 刷新被服务器拒绝的认证信息，返回新的认证信息，之后的请求都应使用新的认证信息，返回错误表示无法刷新
+	Refresh(u *url.URL, rejected *Credential) (*Credential, error)
+}
+
+// CredentialFunc 将函数作为认证信息提供者
+type CredentialFunc func(u *url.URL) (*Credential, error)
+
+// Credential 调用函数本身获取认证信息
+func (f CredentialFunc) Credential(u *url.URL) (*Credential, error) {
+	return f(u)
+}
+
+// 需要结合任务原下载地址判断是否适用的认证信息提供者，发送请求时优先调用
+type originCredentialProvider interface {
+	// 获取访问该地址时使用的认证信息
+	//
+	//   - u 请求地址，可能是重定向后的地址
+	//   - origin 任务的原下载地址
+	originCredential(u, origin *url.URL) (*Credential, error)
+}
+
+// 获取请求使用的认证信息，提供者需要结合任务原下载地址判断时，传入请求上下文携带的原下载地址
+//
+//   - provider 认证信息提供者
+//   - request 将要发送的请求
+func requestCredential(provider CredentialProvider, request *http.Request) (*Credential, error) {
+	if scoped, ok := provider.(originCredentialProvider); ok {
+		return scoped.originCredential(request.URL, originUrlFrom(request.Context(), rootRequest(request).URL))
+	}
+	return provider.Credential(request.URL)
+}
+
+// 获取重定向链中的第一个请求，未发生重定向时为请求本身
+//
+//   - request 请求
+func rootRequest(request *http.Request) *http.Request {
+	for request.Response != nil && request.Response.Request != nil {
+		request = request.Response.Request
+	}
+	return request
+}
+
+// CredentialRule 按主机匹配的认证信息
+type CredentialRule struct {
+	// 主机名匹配模式，不含端口，使用 path.Match 的语法，例如：*.example.com
+	Host string
+	// 匹配的主机使用的认证信息
+	Credential *Credential
+}
+
+// CredentialRules 按主机匹配的认证信息列表，使用第一个匹配请求主机的规则，均不匹配时不进行认证
+type CredentialRules []CredentialRule
+
+// Credential 获取第一个匹配请求主机的认证信息
+func (rules CredentialRules) Credential(u *url.URL) (*Credential, error) {
+	for _, rule := range rules {
+		matched, e := matchHost(rule.Host, u)
+		if e != nil {
+			return nil, e
+		}
+		if matched {
+			return rule.Credential, nil
+		}
+	}
+	return nil, nil
+}
+
+// 判断请求主机是否匹配主机名匹配模式，不区分大小写
+//
+//   - pattern 主机名匹配模式
+//   - u 请求地址
+func matchHost(pattern string, u *url.URL) (bool, error) {
+	matched, e := path.Match(strings.ToLower(pattern), strings.ToLower(u.Hostname()))
+	if e != nil {
+		return false, fmt.Errorf("认证信息的主机匹配模式%s格式错误！%w", pattern, e)
+	}
+	return matched, nil
+}
+
+// TokenCredentials 使用Bearer令牌认证的认证信息提供者，令牌被服务器拒绝时调用刷新函数获取新的令牌
+// 多个分片同时收到401时只会刷新一次，其余分片直接使用刷新后的令牌
+type TokenCredentials struct {
+	// 适用的主机名匹配模式，语法同 CredentialRule 的 Host ，为空表示只适用于下载任务原下载地址的主机，
+	// 重定向至其它主机的请求不会携带令牌
+	Hosts []string
+	// 当前的令牌
+	Token string
+	// 刷新令牌的回调函数，为nil表示不刷新
+	RefreshToken func(u *url.URL) (string, error)
+	// 保护令牌的锁
+	lock sync.Mutex
+}
+
+// 判断令牌是否适用于该主机
+//
+//   - u 请求地址
+//   - origin 任务的原下载地址，未指定 Hosts 时只适用于该地址的主机
+func (credentials *TokenCredentials) matches(u, origin *url.URL) (bool, error) {
+	if len(credentials.Hosts) == 0 {
+		return isSameHost(u, origin), nil
+	}
+	for _, host := range credentials.Hosts {
+		matched, e := matchHost(host, u)
+		if e != nil || matched {
+			return matched, e
+		}
+	}
+	return false, nil
+}
+
+// Credential 获取当前的令牌，主机不适用时返回nil
+// 直接调用时无法得知任务的原下载地址，此时将请求地址视为原下载地址
+func (credentials *TokenCredentials) Credential(u *url.URL) (*Credential, error) {
+	return credentials.originCredential(u, u)
+}
+
+// 获取当前的令牌，请求主机不适用时返回nil
+func (credentials *TokenCredentials) originCredential(u, origin *url.URL) (*Credential, error) {
+	matched, e := credentials.matches(u, origin)
+	if e != nil || !matched {
+		return nil, e
+	}
+	credentials.lock.Lock()
+	defer credentials.lock.Unlock()
+	return &Credential{Token: credentials.Token}, nil
+}
+
+// Refresh 刷新被拒绝的令牌，若令牌已被其它请求刷新，则直接返回新的令牌
+func (credentials *TokenCredentials) Refresh(u *url.URL, rejected *Credential) (*Credential, error) {
+	credentials.lock.Lock()
+	defer credentials.lock.Unlock()
+	if rejected != nil && rejected.Token != credentials.Token {
+		return &Credential{Token: credentials.Token}, nil
+	}
+	if credentials.RefreshToken == nil {
+		return nil, fmt.Errorf("令牌被服务器拒绝，且未设定刷新令牌的回调函数！")
+	}
+	token, e := credentials.RefreshToken(u)
+	if e != nil {
+		return nil, e
+	}
+	credentials.Token = token
+	return &Credential{Token: token}, nil
+}
+
+// 全局的认证信息提供者，为nil表示不进行认证，需持有 globalCredentialLock 访问
+var globalCredentialProvider CredentialProvider
+
+// 保护全局认证信息提供者的锁
+var globalCredentialLock sync.Mutex
+
+// ConfigSetCredentialProvider 设定全局的认证信息提供者，未单独设定认证信息提供者的下载任务都将使用该提供者
+//
+//   - provider 认证信息提供者，例如 CredentialRules 、 TokenCredentials 或者 LoadNetrc 的返回值，传入nil表示不进行认证
+func ConfigSetCredentialProvider(provider CredentialProvider) {
+	globalCredentialLock.Lock()
+	defer globalCredentialLock.Unlock()
+	globalCredentialProvider = provider
+}
+
+// 上下文中认证信息提供者的键类型
+type credentialProviderKey struct{}
+
+// 返回一个携带认证信息提供者的上下文，使用该上下文发送的请求将使用该提供者，而不是全局的提供者
+//
+//   - ctx 父上下文
+//   - provider 认证信息提供者，为nil时直接返回父上下文
+func withCredentialProvider(ctx context.Context, provider CredentialProvider) context.Context {
+	if provider == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, credentialProviderKey{}, provider)
+}
+
+// 从上下文中获取认证信息提供者，上下文未携带时使用全局的提供者
+func credentialProviderFrom(ctx context.Context) CredentialProvider {
+	if provider, ok := ctx.Value(credentialProviderKey{}).(CredentialProvider); ok {
+		return provider
+	}
+	globalCredentialLock.Lock()
+	defer globalCredentialLock.Unlock()
+	return globalCredentialProvider
+}
\ No newline at end of file
@@ -0,0 +1,226 @@
+package gopher_fetch
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试.netrc文件的解析与按主机匹配
+func TestLoadNetrc(t *testing.T) {
+	content := strings.Join([]string{
+		"# 注释",
+		"machine files.example.com login alice password \"p w\"",
+		"machine Other.Example.com",
+		"  login bob",
+		"  password secret",
+		"macdef init",
+		"machine macro.example.com login eve password evil",
+		"",
+		"default login anonymous password guest",
+	}, "\n")
+	file := filepath.Join(t.TempDir(), ".netrc")
+	if e := os.WriteFile(file, []byte(content), 0600); e != nil {
+		t.Fatal(e)
+	}
+	netrc, e := LoadNetrc(file)
+	if e != nil {
+		t.Fatal(e)
+	}
+	cases := map[string]Credential{
+		"https://files.example.com/a":      {Username: "alice", Password: "p w"},
+		"https://other.example.com:8443/a": {Username: "bob", Password: "secret"},
+		"https://macro.example.com/a":      {Username: "anonymous", Password: "guest"},
+		"https://unknown.example.com/a":    {Username: "anonymous", Password: "guest"},
+	}
+	for requestUrl, excepted := range cases {
+		u, _ := url.Parse(requestUrl)
+		credential, e := netrc.Credential(u)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if credential == nil || *credential != excepted {
+			t.Errorf("%s 的认证信息不正确：%+v，应为%+v", requestUrl, credential, excepted)
+		}
+	}
+	if e = os.WriteFile(file, []byte("login alice"), 0600); e != nil {
+		t.Fatal(e)
+	}
+	if _, e = LoadNetrc(file); e == nil {
+		t.Error("错误的netrc文件未返回错误！")
+	}
+}
+
+// 测试解析包含多个质询的 WWW-Authenticate 响应头
+func TestParseChallenges(t *testing.T) {
+	challenges := parseChallenges([]string{
+		`Basic realm="files", Digest realm="a \"b\"", qop="auth,auth-int", nonce=abc, algorithm=SHA-256`,
+		`Bearer`,
+	})
+	if len(challenges) != 3 {
+		t.Fatalf("质询数量不正确：%d", len(challenges))
+	}
+	if challenges[0].scheme != "basic" || challenges[0].params["realm"] != "files" {
+		t.Errorf("Basic质询解析不正确：%+v", challenges[0])
+	}
+	digest := challenges[1]
+	if digest.scheme != "digest" || digest.params["realm"] != `a "b"` || digest.params["qop"] != "auth,auth-int" || digest.params["nonce"] != "abc" || digest.params["algorithm"] != "SHA-256" {
+		t.Errorf("Digest质询解析不正确：%+v", digest)
+	}
+	if challenges[2].scheme != "bearer" {
+		t.Errorf("Bearer质询解析不正确：%+v", challenges[2])
+	}
+	if selectChallenge(challenges) != digest {
+		t.Error("未优先选择Digest质询！")
+	}
+}
+
+// 创建一个需要认证的测试文件服务器
+//
+//   - authorize 判断请求是否通过认证，未通过时返回 WWW-Authenticate 响应头
+//
+// 返回服务器对象、文件内容与收到401的次数
+func newAuthServer(t *testing.T, authorize func(request *http.Request) (bool, string)) (*httptest.Server, []byte, *atomic.Int32) {
+	unauthorized := &atomic.Int32{}
+	server, content := newWrappedFileServer(t, 256*1024, func(fileHandler http.Handler) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			if ok, challenge := authorize(request); !ok {
+				unauthorized.Add(1)
+				if challenge != "" {
+					writer.Header().Set("WWW-Authenticate", challenge)
+				}
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fileHandler.ServeHTTP(writer, request)
+		}
+	})
+	return server, content, unauthorized
+}
+
+// 测试使用Basic与Digest认证下载，收到质询后之后的请求直接发送认证信息
+func TestParallelGetTask_BasicDigestAuth(t *testing.T) {
+	md5Hex := func(text string) string {
+		digest := md5.Sum([]byte(text))
+		return hex.EncodeToString(digest[:])
+	}
+	const realm, nonce = "files", "n0nce"
+	authorizers := map[string]func(request *http.Request) (bool, string){
+		"basic": func(request *http.Request) (bool, string) {
+			username, password, ok := request.BasicAuth()
+			return ok && username == "gopher" && password == "p@ss", `Basic realm="files"`
+		},
+		"digest": func(request *http.Request) (bool, string) {
+			challenge := fmt.Sprintf(`Digest realm="%s", nonce="%s", qop="auth", opaque="op"`, realm, nonce)
+			header := request.Header.Get("Authorization")
+			if !strings.HasPrefix(header, "Digest ") {
+				return false, challenge
+			}
+			params := parseChallenges([]string{header})[0].params
+			ha1 := md5Hex("gopher:" + realm + ":p@ss")
+			ha2 := md5Hex(request.Method + ":" + request.URL.RequestURI())
+			excepted := md5Hex(strings.Join([]string{ha1, nonce, params["nc"], params["cnonce"], "auth", ha2}, ":"))
+			return params["username"] == "gopher" && params["uri"] == request.URL.RequestURI() && params["opaque"] == "op" && params["response"] == excepted, challenge
+		},
+	}
+	for scheme, authorize := range authorizers {
+		server, content, unauthorized := newAuthServer(t, authorize)
+		filePath := filepath.Join(t.TempDir(), "file.bin")
+		task := NewParallelGetTask(server.URL, filePath, "", 0, 4)
+		task.SetCredentialProvider(CredentialRules{
+			{Host: "other.example.com", Credential: &Credential{Username: "wrong", Password: "wrong"}},
+			{Host: "127.0.0.*", Credential: &Credential{Username: "gopher", Password: "p@ss"}},
+		})
+		if e := task.Run(); e != nil {
+			t.Fatal(e)
+		}
+		checkDownloadedFile(t, filePath, content)
+		if unauthorized.Load() != 1 {
+			t.Errorf("%s认证收到401的次数不正确：%d", scheme, unauthorized.Load())
+		}
+	}
+}
+
+// 测试下载过程中令牌过期时，刷新令牌并继续下载，多个分片同时过期时只刷新一次
+func TestParallelGetTask_TokenRefresh(t *testing.T) {
+	var requests atomic.Int32
+	server, content, unauthorized := newAuthServer(t, func(request *http.Request) (bool, string) {
+		// 前3个请求后，旧的令牌过期
+		valid := "Bearer t2"
+		if requests.Add(1) <= 3 {
+			valid = "Bearer t1"
+		}
+		return request.Header.Get("Authorization") == valid, `Bearer realm="files"`
+	})
+	var refreshCount atomic.Int32
+	credentials := &TokenCredentials{
+		Hosts: []string{"127.0.0.1"},
+		Token: "t1",
+		RefreshToken: func(u *url.URL) (string, error) {
+			refreshCount.Add(1)
+			time.Sleep(50 * time.Millisecond)
+			return "t2", nil
+		},
+	}
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewParallelGetTask(server.URL, filePath, "", 0, 8)
+	task.SetCredentialProvider(credentials)
+	if e := task.Run(); e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	if refreshCount.Load() != 1 {
+		t.Errorf("刷新令牌的次数不正确：%d", refreshCount.Load())
+	}
+	if unauthorized.Load() == 0 {
+		t.Error("令牌未过期！")
+	}
+	// 令牌被拒绝且无法刷新时返回错误
+	credentials.RefreshToken = func(u *url.URL) (string, error) {
+		return "", fmt.Errorf("无法刷新")
+	}
+	credentials.Token = "t3"
+	task = NewParallelGetTask(server.URL, filepath.Join(t.TempDir(), "file.bin"), "", 0, 2)
+	task.SetCredentialProvider(credentials)
+	if task.Run() == nil {
+		t.Error("无法刷新令牌时未返回错误！")
+	}
+}
+
+// 测试未指定适用主机的令牌只发送给原下载地址的主机，重定向至其它主机后不再发送
+func TestTokenCredentials_OriginHost(t *testing.T) {
+	var leaked atomic.Bool
+	target, content, _ := newAuthServer(t, func(request *http.Request) (bool, string) {
+		if request.Header.Get("Authorization") != "" {
+			leaked.Store(true)
+		}
+		return true, ""
+	})
+	origin := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer t1" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(writer, request, target.URL+request.URL.Path, http.StatusFound)
+	}))
+	t.Cleanup(origin.Close)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewParallelGetTask(origin.URL+"/file.bin", filePath, "", 0, 4)
+	task.SetCredentialProvider(&TokenCredentials{Token: "t1"})
+	if e := task.Run(); e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	if leaked.Load() {
+		t.Error("令牌被发送给了重定向后的其它主机！")
+	}
+}
\ No newline at end of file
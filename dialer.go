@@ -0,0 +1,225 @@
+package gopher_fetch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// IPPreference 建立连接时对IP协议版本的偏好
+type IPPreference int
+
+// IP协议版本偏好常量
+const (
+	// IPAuto 不限制IP协议版本，按照域名解析结果的顺序连接
+	IPAuto IPPreference = iota
+	// IPv4Only 只使用IPv4地址
+	IPv4Only
+	// IPv6Only 只使用IPv6地址
+	IPv6Only
+	// PreferIPv4 优先使用IPv4地址，连接失败时再尝试IPv6地址
+	PreferIPv4
+	// PreferIPv6 优先使用IPv6地址，连接失败时再尝试IPv4地址
+	PreferIPv6
+)
+
+// DialerConfig 建立连接的配置，零值表示使用系统默认配置
+type DialerConfig struct {
+	// 发起连接使用的本地IP地址，设定多个时每个新连接依次轮流使用，连接时只会使用与目标地址协议版本相同的本地地址
+	// 多线程下载时，不同分片的连接将使用不同的本地地址，可用于聚合多个网卡的带宽，此时应使用HTTP/1.1，使每个分片使用独立的连接
+	LocalAddresses []string
+	// 发起连接使用的网络接口名称，例如eth1，该接口的地址（不包括链路本地地址）会被加入 LocalAddresses
+	Interface string
+	// 对IP协议版本的偏好
+	IPPreference IPPreference
+	// 域名解析覆盖，格式同curl的--resolve参数：主机名:端口:地址[,地址]...，例如：example.com:443:127.0.0.1
+	// 端口为*表示该主机的全部端口，IPv6地址可以使用方括号包围
+	Resolve []string
+}
+
+// 建立连接的配置，以指针的形式保存在客户端配置中，使其可以比较
+type dialerSetting struct {
+	// 本地地址
+	localAddresses []net.IP
+	// IP协议版本偏好
+	preference IPPreference
+	// 域名解析覆盖，键为 主机名:端口 ，端口可以为*
+	resolve map[string][]net.IP
+	// 轮流使用本地地址的计数器
+	next atomic.Uint32
+}
+
+// 校验建立连接的配置，并创建对应的配置对象
+//
+//   - config 建立连接的配置
+func compileDialerConfig(config *DialerConfig) (*dialerSetting, error) {
+	if config.IPPreference < IPAuto || config.IPPreference > PreferIPv6 {
+		return nil, fmt.Errorf("不支持的IP协议版本偏好：%d", config.IPPreference)
+	}
+	setting := &dialerSetting{
+		preference: config.IPPreference,
+		resolve:    make(map[string][]net.IP),
+	}
+	for _, address := range config.LocalAddresses {
+		ip := net.ParseIP(strings.Trim(address, "[]"))
+		if ip == nil {
+			return nil, fmt.Errorf("无法解析本地地址%s！", address)
+		}
+		setting.localAddresses = append(setting.localAddresses, ip)
+	}
+	if config.Interface != "" {
+		networkInterface, e := net.InterfaceByName(config.Interface)
+		if e != nil {
+			return nil, fmt.Errorf("找不到网络接口%s！%w", config.Interface, e)
+		}
+		addresses, e := networkInterface.Addrs()
+		if e != nil {
+			return nil, fmt.Errorf("无法获取网络接口%s的地址！%w", config.Interface, e)
+		}
+		count := len(setting.localAddresses)
+		for _, address := range addresses {
+			if network, ok := address.(*net.IPNet); ok && !network.IP.IsLinkLocalUnicast() {
+				setting.localAddresses = append(setting.localAddresses, network.IP)
+			}
+		}
+		if len(setting.localAddresses) == count {
+			return nil, fmt.Errorf("网络接口%s没有可用的地址！", config.Interface)
+		}
+	}
+	for _, entry := range config.Resolve {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("域名解析覆盖%s格式错误，应为：主机名:端口:地址[,地址]...", entry)
+		}
+		if _, e := strconv.ParseUint(parts[1], 10, 16); e != nil && parts[1] != "*" {
+			return nil, fmt.Errorf("域名解析覆盖%s的端口%s格式错误！", entry, parts[1])
+		}
+		key := net.JoinHostPort(strings.ToLower(parts[0]), parts[1])
+		for _, address := range strings.Split(parts[2], ",") {
+			ip := net.ParseIP(strings.Trim(strings.TrimSpace(address), "[]"))
+			if ip == nil {
+				return nil, fmt.Errorf("域名解析覆盖%s的地址%s格式错误！", entry, address)
+			}
+			setting.resolve[key] = append(setting.resolve[key], ip)
+		}
+	}
+	return setting, nil
+}
+
+// 获取主机的全部地址，并按照IP协议版本偏好过滤与排序
+//
+//   - ctx 连接的上下文
+//   - host 主机名或者IP地址
+//   - port 端口
+func (setting *dialerSetting) lookup(ctx context.Context, host, port string) ([]net.IP, error) {
+	var ips []net.IP
+	if overridden, ok := setting.resolve[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+		ips = overridden
+	} else if overridden, ok = setting.resolve[net.JoinHostPort(strings.ToLower(host), "*")]; ok {
+		ips = overridden
+	} else if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addresses, e := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if e != nil {
+			return nil, e
+		}
+		for _, address := range addresses {
+			ips = append(ips, address.IP)
+		}
+	}
+	result := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		isIPv4 := ip.To4() != nil
+		if (setting.preference == IPv4Only && !isIPv4) || (setting.preference == IPv6Only && isIPv4) {
+			continue
+		}
+		result = append(result, ip)
+	}
+	if setting.preference == PreferIPv4 || setting.preference == PreferIPv6 {
+		sort.SliceStable(result, func(i, j int) bool {
+			return (result[i].To4() != nil) == (setting.preference == PreferIPv4) && (result[j].To4() != nil) != (setting.preference == PreferIPv4)
+		})
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("主机%s没有符合IP协议版本偏好的地址！", host)
+	}
+	return result, nil
+}
+
+// 为连接至目标地址选择本地地址，未设定本地地址时返回nil
+//
+//   - target 目标地址
+//
+// 返回选择的本地地址，以及是否存在可用的本地地址
+func (setting *dialerSetting) localAddress(target net.IP) (*net.TCPAddr, bool) {
+	if len(setting.localAddresses) == 0 {
+		return nil, true
+	}
+	var candidates []net.IP
+	for _, ip := range setting.localAddresses {
+		if (ip.To4() != nil) == (target.To4() != nil) {
+			candidates = append(candidates, ip)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	index := int((setting.next.Add(1) - 1) % uint32(len(candidates)))
+	return &net.TCPAddr{IP: candidates[index]}, true
+}
+
+// 返回按照该配置建立连接的函数，用于 http.Transport 的 DialContext
+//
+//   - dialer 基础的连接对象，其超时等配置会被沿用
+func (setting *dialerSetting) dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, e := net.SplitHostPort(address)
+		if e != nil {
+			return nil, e
+		}
+		ips, e := setting.lookup(ctx, host, port)
+		if e != nil {
+			return nil, e
+		}
+		// 依次尝试每个地址，返回第一个成功的连接
+		var lastError error
+		for _, ip := range ips {
+			localAddress, ok := setting.localAddress(ip)
+			if !ok {
+				lastError = fmt.Errorf("没有可用于连接%s的本地地址！", ip)
+				continue
+			}
+			ipDialer := *dialer
+			if localAddress != nil {
+				ipDialer.LocalAddr = localAddress
+			}
+			conn, e := ipDialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if e == nil {
+				return conn, nil
+			}
+			lastError = e
+		}
+		return nil, lastError
+	}
+}
+
+// ConfigSetDialer 设定全局的建立连接配置，配置有误时返回错误且不修改当前配置
+//
+//   - config 建立连接的配置，传入nil表示恢复系统默认配置
+func ConfigSetDialer(config *DialerConfig) error {
+	if config == nil {
+		globalClient.setDialer(nil)
+		return nil
+	}
+	setting, e := compileDialerConfig(config)
+	if e != nil {
+		return e
+	}
+	globalClient.setDialer(setting)
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,120 @@
+package gopher_fetch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试建立连接配置的校验、域名解析覆盖与IP协议版本偏好
+func TestCompileDialerConfig(t *testing.T) {
+	setting, e := compileDialerConfig(&DialerConfig{
+		IPPreference: PreferIPv6,
+		Resolve: []string{
+			"Files.Example:443:127.0.0.1,[::1]",
+			"files.example:*:10.0.0.1",
+		},
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	ips, e := setting.lookup(context.Background(), "files.example", "443")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(ips) != 2 || !ips[0].Equal(net.ParseIP("::1")) || !ips[1].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("域名解析覆盖或IP协议版本偏好不正确：%v", ips)
+	}
+	ips, e = setting.lookup(context.Background(), "files.example", "80")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("全部端口的域名解析覆盖不正确：%v", ips)
+	}
+	setting.preference = IPv6Only
+	if _, e = setting.lookup(context.Background(), "files.example", "80"); e == nil {
+		t.Error("没有符合偏好的地址时未返回错误！")
+	}
+	// 错误的配置
+	invalid := []*DialerConfig{
+		{LocalAddresses: []string{"not-an-ip"}},
+		{Interface: "gopher-fetch-missing0"},
+		{IPPreference: PreferIPv6 + 1},
+		{Resolve: []string{"files.example:443"}},
+		{Resolve: []string{"files.example:https:127.0.0.1"}},
+		{Resolve: []string{"files.example:443:localhost"}},
+		{Resolve: []string{":443:127.0.0.1"}},
+	}
+	for _, config := range invalid {
+		if _, e = compileDialerConfig(config); e == nil {
+			t.Errorf("错误的配置未返回错误：%+v", config)
+		}
+		if ConfigSetDialer(config) == nil {
+			t.Errorf("错误的全局配置未返回错误：%+v", config)
+		}
+	}
+}
+
+// 测试通过域名解析覆盖访问，并将分片的连接分散到多个本地地址
+func TestParallelGetTask_Dialer(t *testing.T) {
+	disableKeepAlives := GlobalConfig.DisableKeepAlives
+	GlobalConfig.DisableKeepAlives = true
+	defer func() {
+		GlobalConfig.DisableKeepAlives = disableKeepAlives
+	}()
+	// 检查是否可以使用第二个回环地址
+	localAddresses := []string{"127.0.0.1"}
+	if listener, e := net.Listen("tcp", "127.0.0.2:0"); e == nil {
+		_ = listener.Close()
+		localAddresses = append(localAddresses, "127.0.0.2")
+	}
+	remoteAddresses := make(map[string]bool)
+	var lock sync.Mutex
+	fileServer, content := newTestFileServer(t, 256*1024)
+	fileUrl, _ := url.Parse(fileServer.URL)
+	// 记录每个请求的来源地址后，转发至文件服务器
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		host, _, _ := net.SplitHostPort(request.RemoteAddr)
+		lock.Lock()
+		remoteAddresses[host] = true
+		lock.Unlock()
+		httputil.NewSingleHostReverseProxy(fileUrl).ServeHTTP(writer, request)
+	}))
+	t.Cleanup(server.Close)
+	serverUrl, _ := url.Parse(server.URL)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	// 该域名无法在本地解析，只能通过解析覆盖连接
+	task := NewAdaptiveParallelGetTask("http://files.gopher-fetch.test:"+serverUrl.Port()+"/file.bin", filePath, "", 0, 1, 4)
+	task.Adaptive.Interval = time.Hour
+	e := task.SetDialer(&DialerConfig{
+		LocalAddresses: localAddresses,
+		IPPreference:   IPv4Only,
+		Resolve:        []string{"files.gopher-fetch.test:" + serverUrl.Port() + ":127.0.0.1"},
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	e = task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	lock.Lock()
+	defer lock.Unlock()
+	for _, address := range localAddresses {
+		if !remoteAddresses[address] {
+			t.Errorf("未使用本地地址%s建立连接：%v", address, remoteAddresses)
+		}
+	}
+	if len(remoteAddresses) != len(localAddresses) {
+		t.Errorf("使用了未设定的本地地址：%v", remoteAddresses)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,288 @@
+package gopher_fetch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 自动选择下载方式时的默认最大并发数
+const defaultDownloadConcurrent = 8
+
+// 自动选择下载方式时，默认的每个分片的最小大小（字节）
+const defaultMinShardSize int64 = 1024 * 1024
+
+// DownloadMode 自动选择的下载方式
+type DownloadMode int
+
+// 下载方式常量
+const (
+	// ModeParallel 多线程分片下载
+	ModeParallel DownloadMode = iota + 1
+	// ModeMonoResumable 可断点续传的单线程下载
+	ModeMonoResumable
+	// ModeMonoNonResumable 不可断点续传的单线程下载
+	ModeMonoNonResumable
+)
+
+// String 返回下载方式的名称
+func (mode DownloadMode) String() string {
+	switch mode {
+	case ModeParallel:
+		return "parallel"
+	case ModeMonoResumable:
+		return "mono-resumable"
+	case ModeMonoNonResumable:
+		return "mono-non-resumable"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(mode))
+	}
+}
+
+// DownloadOptions 自动选择下载方式时的下载选项，零值字段均使用默认值
+type DownloadOptions struct {
+	// 最大下载并发数，默认为8
+	Concurrent int
+	// 自适应并发的最小并发数，大于0时多线程下载将启用自适应并发，以该值作为初始并发数，根据吞吐量增加至最多 Concurrent 个并发
+	MinConcurrent int
+	// 每个分片的最小大小（字节），文件较小时会减少并发数，使每个分片都不小于该大小，默认为1MB
+	MinShardSize int64
+	// 分片请求时间间隔，若设为0则开始下载时所有分片同时开始请求
+	ShardStartDelay time.Duration
+	// 下载进度文件的保存位置，默认为下载文件所在目录下的"文件名.process.json"
+	ProcessFile string
+	// 是否不保存进度文件，设为true时下载中断后无法恢复
+	DisableProcessFile bool
+	// 保存进度的进度存储，为nil时使用全局的默认进度存储
+	ProgressStore ProgressStore
+	// 该任务的TLS配置，为nil时使用全局TLS配置，探测服务器时同样使用该配置
+	TLS *TLSConfig
+	// 该任务的代理服务器配置，为nil时使用全局代理配置，探测服务器时同样使用该配置
+	Proxy *ProxyConfig
+	// 该任务使用的代理池，设定后每个请求都会从代理池选择代理服务器，优先于 Proxy
+	ProxyPool *ProxyPool
+	// 该任务建立连接的配置，为nil时使用全局配置，探测服务器时同样使用该配置
+	Dialer *DialerConfig
+	// 该任务的Cookie存储，为nil时使用全局Cookie存储，探测服务器时同样使用该存储
+	CookieJar http.CookieJar
+	// 该任务的认证信息提供者，为nil时使用全局的认证信息提供者，探测服务器时同样使用该提供者
+	Credentials CredentialProvider
+	// 刷新下载地址的回调函数，为nil表示不刷新，参考 ParallelGetTask 的 SetUrlRefresher 方法
+	UrlRefresher UrlRefresher
+	// 下载地址的过期时间，零值表示未知
+	UrlExpiresAt time.Time
+	// 是否以时间戳模式下载，参考 ParallelGetTask 的 SetTimestamping 方法，启用后探测服务器时同样发送条件请求
+	Timestamping bool
+	// 该任务的日志输出，为nil时使用全局日志输出
+	Logger Logger
+	// 下载状态的回调函数，可以为nil，参考 ParallelGetTask 的 SubscribeStatus 方法
+	StatusLookup func(status *TaskStatus)
+	// 生命周期事件的回调函数，可以为nil，参考 ParallelGetTask 的 SubscribeEvent 方法
+	EventLookup func(event TaskEvent)
+}
+
+// 返回填充了默认值的下载选项副本
+func (options *DownloadOptions) withDefaults(filePath string) *DownloadOptions {
+	result := &DownloadOptions{}
+	if options != nil {
+		*result = *options
+	}
+	if result.Concurrent <= 0 {
+		result.Concurrent = defaultDownloadConcurrent
+	}
+	if result.MinShardSize <= 0 {
+		result.MinShardSize = defaultMinShardSize
+	}
+	if result.ProgressStore == nil {
+		result.ProgressStore = globalProgressStore
+	}
+	if result.DisableProcessFile {
+		result.ProcessFile = ""
+	} else if result.ProcessFile == "" {
+		result.ProcessFile = fmt.Sprintf("%s.process.json", filePath)
+	}
+	return result
+}
+
+// 根据文件大小计算下载并发数，保证每个分片都不小于最小分片大小
+//
+//   - totalSize 下载文件的总大小（字节）
+//   - minShardSize 每个分片的最小大小（字节）
+//   - maxConcurrent 最大下载并发数
+func computeConcurrent(totalSize, minShardSize int64, maxConcurrent int) int {
+	concurrent := totalSize / minShardSize
+	if concurrent > int64(maxConcurrent) {
+		return maxConcurrent
+	}
+	if concurrent < 1 {
+		return 1
+	}
+	return int(concurrent)
+}
+
+// NewAutoTask 探测服务器并自动选择下载方式，创建对应的下载任务，但不开始下载
+//
+// 若已存在该下载文件保存的进度，则直接恢复该任务，否则：
+//   - 服务器支持分片获取时，根据文件大小计算并发数，并发数大于1时使用多线程下载，否则使用可断点续传的单线程下载
+//   - 服务器支持分片获取但未给出文件大小时，使用可断点续传的单线程下载
+//   - 服务器不支持分片获取时，使用不可断点续传的单线程下载，此时不会保存进度文件
+//
+// 以时间戳模式下载且服务器上的文件未修改时，不创建任务，返回 ErrNotModified
+//
+// 参数：
+//   - url 下载地址
+//   - filePath 下载文件的保存路径
+//   - options 下载选项，传入nil表示全部使用默认值
+//
+// 返回的任务对象可通过类型断言转换为 *ParallelGetTask 或者 *MonoGetTask
+func NewAutoTask(url, filePath string, options *DownloadOptions) (Task, error) {
+	options = options.withDefaults(filePath)
+	log := logger.with(fieldUrl(url))
+	if options.Logger != nil {
+		log = &fetchLogger{target: options.Logger, fields: []LogField{fieldUrl(url)}}
+	}
+	// 按照下载选项创建http请求客户端，探测服务器与下载使用同一个客户端
+	provider, e := options.clientProvider(log)
+	if e != nil {
+		return nil, e
+	}
+	// 存在已保存的进度，则恢复任务
+	if options.ProcessFile != "" {
+		if _, e := options.ProgressStore.Load(options.ProcessFile); e == nil {
+			task, e := loadTask(options.ProgressStore, options.ProcessFile)
+			if e == nil {
+				log.info(msgStrategySelected, fieldReason(msgStrategyProgressFound), fieldPath(options.ProcessFile))
+				// 进度文件中的地址可能已过期，使用传入的地址继续下载
+				task.SetUrl(url, options.UrlExpiresAt)
+				return options.setup(task, provider), nil
+			}
+			log.warn(msgSkipProcessFile, fieldPath(options.ProcessFile), fieldError(e))
+		}
+	}
+	// 探测服务器，下载地址失效时刷新地址
+	ctx := withCredentialProvider(withProxyPool(withClientProvider(context.Background(), provider), options.ProxyPool), options.Credentials)
+	var conditional *conditionalProbe
+	if options.Timestamping {
+		conditional = &conditionalProbe{stored: loadFileValidators(filePath, url)}
+		ctx = withConditionalProbe(ctx, conditional)
+	}
+	// 服务器上的文件未修改时返回 ErrNotModified ，不创建任务
+	probeUrl, expiresAt, length, supportRange, resolved, e := probeWithUrlRefresh(ctx, url, options.UrlRefresher, log)
+	if e != nil {
+		return nil, e
+	}
+	if probeUrl != url {
+		url, options.UrlExpiresAt = probeUrl, expiresAt
+	}
+	var task configurableTask
+	var mode DownloadMode
+	var reason messageKey
+	concurrent := 1
+	if !supportRange {
+		mode, reason = ModeMonoNonResumable, msgStrategyRangeUnsupported
+		task = NewMonoGetTask(url, filePath, "")
+	} else if length < 0 {
+		mode, reason = ModeMonoResumable, msgStrategyUnknownLength
+		task = NewMonoGetTask(url, filePath, options.ProcessFile)
+	} else if concurrent = computeConcurrent(length, options.MinShardSize, options.Concurrent); concurrent <= 1 {
+		mode, reason = ModeMonoResumable, msgStrategySmallFile
+		task = NewMonoGetTask(url, filePath, options.ProcessFile)
+	} else {
+		mode, reason = ModeParallel, msgStrategyRangeSupported
+		if options.MinConcurrent > 0 && options.MinConcurrent < concurrent {
+			task = NewAdaptiveParallelGetTask(url, filePath, options.ProcessFile, options.ShardStartDelay, options.MinConcurrent, concurrent)
+		} else {
+			task = NewParallelGetTask(url, filePath, options.ProcessFile, options.ShardStartDelay, concurrent)
+		}
+	}
+	log.info(msgStrategySelected, field("mode", mode), fieldReason(reason), field("size", length), field("concurrent", concurrent))
+	// 任务运行时直接使用探测结果，并请求重定向后的地址
+	if receiver, ok := task.(probeReceiver); ok {
+		receiver.useProbeResult(&probeResult{length: length, supportRange: supportRange, resolved: resolved, conditional: conditional})
+	}
+	return options.setup(task, provider), nil
+}
+
+// 按照下载选项的TLS、代理服务器、建立连接与Cookie存储配置创建http请求客户端提供者，均未设定时返回nil，表示使用全局客户端
+//
+//   - log 输出日志的日志对象
+func (options *DownloadOptions) clientProvider(log *fetchLogger) (*clientProvider, error) {
+	if options.TLS == nil && options.Proxy == nil && options.Dialer == nil && options.CookieJar == nil {
+		return nil, nil
+	}
+	provider := &clientProvider{parent: globalClient}
+	if options.TLS != nil {
+		tlsConfig, e := buildTLSConfig(options.TLS, log)
+		if e != nil {
+			return nil, e
+		}
+		provider.tlsConfig = tlsConfig
+	}
+	if options.Proxy != nil {
+		proxy, e := compileProxyConfig(options.Proxy)
+		if e != nil {
+			return nil, e
+		}
+		provider.proxy = proxy
+	}
+	if options.Dialer != nil {
+		dialer, e := compileDialerConfig(options.Dialer)
+		if e != nil {
+			return nil, e
+		}
+		provider.dialer = dialer
+	}
+	provider.cookieJar = newCookieJarSetting(options.CookieJar)
+	return provider, nil
+}
+
+// 可以使用已创建的http请求客户端提供者的任务，两种下载任务均满足
+type clientProviderUser interface {
+	useClientProvider(provider *clientProvider)
+}
+
+// 可以使用自动选择下载方式时探测服务器结果的任务，两种下载任务均满足
+type probeReceiver interface {
+	useProbeResult(result *probeResult)
+}
+
+// 按照下载选项设定任务的进度存储、http请求客户端、代理池、认证信息提供者、下载地址刷新、时间戳模式、日志输出与回调函数
+//
+//   - task 下载任务
+//   - provider 探测服务器时使用的http请求客户端提供者，为nil表示使用全局客户端
+func (options *DownloadOptions) setup(task configurableTask, provider *clientProvider) Task {
+	task.SetProgressStore(options.ProgressStore)
+	if user, ok := task.(clientProviderUser); ok && provider != nil {
+		user.useClientProvider(provider)
+	}
+	task.SetProxyPool(options.ProxyPool)
+	task.SetCredentialProvider(options.Credentials)
+	task.SetUrlRefresher(options.UrlRefresher, options.UrlExpiresAt)
+	task.SetTimestamping(options.Timestamping)
+	if options.Logger != nil {
+		task.SetLogger(options.Logger)
+	}
+	if options.StatusLookup != nil {
+		task.SubscribeStatus(options.StatusLookup)
+	}
+	if options.EventLookup != nil {
+		task.SubscribeEvent(options.EventLookup)
+	}
+	return task
+}
+
+// Download 探测服务器并自动选择下载方式，然后执行下载，选择规则参考 NewAutoTask 函数
+//
+//   - url 下载地址
+//   - filePath 下载文件的保存路径
+//   - options 下载选项，传入nil表示全部使用默认值
+//
+// 返回执行下载的任务对象，可用于下载完成后校验文件，出现错误时返回错误对象，以时间戳模式下载且服务器上的文件未修改时返回 ErrNotModified
+func Download(url, filePath string, options *DownloadOptions) (Task, error) {
+	task, e := NewAutoTask(url, filePath, options)
+	if e != nil {
+		return nil, e
+	}
+	return task, task.Run()
+}
\ No newline at end of file
@@ -0,0 +1,85 @@
+package gopher_fetch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// 测试根据服务器与文件大小自动选择下载方式
+func TestDownload(t *testing.T) {
+	largeServer, largeContent := newTestFileServer(t, 3*1024*1024+5)
+	smallServer, smallContent := newTestFileServer(t, 4096)
+	// 不支持分片获取的服务器
+	noRangeContent := smallContent
+	noRangeServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Length", strconv.Itoa(len(noRangeContent)))
+		if request.Method == http.MethodGet {
+			_, _ = writer.Write(noRangeContent)
+		}
+	}))
+	t.Cleanup(noRangeServer.Close)
+	cases := []struct {
+		name       string
+		url        string
+		content    []byte
+		concurrent int
+		process    bool
+	}{
+		{"parallel", largeServer.URL, largeContent, 3, true},
+		{"monoResumable", smallServer.URL, smallContent, 0, true},
+		{"monoNonResumable", noRangeServer.URL, noRangeContent, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "file.bin")
+			task, e := NewAutoTask(c.url, filePath, &DownloadOptions{Concurrent: 4})
+			if e != nil {
+				t.Fatal(e)
+			}
+			switch value := task.(type) {
+			case *ParallelGetTask:
+				if value.Concurrent != c.concurrent {
+					t.Errorf("并发数不正确：%d", value.Concurrent)
+				}
+			case *MonoGetTask:
+				if c.concurrent != 0 || (value.processFile != "") != c.process {
+					t.Errorf("下载方式不正确：%+v", value.snapshot())
+				}
+			}
+			e = task.Run()
+			if e != nil {
+				t.Fatal(e)
+			}
+			checkDownloadedFile(t, filePath, c.content)
+		})
+	}
+}
+
+// 测试自动选择下载方式时只探测一次服务器，任务运行时直接使用探测结果
+func TestDownload_ProbeOnce(t *testing.T) {
+	for _, size := range []int{4096, 3*1024*1024 + 5} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			var headCount atomic.Int32
+			fileServer, content := newWrappedFileServer(t, size, func(fileHandler http.Handler) http.HandlerFunc {
+				return func(writer http.ResponseWriter, request *http.Request) {
+					if request.Method == http.MethodHead {
+						headCount.Add(1)
+					}
+					fileHandler.ServeHTTP(writer, request)
+				}
+			})
+			filePath := filepath.Join(t.TempDir(), "file.bin")
+			if _, e := Download(fileServer.URL, filePath, &DownloadOptions{Concurrent: 4}); e != nil {
+				t.Fatal(e)
+			}
+			if count := headCount.Load(); count != 1 {
+				t.Errorf("探测服务器的次数不正确：%d", count)
+			}
+			checkDownloadedFile(t, filePath, content)
+		})
+	}
+}
\ No newline at end of file
@@ -1,6 +1,107 @@
 package gopher_fetch
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// StatusCodeError 服务器返回了表示失败的状态码，可通过 errors.As 从下载任务返回的错误中获取
+type StatusCodeError struct {
+	// 响应状态码
+	StatusCode int
+	// 服务器通过 Retry-After 响应头要求的等待时间，未给出时为0
+	RetryAfter time.Duration
+}
+
+// 实现error接口
+func (e *StatusCodeError) Error() string {
+	return fmt.Sprintf("状态码错误：%d", e.StatusCode)
+}
+
+// RangeMismatchError 分片请求的响应不是请求的范围，例如服务器或者代理忽略了Range请求头而返回了完整的文件，属于可重试的错误，可通过 errors.As 从下载任务返回的错误中获取
+type RangeMismatchError struct {
+	// 请求的起始位置
+	Start int64
+	// 请求的终止位置，-1表示直到文件末尾
+	End int64
+	// 响应状态码
+	StatusCode int
+	// 响应的 Content-Range 响应头
+	ContentRange string
+}
+
+// 实现error接口
+func (e *RangeMismatchError) Error() string {
+	return fmt.Sprintf("响应范围不正确！请求范围：%d-%d，状态码：%d，Content-Range：%s", e.Start, e.End, e.StatusCode, e.ContentRange)
+}
+
+// TimeoutPhase 发生超时的请求阶段
+type TimeoutPhase string
+
+// 请求阶段常量
+const (
+	// TimeoutDial 建立TCP连接
+	TimeoutDial TimeoutPhase = "dial"
+	// TimeoutTLSHandshake TLS握手
+	TimeoutTLSHandshake TimeoutPhase = "tlsHandshake"
+	// TimeoutResponseHeader 等待响应头
+	TimeoutResponseHeader TimeoutPhase = "responseHeader"
+	// TimeoutProbe 下载前探测服务器
+	TimeoutProbe TimeoutPhase = "probe"
+)
+
+// TimeoutError 请求在某个阶段超时，属于可重试的错误，可通过 errors.As 从下载任务返回的错误中获取
+// 读取响应体时的超时属于连接停滞，参考 StallError
+type TimeoutError struct {
+	// 发生超时的阶段
+	Phase TimeoutPhase
+	// 该阶段配置的超时时间
+	Timeout time.Duration
+	// 实际发生的错误
+	Err error
+}
+
+// 实现error接口
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("请求超时！阶段：%s，超时时间：%s，%s", e.Phase, e.Timeout, e.Err)
+}
+
+// Unwrap 返回实际发生的错误
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// 判断一个错误是否表示服务器正在限流或者过载，即状态码为429、503或者连接被重置
+//
+//   - e 判断的错误
+func isThrottleError(e error) bool {
+	var statusError *StatusCodeError
+	if errors.As(e, &statusError) {
+		return statusError.StatusCode == http.StatusTooManyRequests || statusError.StatusCode == http.StatusServiceUnavailable
+	}
+	return errors.Is(e, syscall.ECONNRESET)
+}
+
+// 根据响应创建状态码错误对象，并解析 Retry-After 响应头，该响应头可以是秒数或者HTTP日期
+//
+//   - response 表示失败的响应
+func newStatusCodeError(response *http.Response) *StatusCodeError {
+	e := &StatusCodeError{StatusCode: response.StatusCode}
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return e
+	}
+	if seconds, parseError := strconv.Atoi(value); parseError == nil {
+		e.RetryAfter = time.Duration(max(seconds, 0)) * time.Second
+	} else if date, parseError := http.ParseTime(value); parseError == nil {
+		e.RetryAfter = max(time.Until(date), 0)
+	}
+	return e
+}
 
 // 自定义可重试的错误类型
 type retryError struct {
@@ -10,41 +111,56 @@ type retryError struct {
 	// 下次重试的次数
 	retryCount int
 	// 错误原因
-	reason string
+	reason messageKey
+	// 引发重试的实际错误
+	cause error
 }
 
-// 表示可重试错误对象类型常量
-var retryErrorType *retryError
+// 判断一个错误是否为可重试错误，每次判断使用独立的目标变量，可被多个分片并发调用
+//
+//   - e 判断的错误
+func isRetryError(e error) bool {
+	var target *retryError
+	return errors.As(e, &target)
+}
 
 // 实现error接口
 func (e *retryError) Error() string {
-	message := fmt.Sprintf("原因：%s，将进行第%d次重试...", e.reason, e.retryCount)
 	if e.order > 0 {
-		return fmt.Sprintf("分片%d出现错误！%s", e.order, message)
+		return msgFormatShardRetryError.format(e.order, e.reason.text(), e.retryCount)
 	}
-	return fmt.Sprintf("单线程下载任务出现错误！%s", message)
+	return msgFormatMonoRetryError.format(e.reason.text(), e.retryCount)
+}
+
+// Unwrap 返回引发重试的实际错误
+func (e *retryError) Unwrap() error {
+	return e.cause
 }
 
 // 创建一个用于分片任务的重试错误对象
 //
 //   - task 分片任务对象
-//   - message 重试原因
-func createShardRetryError(task *shardTask, message string) error {
+//   - reason 重试原因
+//   - cause 实际发生的错误
+func createShardRetryError(task *shardTask, reason messageKey, cause error) error {
 	return &retryError{
 		order:      task.Config.Order,
-		retryCount: task.Status.retryCount,
-		reason:     message,
+		retryCount: int(task.Status.retryCount.Load()),
+		reason:     reason,
+		cause:      cause,
 	}
 }
 
 // 创建一个用于单线程任务的重试错误对象
 //
 //   - task 单线程任务对象
-//   - message 重试原因
-func createMonoRetryError(task *MonoGetTask, message string) error {
+//   - reason 重试原因
+//   - cause 实际发生的错误
+func createMonoRetryError(task *MonoGetTask, reason messageKey, cause error) error {
 	return &retryError{
 		order:      0,
 		retryCount: task.retryCount,
-		reason:     message,
+		reason:     reason,
+		cause:      cause,
 	}
 }
\ No newline at end of file
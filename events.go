@@ -17,41 +17,130 @@ const (
 
 // TaskStatus 表示一个时刻的多线程下载任务的任务状态
 type TaskStatus struct {
-	// 下载文件的总大小（字节）
+	// 下载文件的总大小（字节），为-1表示服务器未给出大小，此时只能得知已下载大小
 	TotalSize int64
 	// 已下载大小（字节）
 	DownloadSize int64
-	// 当前实际并发数
+	// 当前实际并发数，多线程任务启用自适应并发时为当前的并发上限
 	Concurrency int
-	// 当前下载速度，单位：字节/毫秒
+	// 当前下载速度，经过滑动窗口与指数移动平均平滑，单位：字节/毫秒
 	Speed float64
+	// 自订阅状态以来的平均下载速度，单位：字节/毫秒
+	AverageSpeed float64
+	// 根据当前下载速度估算的剩余下载时间，仅在 RemainTimeKnown 为true时有效
+	RemainTime time.Duration
+	// 剩余下载时间是否可以估算，下载速度为0或者文件大小未知时为false
+	RemainTimeKnown bool
 	// 当前下载任务是否被终止或者结束
 	IsShutdown bool
+	// 每个分片的下载状态，按分片序号排列，仅多线程下载任务有效，单线程下载任务为nil
+	Shards []ShardStatus
+	// 自任务创建以来的连接统计
+	Connections ConnectionStats
+}
+
+// ShardState 分片下载状态
+type ShardState int
+
+// 分片下载状态常量
+const (
+	// ShardPending 等待下载
+	ShardPending ShardState = iota
+	// ShardRunning 正在下载
+	ShardRunning
+	// ShardRetrying 出现错误，等待重试
+	ShardRetrying
+	// ShardDone 下载完成
+	ShardDone
+	// ShardFailed 超过最大重试次数，下载失败
+	ShardFailed
+)
+
+// ShardStatus 表示一个时刻的单个分片的下载状态
+type ShardStatus struct {
+	// 分片序号，从1开始
+	Order int
+	// 分片的起始范围（字节，包含）
+	RangeStart int64
+	// 分片的结束范围（字节，包含）
+	RangeEnd int64
+	// 该分片已下载大小（字节）
+	DownloadSize int64
+	// 该分片的下载状态
+	State ShardState
+	// 该分片已重试的次数
+	RetryCount int
+	// 该分片当前下载速度，单位：字节/毫秒
+	Speed float64
 }
 
 // 发布一个 ParallelGetTask 分片下载任务的当前状态，通知其所有的观察者
+// 距上次发布不足 FetchConfig.StatusNotifyDuration 时不会构造状态对象，避免每次下载数据量变化时都分配内存
 //
 //   - task 对应的下载任务
 //   - shutdown 该下载任务是否已经结束或者被中断
 func publishParallelTaskStatus(task *ParallelGetTask, shutdown bool) {
+	task.publishLock.Lock()
+	defer task.publishLock.Unlock()
+	// 结束状态发布后，不再发布滞后的状态
+	if task.shutdownPublished {
+		return
+	}
+	now := time.Now()
+	elapsed := now.Sub(task.lastPublishTime)
+	if !shutdown && elapsed < GlobalConfig.StatusNotifyDuration {
+		return
+	}
+	task.lastPublishTime = now
+	task.shutdownPublished = shutdown
+	// 构造每个分片的状态，并计算距上次发布以来的分片速度
+	shards := task.shardStatus()
+	for i, shard := range task.ShardList {
+		shardSize := shards[i].DownloadSize
+		if elapsed > 0 {
+			shards[i].Speed = float64(shardSize-shard.Status.lastNotifySize) / (float64(elapsed) / float64(time.Millisecond))
+		}
+		shard.Status.lastNotifySize = shardSize
+	}
 	task.statusSubject.UpdateAndNotify(&TaskStatus{
-		TotalSize:    task.TotalSize,
-		DownloadSize: task.DownloadSize,
-		Concurrency:  task.concurrentTaskCount,
+		TotalSize:    task.totalSize(),
+		DownloadSize: task.downloadSize.Load(),
+		Concurrency:  task.concurrency(),
 		IsShutdown:   shutdown,
+		Shards:       shards,
+		Connections:  task.connections.stats(),
 	}, false)
 }
 
+// 获取全部分片的当前状态，不包括分片速度
+func (task *ParallelGetTask) shardStatus() []ShardStatus {
+	task.shardLock.Lock()
+	defer task.shardLock.Unlock()
+	shards := make([]ShardStatus, len(task.ShardList))
+	for i, shard := range task.ShardList {
+		shards[i] = ShardStatus{
+			Order:        shard.Config.Order,
+			RangeStart:   shard.Config.RangeStart,
+			RangeEnd:     shard.Config.RangeEnd,
+			DownloadSize: shard.Status.downloadSize.Load(),
+			State:        shard.Status.getState(),
+			RetryCount:   int(shard.Status.retryCount.Load()),
+		}
+	}
+	return shards
+}
+
 // 发布一个 MonoGetTask 单线程下载任务的当前状态，通知其所有观察者
 //
 //   - task 对应的单线程下载任务
 //   - shutdown 该下载任务是否已经结束
 func publishMonoTaskStatus(task *MonoGetTask, shutdown bool) {
 	task.statusSubject.UpdateAndNotify(&TaskStatus{
-		TotalSize:    task.TotalSize,
-		DownloadSize: task.DownloadSize,
+		TotalSize:    task.totalSize(),
+		DownloadSize: task.downloadSize.Load(),
 		Concurrency:  1,
 		IsShutdown:   shutdown,
+		Connections:  task.connections.stats(),
 	}, false)
 }
 
@@ -62,9 +151,8 @@ type sizeChangeSubscriber struct {
 }
 
 // OnSubscribe 下载数据量新增时的自定义事件处理
+// 已下载大小已由分片协程原子地累加，此处只负责发布状态
 func (subscriber *sizeChangeSubscriber) OnSubscribe(e *gopher_notify.Event[string, int64]) {
-	// 改变多线程任务状态
-	subscriber.task.DownloadSize += e.GetData()
 	// 发布多线程任务状态
 	publishParallelTaskStatus(subscriber.task, false)
 }
@@ -76,9 +164,8 @@ type shardStartSubscriber struct {
 }
 
 // OnSubscribe 当有一个分片任务启动时的自定义时间处理
+// 实际并发数已由分片协程原子地增加，此处只负责发布状态
 func (subscriber *shardStartSubscriber) OnSubscribe(e *gopher_notify.Event[string, int64]) {
-	// 改变多线程任务状态
-	subscriber.task.concurrentTaskCount++
 	// 发布多线程任务状态
 	publishParallelTaskStatus(subscriber.task, false)
 }
@@ -90,32 +177,28 @@ type shardDoneSubscriber struct {
 }
 
 // OnSubscribe 当有一个分片任务完成时的自定义事件处理
+// 实际并发数已由分片协程原子地减少，此处只负责发布状态
 func (subscriber *shardDoneSubscriber) OnSubscribe(e *gopher_notify.Event[string, int64]) {
-	// 改变多线程任务状态
-	subscriber.task.concurrentTaskCount--
 	// 发布多线程任务状态
 	publishParallelTaskStatus(subscriber.task, false)
 }
 
 // 观察下载任务状态变化的观察者
 type taskObserver struct {
-	// 上次下载大小
-	lastSize int64
-	// 上次通知时间
-	lastNotifyTime time.Time
+	// 计算下载速度的估算器
+	estimator *SpeedEstimator
 	// 用户传入的自定义接收进度变化的回调函数
 	subscribeFunction func(status *TaskStatus)
 }
 
 // OnUpdate 当一个 ParallelGetTask 的下载状态发生变化时，该方法被调用
 func (observer *taskObserver) OnUpdate(data *TaskStatus) {
-	// 计算速度
-	size := data.DownloadSize - observer.lastSize
-	duration := time.Now().Sub(observer.lastNotifyTime).Milliseconds()
-	data.Speed = float64(size) / float64(duration)
-	// 记录本次状态
-	observer.lastSize = data.DownloadSize
-	observer.lastNotifyTime = time.Now()
+	// 计算速度与剩余时间
+	observer.estimator.Update(data.DownloadSize)
+	data.Speed = observer.estimator.Speed()
+	data.AverageSpeed = observer.estimator.AverageSpeed()
+	data.RemainTime = observer.estimator.RemainTime(data.TotalSize)
+	data.RemainTimeKnown = data.RemainTime >= 0
 	// 调用用户传入的自定义接收进度函数
 	observer.subscribeFunction(data)
 }
\ No newline at end of file
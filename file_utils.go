@@ -2,20 +2,27 @@ package gopher_fetch
 
 import (
 	"bufio"
-	"encoding/json"
 	"io"
 	"os"
+	"path/filepath"
 )
 
+// 进度文件写入过程中使用的临时文件后缀
+const tempFileSuffix = ".tmp"
+
+// 上一次保存的进度文件的备份后缀，当进度文件损坏时使用备份恢复
+const backupFileSuffix = ".bak"
+
 // 创建一个指定大小的空白文件
 //
 //   - path 创建的文件路径
 //   - size 创建的文件大小（单位：字节）
-func createBlankFile(path string, size int64) error {
+//   - log 输出日志的日志对象
+func createBlankFile(path string, size int64, log *fetchLogger) error {
 	// 创建文件
 	file, e := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0755)
 	if e != nil {
-		logger.ErrorLine("创建文件出错！")
+		log.error(msgCreateFileError, fieldPath(path), fieldError(e))
 		return e
 	}
 	defer func() {
@@ -24,10 +31,10 @@ func createBlankFile(path string, size int64) error {
 	// 调整文件大小
 	e = file.Truncate(size)
 	if e != nil {
-		logger.ErrorLine("调整文件大小出错！")
+		log.error(msgTruncateFileError, fieldPath(path), fieldError(e))
 		return e
 	}
-	logger.InfoLine("已为下载文件预分配磁盘空间！")
+	log.info(msgFilePreallocated, fieldPath(path), field("size", size))
 	return nil
 }
 
@@ -50,68 +57,84 @@ func readFile(path string) ([]byte, error) {
 	return io.ReadAll(reader)
 }
 
-// 将内容写入文件
+// 将文件已写入的内容同步至磁盘
 //
-//   - content 写入的字节
-//   - path 文件保存位置
-func writeFile(content []byte, path string) error {
-	// 创建文件
-	file, e := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+//   - path 文件路径
+func syncFile(path string) error {
+	file, e := os.OpenFile(path, os.O_WRONLY, 0755)
 	if e != nil {
 		return e
 	}
 	defer func() {
 		_ = file.Close()
 	}()
-	// 写入文件
-	writer := bufio.NewWriter(file)
-	_, e = writer.Write(content)
+	return file.Sync()
+}
+
+// 尽可能地将目录项的变化同步至磁盘，部分操作系统不支持同步目录，此时忽略错误
+//
+//   - dir 目录路径
+func syncDir(dir string) {
+	file, e := os.Open(dir)
 	if e != nil {
-		return e
+		return
 	}
-	return writer.Flush()
+	_ = file.Sync()
+	_ = file.Close()
 }
 
-// 将下载任务序列化为JSON文件
+// 以原子方式将内容写入文件
+// 先将内容写入临时文件并同步至磁盘，再将原文件重命名为备份文件，最后将临时文件重命名为目标文件，
+// 因此任意时刻断电，目标文件或者备份文件中总有一个是完整的
 //
-//   - T 任务对象类型
-//   - task 下载任务对象
-//   - path 保存文件位置，若为空字符串""则不会进行任何操作
-//
-// 出现错误返回错误对象
-func saveTaskToJson[T any](task T, path string) error {
-	if path == "" {
-		return nil
+//   - content 写入的字节
+//   - path 文件保存位置
+//   - perm 新建文件的权限
+func writeFileAtomic(content []byte, path string, perm os.FileMode) error {
+	tempPath := path + tempFileSuffix
+	// 写入临时文件
+	file, e := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if e != nil {
+		return e
+	}
+	writer := bufio.NewWriter(file)
+	_, e = writer.Write(content)
+	if e == nil {
+		e = writer.Flush()
 	}
-	// 序列化
-	content, e := json.Marshal(task)
+	if e == nil {
+		e = file.Sync()
+	}
+	closeError := file.Close()
 	if e != nil {
-		logger.ErrorLine("序列化任务对象出现错误！")
 		return e
 	}
-	return writeFile(content, path)
+	if closeError != nil {
+		return closeError
+	}
+	// 保留上一次的文件作为备份
+	if _, e = os.Stat(path); e == nil {
+		e = os.Rename(path, path+backupFileSuffix)
+		if e != nil {
+			return e
+		}
+	}
+	// 替换为新文件
+	e = os.Rename(tempPath, path)
+	if e != nil {
+		return e
+	}
+	syncDir(filepath.Dir(path))
+	return nil
 }
 
-// 从JSON文件加载下载任务
+// 删除进度文件及其临时文件与备份文件
 //
-//   - T 任务对象类型，不要传递指针
-//   - path 保存文件位置
+//   - path 进度文件位置
 //
-// 出现错误返回错误对象
-func loadTaskFromJson[T any](path string) (T, error) {
-	var zero T
-	// 读取内容
-	content, e := readFile(path)
-	if e != nil {
-		logger.ErrorLine(e.Error())
-		return zero, e
-	}
-	// 反序列化
-	var task T
-	e = json.Unmarshal(content, &task)
-	if e != nil {
-		logger.ErrorLine("反序列化任务内容出错！")
-		return zero, e
-	}
-	return task, nil
+// 删除进度文件失败时返回错误对象，临时文件与备份文件不存在时忽略
+func removeProcessFile(path string) error {
+	_ = os.Remove(path + tempFileSuffix)
+	_ = os.Remove(path + backupFileSuffix)
+	return os.Remove(path)
 }
\ No newline at end of file
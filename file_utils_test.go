@@ -0,0 +1,73 @@
+package gopher_fetch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试原子写入进度文件，以及进度文件损坏时从备份恢复
+func TestLoadProcessFile_Fallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin.process.json")
+	// 保存两次进度
+	e := saveProcessFile(globalProgressStore, path, taskKindMono, time.Now(), &monoTaskSnapshot{Url: "http://example.com", DownloadSize: 100})
+	if e != nil {
+		t.Fatal(e)
+	}
+	e = saveProcessFile(globalProgressStore, path, taskKindMono, time.Now(), &monoTaskSnapshot{Url: "http://example.com", DownloadSize: 200})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e = os.Stat(path + tempFileSuffix); !os.IsNotExist(e) {
+		t.Error("临时文件未被移除！")
+	}
+	snapshot, e := loadMonoSnapshot(path)
+	if e != nil || snapshot.DownloadSize != 200 {
+		t.Fatalf("读取最新进度不正确：%+v %v", snapshot, e)
+	}
+	// 模拟进度文件写入中途断电导致损坏，此时应恢复上一次的进度
+	e = os.WriteFile(path, []byte{}, 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	snapshot, e = loadMonoSnapshot(path)
+	if e != nil || snapshot.DownloadSize != 100 {
+		t.Fatalf("从备份恢复进度不正确：%+v %v", snapshot, e)
+	}
+	// 删除进度文件时一并删除备份
+	e = removeProcessFile(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e = os.Stat(path + backupFileSuffix); !os.IsNotExist(e) {
+		t.Error("备份文件未被删除！")
+	}
+}
+
+// 从进度文件读取单线程任务快照
+func loadMonoSnapshot(path string) (monoTaskSnapshot, error) {
+	envelope, e := loadProcessFile(globalProgressStore, path)
+	if e != nil {
+		return monoTaskSnapshot{}, e
+	}
+	return decodeProcessTask[monoTaskSnapshot](envelope, taskKindMono)
+}
+
+// 测试保存进度文件的间隔小于等于0时使用默认间隔
+func TestCheckpointInterval(t *testing.T) {
+	interval := GlobalConfig.CheckpointInterval
+	defer func() {
+		GlobalConfig.CheckpointInterval = interval
+	}()
+	for _, value := range []time.Duration{0, -time.Second} {
+		GlobalConfig.CheckpointInterval = value
+		if GlobalConfig.checkpointInterval() != defaultCheckpointInterval {
+			t.Errorf("间隔为%s时未使用默认间隔：%s", value, GlobalConfig.checkpointInterval())
+		}
+	}
+	GlobalConfig.CheckpointInterval = time.Second
+	if GlobalConfig.checkpointInterval() != time.Second {
+		t.Errorf("保存进度文件的间隔不正确：%s", GlobalConfig.checkpointInterval())
+	}
+}
\ No newline at end of file
@@ -1,6 +1,6 @@
 module gitee.com/swsk33/gopher-fetch
 
-go 1.18
+go 1.21
 
 retract (
 	v1.1.1
@@ -12,6 +12,7 @@ require (
 	gitee.com/swsk33/gopher-notify v1.2.2
 	gitee.com/swsk33/sclog v1.3.3
 	github.com/fatih/color v1.18.0
+	golang.org/x/net v0.33.0
 )
 
 require (
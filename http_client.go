@@ -2,22 +2,16 @@ package gopher_fetch
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
+	"net/http/httptrace"
 	"os"
+	"sync/atomic"
 )
 
-// 全局http请求客户端
-var httpClient = &http.Client{
-	Timeout: 0,
-	Transport: &http.Transport{
-		DisableKeepAlives: true,
-	},
-}
-
 // 响应读取缓冲区大小
 const bufferSize = 64 * 1024
 
@@ -26,11 +20,21 @@ const bufferSize = 64 * 1024
 //   - url 请求地址
 //   - method 请求方法，例如：http.MethodHead http.MethodGet 等等
 //   - rangeStart, rangeEnd 表示分片请求的范围，若不需要设定范围，则全部置为-1，若起始不为-1但终止为-1，则获取从起始开始往后的全部内容
-func sendRequest(url, method string, rangeStart, rangeEnd int64) (*http.Response, error) {
+//   - log 输出日志的日志对象
+func sendRequest(url, method string, rangeStart, rangeEnd int64, log *fetchLogger) (*http.Response, error) {
+	return sendRequestContext(context.Background(), url, method, rangeStart, rangeEnd, log)
+}
+
+// 发送一个可取消的HTTP请求，取消后正在进行的请求以及响应体的读取都会立即返回错误
+// 建立连接、TLS握手或者等待响应头超时时，返回 TimeoutError
+//
+//   - ctx 请求的上下文，可携带所属任务的http请求客户端与连接统计
+//   - 其余参数同 sendRequest
+func sendRequestContext(ctx context.Context, url, method string, rangeStart, rangeEnd int64, log *fetchLogger) (*http.Response, error) {
 	// 准备请求
-	request, e := http.NewRequest(method, url, nil)
+	request, e := http.NewRequestWithContext(ctx, method, url, nil)
 	if e != nil {
-		logger.ErrorLine("创建请求对象出错！")
+		log.error(msgCreateRequestError, fieldError(e))
 		return nil, e
 	}
 	// 加入请求头
@@ -40,84 +44,169 @@ func sendRequest(url, method string, rangeStart, rangeEnd int64) (*http.Response
 	} else if rangeStart != -1 {
 		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", rangeStart))
 	}
-	for key, value := range GlobalConfig.Headers {
-		request.Header.Set(key, value)
-	}
+	setCustomHeaders(request, originUrlFrom(ctx, request.URL))
+	setConditionalHeaders(request)
+	// 记录请求所处的阶段，用于判断超时发生的位置
+	// 同时记录连接统计
+	counter := connectionCounterFrom(ctx)
+	phase := &atomic.Value{}
+	phase.Store(TimeoutDial)
+	request = request.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		TLSHandshakeStart: func() {
+			phase.Store(TimeoutTLSHandshake)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			phase.Store(TimeoutResponseHeader)
+			counter.gotConn(info.Reused)
+		},
+	}))
+	// 从代理池选择代理服务器
+	pool, proxy, request := selectPooledProxy(request)
 	// 发送请求
-	response, e := httpClient.Do(request)
+	response, e := clientFrom(ctx).Do(request)
+	if pool != nil {
+		if e != nil {
+			// 请求被取消或者超过上下文的期限时，无法判断代理服务器是否可用，不计入失败
+			pool.release(proxy, ctx.Err() != nil || !isProxyFailure(e), log)
+		} else {
+			response.Body = &pooledResponseBody{ReadCloser: response.Body, pool: pool, proxy: proxy, statusCode: response.StatusCode, logger: log}
+		}
+	}
 	if e != nil {
-		logger.ErrorLine("发送HTTP请求失败！")
+		// 上下文被取消或者到期时，由调用者处理
+		if ctx.Err() == nil {
+			e = classifyTimeout(e, phase.Load().(TimeoutPhase))
+		}
+		log.error(msgSendRequestError, field("method", method), fieldError(e))
 		return nil, e
 	}
+	counter.gotResponse(response.ProtoMajor)
 	return response, nil
 }
 
-// 获取请求的文件大小
+// 获取请求的文件大小，探测超时时重试，最多重试 FetchConfig.Retry 次
 //
+//   - ctx 探测请求的上下文，可携带所属任务的http请求客户端与连接统计
 //   - url 请求地址
+//   - log 输出日志的日志对象
 //
 // 返回值分别是：
-//   - 获取到的长度，获取失败返回-1
+//   - 获取到的长度，获取失败或者服务器未给出长度（例如分块传输编码的响应）时返回-1
 //   - 请求是否支持分片获取（是否支持Range请求头）
-//   - 出现错误则返回非空错误对象
-func getContentLength(url string) (int64, bool, error) {
+//   - 跟随重定向后最终的请求地址，未发生重定向时与请求地址相同，出现错误时为空
+//   - 出现错误则返回非空错误对象，超时时为 TimeoutError ，重定向不符合策略时可通过 errors.Is 判断为 ErrTooManyRedirects 或者 ErrRedirectNotAllowed ，条件请求表示文件未修改时为 ErrNotModified
+func getContentLength(ctx context.Context, url string, log *fetchLogger) (int64, bool, string, error) {
+	for attempt := 1; ; attempt++ {
+		length, supportRange, resolved, e := probeContentLength(ctx, url, log)
+		var timeoutError *TimeoutError
+		if e == nil || !errors.As(e, &timeoutError) || attempt > GlobalConfig.Retry {
+			return length, supportRange, resolved, e
+		}
+		log.warn(msgProbeRetrying, fieldAttempt(attempt), fieldError(e))
+	}
+}
+
+// 探测一次服务器，获取请求的文件大小，整个探测过程不超过 FetchConfig.ProbeTimeout
+//
+// 参数与返回值同 getContentLength
+func probeContentLength(ctx context.Context, url string, log *fetchLogger) (length int64, supportRange bool, resolved string, e error) {
+	ctx, cancel := context.WithCancel(ctx)
+	if GlobalConfig.ProbeTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, GlobalConfig.ProbeTimeout)
+	}
+	defer cancel()
+	// 探测整体超时，转换为超时错误
+	defer func() {
+		if e != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			e = &TimeoutError{Phase: TimeoutProbe, Timeout: GlobalConfig.ProbeTimeout, Err: e}
+		}
+	}()
 	// 发送HEAD请求，获取Length
-	response, e := sendRequest(url, http.MethodHead, -1, -1)
+	response, e := sendRequestContext(ctx, url, http.MethodHead, -1, -1, log)
 	if e != nil {
-		logger.ErrorLine("发送HEAD请求出错！")
-		return -1, false, e
+		log.error(msgHeadRequestError, fieldError(e))
+		return -1, false, "", e
+	}
+	// HEAD请求的响应没有响应体，直接关闭以释放连接
+	_ = response.Body.Close()
+	if response.StatusCode == http.StatusNotModified {
+		log.info(msgNotModified)
+		return -1, false, "", ErrNotModified
 	}
 	// 如果Head不被允许，则切换为Get再试
 	if response.StatusCode >= 300 {
-		logger.Warn("无法使用HEAD请求，状态码：%d，将使用GET请求重试...\n", response.StatusCode)
-		response, e = sendRequest(url, http.MethodGet, -1, -1)
+		log.warn(msgHeadNotAllowed, field("status", response.StatusCode))
+		response, e = sendRequestContext(ctx, url, http.MethodGet, -1, -1, log)
 		if e != nil {
-			logger.ErrorLine("发送GET请求获取大小出错！")
-			return -1, false, e
+			log.error(msgGetLengthError, fieldError(e))
+			return -1, false, "", e
 		}
 		// 最终直接关闭响应体，不进行读取
 		defer func() {
 			_ = response.Body.Close()
 		}()
 		// 再次检查状态码，若不正确则返回错误
+		if response.StatusCode == http.StatusNotModified {
+			log.info(msgNotModified)
+			return -1, false, "", ErrNotModified
+		}
 		if response.StatusCode >= 300 {
-			logger.Error("发送GET请求获取大小出错！状态码：%d\n", response.StatusCode)
-			return -1, false, fmt.Errorf("状态码不正确：%d", response.StatusCode)
+			log.error(msgGetLengthError, field("status", response.StatusCode))
+			return -1, false, "", newStatusCodeError(response)
+		}
+	}
+	// 后续的探测直接请求重定向后的地址
+	resolved = response.Request.URL.String()
+	recordValidators(ctx, response)
+	// 检查是否支持部分请求，未声明时主动探测
+	length = response.ContentLength
+	switch response.Header.Get("Accept-Ranges") {
+	case "bytes":
+		supportRange = true
+	case "":
+		var probeLength int64
+		supportRange, probeLength = probeRangeSupport(ctx, resolved, length < 0, log)
+		// 探测请求超时时，不能视为不支持分片获取
+		if e = ctx.Err(); e != nil {
+			return -1, false, "", e
+		}
+		if length < 0 {
+			length = probeLength
 		}
 	}
-	// 读取长度
-	if response.ContentLength <= 0 {
-		return -1, false, errors.New("无法获取目标文件大小！")
+	// 服务器未给出长度
+	if length < 0 {
+		log.warn(msgUnknownLength, field("supportRange", supportRange))
+		return -1, supportRange, resolved, nil
 	}
-	// 检查是否支持部分请求
-	supportRange := response.Header.Get("Accept-Ranges") == "bytes"
-	logger.Info("已获取下载文件大小：%d字节\n", response.ContentLength)
-	return response.ContentLength, supportRange, nil
+	log.info(msgContentLength, field("size", length), field("supportRange", supportRange))
+	return length, supportRange, resolved, nil
 }
 
 // 发送下载文件请求并保存到本地
+// 下载过程中若连接停滞，即超过 FetchConfig.IdleReadTimeout 未读取到数据，或者速度持续低于 FetchConfig.MinSpeed ，则中断下载并返回 StallError
 //
+//   - ctx 下载请求的上下文，取消后下载立即中断并返回错误
 //   - url 下载地址
 //   - filePath 保存位置（文件需已创建好）
-//   - start 下载起始范围（字节），-1代表从头开始读取文件
+//   - start 下载起始范围（字节），-1代表从头开始读取文件，请求部分内容时响应必须是206且 Content-Range 与请求一致，否则返回 RangeMismatchError
 //   - end 下载终止范围（字节），-1代表一直读取到文件尾
-//   - downloadSize 记录已下载字节数的变量指针，用于任务对象维护状态
-//   - fetchDone 记录文件是否完整下载完成的变量指针，用于任务对象维护状态
 //   - startHook 下载开始时该回调函数会被执行，用于状态的发布-订阅逻辑，可以为nil
-//   - sizeAddHook 每下载一部分文件，该回调函数就会被执行，参数表示本次下载的字节数，用于状态的发布-订阅逻辑，不能为nil
-//   - doneHook 下载任务完成时，该回调函数就会被执行，用于状态的发布-订阅逻辑，不能为nil
+//   - sizeAddHook 每下载一部分文件并写入后，该回调函数就会被执行，参数表示本次下载的字节数，用于任务对象累加已下载大小以及状态的发布-订阅逻辑，不能为nil
+//   - doneHook 下载任务完成时，该回调函数就会被执行，用于任务对象标记完成以及状态的发布-订阅逻辑，不能为nil
+//   - log 输出日志的日志对象
 //
 // 返回值：
-//   - 出现错误时，返回错误原因，否则返回空字符串""，该返回值用于重试消息提示
+//   - 出现错误时，返回错误原因的消息键，该返回值用于重试消息提示
 //   - 出现错误时返回引发错误的错误对象，否则返回nil
-func downloadFile(url, filePath string, start, end int64, downloadSize *int64, fetchDone *bool, startHook func(), sizeAddHook func(addSize int64), doneHook func()) (string, error) {
+func downloadFile(ctx context.Context, url, filePath string, start, end int64, startHook func(), sizeAddHook func(addSize int64), doneHook func(), log *fetchLogger) (messageKey, error) {
 	if startHook != nil {
 		startHook()
 	}
 	// 打开文件
 	file, e := os.OpenFile(filePath, os.O_WRONLY, 0755)
 	if e != nil {
-		return fmt.Sprintf("准备下载的文件%s失败", filePath), e
+		return msgReasonOpenFile, e
 	}
 	defer func() {
 		_ = file.Close()
@@ -126,22 +215,37 @@ func downloadFile(url, filePath string, start, end int64, downloadSize *int64, f
 	if start > 0 {
 		_, e = file.Seek(start, io.SeekStart)
 		if e != nil {
-			return "设定文件指针失败", e
+			return msgReasonSeekFile, e
 		}
 	}
 	// 发送请求
-	response, e := sendRequest(url, http.MethodGet, start, end)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	response, e := sendRequestContext(ctx, url, http.MethodGet, start, end, log)
 	if e != nil {
-		return "发送下载请求失败", e
+		var timeoutError *TimeoutError
+		if errors.As(e, &timeoutError) {
+			return msgReasonTimeout, e
+		}
+		return msgReasonSendRequest, e
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 	// 判断错误码
 	if response.StatusCode >= 300 {
-		message := fmt.Sprintf("状态码错误：%d", response.StatusCode)
-		return message, errors.New(message)
+		return msgReasonBadStatus, newStatusCodeError(response)
+	}
+	// 请求部分内容时，响应必须是对应的范围，否则写入的位置错误会损坏文件
+	if start > 0 || (start == 0 && end >= 0) {
+		contentRange := response.Header.Get("Content-Range")
+		if response.StatusCode != http.StatusPartialContent || !matchContentRange(contentRange, start, end) {
+			return msgReasonRangeMismatch, &RangeMismatchError{Start: start, End: end, StatusCode: response.StatusCode, ContentRange: contentRange}
+		}
 	}
+	// 监视连接是否停滞，停滞时取消请求
+	watchdog := startStallWatchdog(cancel)
+	defer watchdog.stop()
 	// 读取响应体
 	buffer := make([]byte, bufferSize)
 	// 文件写入器
@@ -149,25 +253,27 @@ func downloadFile(url, filePath string, start, end int64, downloadSize *int64, f
 	for {
 		// 读取一次响应体
 		readSize, readError := response.Body.Read(buffer)
+		watchdog.record(int64(readSize))
 		// 处理错误，视情况重试
 		if readError != nil && readError != io.EOF {
-			return "读取响应体错误", readError
+			if stallError := watchdog.stallError(); stallError != nil {
+				return msgReasonStalled, stallError
+			}
+			return msgReasonReadBody, readError
 		}
 		// 写入文件
 		if readSize > 0 {
 			_, writeError := writer.Write(buffer[:readSize])
 			if writeError != nil {
-				return "下载任务写入文件出错", writeError
+				return msgReasonWriteFile, writeError
 			}
 			// 刷新缓冲区
 			writeError = writer.Flush()
 			if writeError != nil {
-				return "下载任务刷新文件缓冲区出错", writeError
+				return msgReasonFlushFile, writeError
 			}
 			// 记录已下载大小
-			addSize := int64(readSize)
-			*downloadSize += addSize
-			sizeAddHook(addSize)
+			sizeAddHook(int64(readSize))
 		}
 		// 判断是否到末尾
 		if readError == io.EOF {
@@ -175,32 +281,6 @@ func downloadFile(url, filePath string, start, end int64, downloadSize *int64, f
 		}
 	}
 	// 标记任务完成
-	*fetchDone = true
 	doneHook()
-	return "", nil
-}
-
-// ConfigSetProxy 设定下载代理服务器
-//
-// proxyUrl 代理服务器地址，例如：http://127.0.0.1:2345
-func ConfigSetProxy(proxyUrl string) {
-	proxy, e := url.Parse(proxyUrl)
-	if e != nil {
-		logger.Error("不支持的代理地址格式：%s\n", proxyUrl)
-		return
-	}
-	httpClient.Transport.(*http.Transport).Proxy = http.ProxyURL(proxy)
-	logger.Warn("将使用代理服务器：%s 进行下载\n", proxyUrl)
-}
-
-// ConfigEnvironmentProxy 配置从环境变量自动获取代理服务器配置
-func ConfigEnvironmentProxy() {
-	httpClient.Transport.(*http.Transport).Proxy = http.ProxyFromEnvironment
-	logger.WarnLine("将从环境变量获取代理配置")
-}
-
-// ConfigDisableProxy 关闭代理配置
-func ConfigDisableProxy() {
-	httpClient.Transport.(*http.Transport).Proxy = nil
-	logger.WarnLine("将不使用代理进行下载")
+	return msgNone, nil
 }
\ No newline at end of file
@@ -0,0 +1,241 @@
+package gopher_fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 创建一个用于测试的本地文件服务器，支持Range请求
+//
+//   - size 服务器提供的文件大小（字节）
+//
+// 返回服务器对象和文件内容
+func newTestFileServer(t *testing.T, size int) (*httptest.Server, []byte) {
+	return newWrappedFileServer(t, size, nil)
+}
+
+// 创建一个用于测试的本地文件服务器，请求先经过包装函数返回的处理器，可在其中统计、拒绝或者重定向请求
+//
+//   - size 服务器提供的文件大小（字节）
+//   - wrap 包装提供文件内容的处理器，为nil表示直接提供文件内容
+//
+// 返回服务器对象和文件内容
+func newWrappedFileServer(t *testing.T, size int, wrap func(fileHandler http.Handler) http.HandlerFunc) (*httptest.Server, []byte) {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(content)
+	var handler http.Handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.ServeContent(writer, request, "file.bin", time.Time{}, bytes.NewReader(content))
+	})
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server, content
+}
+
+// 检查下载的文件内容
+//
+//   - filePath 文件保存位置
+//   - content 正确的文件内容
+func checkDownloadedFile(t *testing.T, filePath string, content []byte) {
+	downloaded, e := os.ReadFile(filePath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Fatal("下载的文件内容不正确！")
+	}
+}
+
+// 测试从本地服务器获取文件大小
+func TestGetContentLength(t *testing.T) {
+	server, content := newTestFileServer(t, 4096)
+	length, supportRange, _, e := getContentLength(context.Background(), server.URL, logger)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if length != int64(len(content)) || !supportRange {
+		t.Errorf("获取的文件信息不正确！大小：%d，支持分片：%t", length, supportRange)
+	}
+}
+
+// 不声明Accept-Ranges响应头的响应写入器
+type hideAcceptRangesWriter struct {
+	http.ResponseWriter
+}
+
+// WriteHeader 写入响应头前移除Accept-Ranges
+func (writer *hideAcceptRangesWriter) WriteHeader(statusCode int) {
+	writer.Header().Del("Accept-Ranges")
+	writer.ResponseWriter.WriteHeader(statusCode)
+}
+
+// 测试服务器未声明Accept-Ranges时，主动探测是否支持分片获取
+func TestGetContentLength_RangeProbe(t *testing.T) {
+	content := make([]byte, 4096)
+	probeCount := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Range") == "bytes=0-0" {
+			probeCount.Add(1)
+		}
+		http.ServeContent(&hideAcceptRangesWriter{writer}, request, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	for i := 0; i < 2; i++ {
+		length, supportRange, _, e := getContentLength(context.Background(), server.URL, logger)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if length != int64(len(content)) || !supportRange {
+			t.Errorf("获取的文件信息不正确！大小：%d，支持分片：%t", length, supportRange)
+		}
+	}
+	// 同一主机只探测一次
+	if probeCount.Load() != 1 {
+		t.Errorf("探测次数不正确：%d", probeCount.Load())
+	}
+	// 探测请求出错时不缓存结果，文件大小未知时即使存在缓存也需要探测以获取大小
+	var failProbe atomic.Bool
+	failProbe.Store(true)
+	server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodHead {
+			return
+		}
+		if request.Header.Get("Range") == "bytes=0-0" && failProbe.Swap(false) {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(&hideAcceptRangesWriter{writer}, request, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	for i, excepted := range []bool{false, true, true} {
+		length, supportRange, _, e := getContentLength(context.Background(), server.URL, logger)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if supportRange != excepted || (excepted && length != int64(len(content))) {
+			t.Errorf("第%d次获取的文件信息不正确！大小：%d，支持分片：%t", i+1, length, supportRange)
+		}
+	}
+	// 解析Content-Range
+	for value, excepted := range map[string]int64{"bytes 0-0/100": 100, "bytes 0-0/*": -1, "bytes 0-99/100": -2, "": -2} {
+		supportRange, length := parseContentRange(value)
+		if (excepted == -2 && supportRange) || (excepted != -2 && (!supportRange || length != excepted)) {
+			t.Errorf("解析%q不正确：%t %d", value, supportRange, length)
+		}
+	}
+}
+
+// 测试探测服务器时的超时错误
+func TestGetContentLength_Timeout(t *testing.T) {
+	retry, headerTimeout, probeTimeout := GlobalConfig.Retry, GlobalConfig.ResponseHeaderTimeout, GlobalConfig.ProbeTimeout
+	defer func() {
+		GlobalConfig.Retry, GlobalConfig.ResponseHeaderTimeout, GlobalConfig.ProbeTimeout = retry, headerTimeout, probeTimeout
+	}()
+	GlobalConfig.Retry = 1
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests.Add(1)
+		select {
+		case <-request.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+	cases := []struct {
+		headerTimeout time.Duration
+		probeTimeout  time.Duration
+		phase         TimeoutPhase
+	}{
+		{50 * time.Millisecond, 0, TimeoutResponseHeader},
+		{0, 50 * time.Millisecond, TimeoutProbe},
+	}
+	for _, c := range cases {
+		GlobalConfig.ResponseHeaderTimeout, GlobalConfig.ProbeTimeout = c.headerTimeout, c.probeTimeout
+		requests.Store(0)
+		_, _, _, e := getContentLength(context.Background(), server.URL, logger)
+		var timeoutError *TimeoutError
+		if !errors.As(e, &timeoutError) {
+			t.Fatalf("未返回超时错误：%v", e)
+		}
+		if timeoutError.Phase != c.phase {
+			t.Errorf("超时阶段不正确：%s，应为%s", timeoutError.Phase, c.phase)
+		}
+		// 超时后应重试一次
+		if requests.Load() != 2 {
+			t.Errorf("请求次数不正确：%d", requests.Load())
+		}
+	}
+}
+
+// 测试请求部分内容时，服务器忽略Range请求头返回完整文件，不写入文件并返回可重试的错误
+func TestDownloadFile_RangeMismatch(t *testing.T) {
+	content := bytes.Repeat([]byte("gopher"), 1024)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write(content)
+	}))
+	t.Cleanup(server.Close)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	if e := createBlankFile(filePath, int64(len(content)), logger); e != nil {
+		t.Fatal(e)
+	}
+	cases := []struct {
+		start int64
+		end   int64
+	}{
+		{1024, -1},
+		{0, 1023},
+		{2048, 4095},
+	}
+	for _, c := range cases {
+		var added int64
+		reason, e := downloadFile(context.Background(), server.URL, filePath, c.start, c.end, nil, func(addSize int64) {
+			added += addSize
+		}, func() {}, logger)
+		var rangeError *RangeMismatchError
+		if !errors.As(e, &rangeError) || reason != msgReasonRangeMismatch || rangeError.StatusCode != http.StatusOK {
+			t.Errorf("范围%d-%d未返回范围错误：%v", c.start, c.end, e)
+		}
+		if added != 0 {
+			t.Errorf("范围%d-%d写入了错误的数据：%d", c.start, c.end, added)
+		}
+	}
+	// 不带范围从头下载时，200响应是正确的
+	if _, e := downloadFile(context.Background(), server.URL, filePath, 0, -1, nil, func(int64) {}, func() {}, logger); e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+}
+
+// 测试判断分片响应的Content-Range是否与请求一致
+func TestMatchContentRange(t *testing.T) {
+	cases := []struct {
+		contentRange string
+		start        int64
+		end          int64
+		match        bool
+	}{
+		{"bytes 100-199/1000", 100, 199, true},
+		{"bytes 100-999/1000", 100, -1, true},
+		{"bytes 100-999/*", 100, -1, true},
+		{"bytes 0-999/1000", 100, 199, false},
+		{"bytes 100-150/1000", 100, 199, false},
+		{"bytes */1000", 100, -1, false},
+		{"", 100, -1, false},
+	}
+	for _, c := range cases {
+		if matchContentRange(c.contentRange, c.start, c.end) != c.match {
+			t.Errorf("判断%q与范围%d-%d是否一致的结果不正确", c.contentRange, c.start, c.end)
+		}
+	}
+}
\ No newline at end of file
@@ -1,20 +1,82 @@
 package gopher_fetch
 
 import (
+	"context"
+	"fmt"
 	"gitee.com/swsk33/sclog"
 	"github.com/fatih/color"
+	"log/slog"
+	"strings"
 	"sync"
 )
 
+// LogLevel 日志级别
+type LogLevel int
+
+// 日志级别常量
+const (
+	// LevelInfo 普通信息
+	LevelInfo LogLevel = iota
+	// LevelWarn 警告信息
+	LevelWarn
+	// LevelError 错误信息
+	LevelError
+)
+
+// LogField 一个结构化日志字段
+type LogField struct {
+	// 字段名
+	Key string
+	// 字段值
+	Value any
+}
+
+// Logger 日志输出接口，实现该接口即可接管下载过程中的全部日志输出
+type Logger interface {
+	// Log 输出一条日志
+	//
+	//   - level 日志级别
+	//   - message 日志消息，已按照 ConfigSetLanguage 设定的语言本地化
+	//   - fields 本条日志附带的结构化字段，例如下载地址、分片序号、下载范围、重试次数等
+	Log(level LogLevel, message string, fields ...LogField)
+}
+
+// 结构化字段名常量
+const (
+	// 下载地址
+	fieldKeyUrl = "url"
+	// 文件路径
+	fieldKeyPath = "path"
+	// 分片序号
+	fieldKeyOrder = "shard"
+	// 分片下载范围
+	fieldKeyRange = "range"
+	// 重试次数
+	fieldKeyAttempt = "attempt"
+	// 重试原因
+	fieldKeyReason = "reason"
+	// 错误信息
+	fieldKeyError = "error"
+)
+
 // 全局日志输出锁
 var loggerLock = &sync.Mutex{}
 
-// 全局日志对象
-var logger = sclog.NewMutexLoggerShareLock(loggerLock)
+// 默认的控制台日志对象
+var consoleLogger = sclog.NewMutexLoggerShareLock(loggerLock)
 
 // 全局实时日志对象
 var realTimeLogger = sclog.NewMutexLoggerShareLock(loggerLock)
 
+// 用户配置的全局日志输出，为nil时使用控制台输出
+var globalLogger Logger
+
+// 是否启用日志输出
+var loggerEnabled = true
+
+// 全局内部日志对象
+var logger = &fetchLogger{}
+
 func init() {
 	config := sclog.NewLineConfig()
 	config.Time.Enabled = false
@@ -23,13 +85,174 @@ func init() {
 	realTimeLogger.ConfigAll(config)
 }
 
-// ConfigEnableLogger 配置是否启用控制台日志输出
+// 基于 sclog 的默认控制台日志输出
+type sclogLogger struct {
+	// 实际输出的日志对象
+	target *sclog.Logger
+}
+
+// Log 实现 Logger 接口，结构化字段以key=value的形式附加在消息末尾
+func (l *sclogLogger) Log(level LogLevel, message string, fields ...LogField) {
+	builder := &strings.Builder{}
+	builder.WriteString(message)
+	for _, field := range fields {
+		builder.WriteString(fmt.Sprintf(" %s=%v", field.Key, field.Value))
+	}
+	switch level {
+	case LevelWarn:
+		l.target.WarnLine(builder.String())
+	case LevelError:
+		l.target.ErrorLine(builder.String())
+	default:
+		l.target.InfoLine(builder.String())
+	}
+}
+
+// 基于 log/slog 的日志输出适配器
+type slogLogger struct {
+	// 实际输出的slog日志对象
+	target *slog.Logger
+}
+
+// NewSlogLogger 创建一个将日志转发至 slog.Handler 的日志输出对象
+//
+//   - handler slog的日志处理器，例如 slog.NewJSONHandler 创建的对象
+func NewSlogLogger(handler slog.Handler) Logger {
+	return &slogLogger{target: slog.New(handler)}
+}
+
+// Log 实现 Logger 接口，结构化字段将转换为 slog.Attr
+func (l *slogLogger) Log(level LogLevel, message string, fields ...LogField) {
+	slogLevel := slog.LevelInfo
+	switch level {
+	case LevelWarn:
+		slogLevel = slog.LevelWarn
+	case LevelError:
+		slogLevel = slog.LevelError
+	}
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, field := range fields {
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+	}
+	l.target.LogAttrs(context.Background(), slogLevel, message, attrs...)
+}
+
+// 内部使用的日志对象，负责消息本地化并附加上下文字段
+type fetchLogger struct {
+	// 实际输出的日志对象，为nil时使用全局日志输出
+	target Logger
+	// 每条日志都会附带的上下文字段
+	fields []LogField
+}
+
+// 创建一个附带额外上下文字段的日志对象，其日志输出目标不变
+//
+//   - fields 附加的上下文字段
+func (l *fetchLogger) with(fields ...LogField) *fetchLogger {
+	merged := make([]LogField, 0, len(l.fields)+len(fields))
+	merged = append(merged, l.fields...)
+	merged = append(merged, fields...)
+	return &fetchLogger{
+		target: l.target,
+		fields: merged,
+	}
+}
+
+// 输出一条日志
 //
-// enable 若为true则打开控制台日志输出，否则关闭日志
+//   - level 日志级别
+//   - key 日志消息键
+//   - fields 本条日志的附加字段
+func (l *fetchLogger) log(level LogLevel, key messageKey, fields ...LogField) {
+	if !loggerEnabled {
+		return
+	}
+	target := l.target
+	if target == nil {
+		target = globalLogger
+	}
+	if target == nil {
+		target = &sclogLogger{target: consoleLogger}
+	}
+	all := fields
+	if len(l.fields) > 0 {
+		all = make([]LogField, 0, len(l.fields)+len(fields))
+		all = append(all, l.fields...)
+		all = append(all, fields...)
+	}
+	target.Log(level, key.text(), all...)
+}
+
+// 输出一条普通信息
+func (l *fetchLogger) info(key messageKey, fields ...LogField) {
+	l.log(LevelInfo, key, fields...)
+}
+
+// 输出一条警告信息
+func (l *fetchLogger) warn(key messageKey, fields ...LogField) {
+	l.log(LevelWarn, key, fields...)
+}
+
+// 输出一条错误信息
+func (l *fetchLogger) error(key messageKey, fields ...LogField) {
+	l.log(LevelError, key, fields...)
+}
+
+// 创建下载地址字段
+func fieldUrl(url string) LogField {
+	return LogField{Key: fieldKeyUrl, Value: url}
+}
+
+// 创建文件路径字段
+func fieldPath(path string) LogField {
+	return LogField{Key: fieldKeyPath, Value: path}
+}
+
+// 创建分片序号字段
+func fieldOrder(order int) LogField {
+	return LogField{Key: fieldKeyOrder, Value: order}
+}
+
+// 创建分片下载范围字段
+func fieldRange(start, end int64) LogField {
+	return LogField{Key: fieldKeyRange, Value: fmt.Sprintf("%d-%d", start, end)}
+}
+
+// 创建重试次数字段
+func fieldAttempt(attempt int) LogField {
+	return LogField{Key: fieldKeyAttempt, Value: attempt}
+}
+
+// 创建重试原因字段
+func fieldReason(reason messageKey) LogField {
+	return LogField{Key: fieldKeyReason, Value: reason.text()}
+}
+
+// 创建错误信息字段
+func fieldError(e error) LogField {
+	return LogField{Key: fieldKeyError, Value: e.Error()}
+}
+
+// 创建任意字段
+func field(key string, value any) LogField {
+	return LogField{Key: key, Value: value}
+}
+
+// ConfigEnableLogger 配置是否启用日志输出
+//
+// enable 若为true则打开日志输出，否则关闭日志
 func ConfigEnableLogger(enable bool) {
+	loggerEnabled = enable
 	if enable {
-		logger.Level = sclog.INFO
+		consoleLogger.Level = sclog.INFO
 	} else {
-		logger.Level = sclog.OFF
+		consoleLogger.Level = sclog.OFF
 	}
+}
+
+// ConfigSetLogger 设定全局日志输出，未单独设定日志输出的下载任务都将使用该日志输出
+//
+//   - target 自定义的日志输出对象，例如 NewSlogLogger 创建的对象，传入nil表示恢复默认的控制台日志输出
+func ConfigSetLogger(target Logger) {
+	globalLogger = target
 }
\ No newline at end of file
@@ -0,0 +1,30 @@
+package gopher_fetch
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+// 测试通过slog适配器输出结构化英文日志
+func TestNewSlogLogger(t *testing.T) {
+	ConfigSetLanguage(LanguageEnglish)
+	defer ConfigSetLanguage(LanguageChinese)
+	buffer := &bytes.Buffer{}
+	task := NewSimpleParallelGetTask("http://example.com/file.bin", "downloads/file.bin", 4)
+	task.SetLogger(NewSlogLogger(slog.NewJSONHandler(buffer, nil)))
+	// 输出一条分片日志
+	task.logger.with(fieldOrder(2), fieldRange(100, 199)).warn(msgShardRetrying, fieldAttempt(1))
+	var record map[string]any
+	e := json.Unmarshal(buffer.Bytes(), &record)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if record["msg"] != msgShardRetrying.text() || record["level"] != "WARN" {
+		t.Errorf("日志消息不正确：%v", record)
+	}
+	if record[fieldKeyUrl] != "http://example.com/file.bin" || record[fieldKeyRange] != "100-199" || record[fieldKeyOrder] != float64(2) || record[fieldKeyAttempt] != float64(1) {
+		t.Errorf("日志字段不正确：%v", record)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,296 @@
+package gopher_fetch
+
+import "fmt"
+
+// Language 日志与提示消息使用的语言
+type Language string
+
+// 支持的语言常量
+const (
+	// LanguageChinese 简体中文
+	LanguageChinese Language = "zh"
+	// LanguageEnglish 英文
+	LanguageEnglish Language = "en"
+)
+
+// 当前使用的消息语言
+var currentLanguage = LanguageChinese
+
+// ConfigSetLanguage 设定日志与提示消息使用的语言，默认为简体中文
+//
+//   - language 语言，支持： gopher_fetch.LanguageChinese gopher_fetch.LanguageEnglish
+func ConfigSetLanguage(language Language) {
+	if _, ok := messageCatalog[language]; !ok {
+		logger.warn(msgUnsupportedLanguage, field("language", language))
+		return
+	}
+	currentLanguage = language
+}
+
+// 消息键，对应消息目录中的一条消息
+type messageKey int
+
+// 消息键常量
+const (
+	// 空消息，表示没有对应的消息
+	msgNone messageKey = iota
+	// 日志消息
+	msgUnsupportedLanguage
+	msgChecksumError
+	msgChecksumDone
+	msgCreateFileError
+	msgTruncateFileError
+	msgFilePreallocated
+	msgReadProcessFileError
+	msgDeserializeTaskError
+	msgProcessFileFallback
+	msgProcessFileMigrated
+	msgSkipProcessFile
+	msgCreateRequestError
+	msgSendRequestError
+	msgHeadRequestError
+	msgHeadNotAllowed
+	msgGetLengthError
+	msgContentLength
+	msgUnknownLength
+	msgRangeProbed
+	msgRangeProbeFailed
+	msgInvalidProxy
+	msgUseProxy
+	msgEnvironmentProxy
+	msgDisableProxy
+	msgMonoRecovered
+	msgParallelRecovered
+	msgRangeUnsupported
+	msgConcurrencyAdjusted
+	msgShardsAllocated
+	msgShardAlreadyDone
+	msgShardDownloadStart
+	msgShardRetrying
+	msgShardThrottled
+	msgMonoRetrying
+	msgFileDownloaded
+	msgFileIncomplete
+	msgSaveProcessError
+	msgRemoveProcessError
+	msgStrategySelected
+	msgConcurrencyLimitChanged
+	msgHedgeStart
+	msgHedgeWon
+	msgHedgeFailed
+	msgProbeRetrying
+	msgInsecureTLS
+	msgProxyQuarantined
+	msgUrlRefreshed
+	msgUrlRefreshFailed
+	msgUrlResolved
+	msgResolvedUrlRejected
+	msgNotModified
+	msgSetModTimeError
+	msgSaveMetadataError
+	// 重试原因
+	msgReasonOpenFile
+	msgReasonSeekFile
+	msgReasonSendRequest
+	msgReasonBadStatus
+	msgReasonRangeMismatch
+	msgReasonReadBody
+	msgReasonWriteFile
+	msgReasonFlushFile
+	msgReasonStalled
+	msgReasonTimeout
+	msgReasonUrlExpired
+	// 下载方式选择原因
+	msgStrategyProgressFound
+	msgStrategyRangeSupported
+	msgStrategySmallFile
+	msgStrategyUnknownLength
+	msgStrategyRangeUnsupported
+	// 并发数调整原因
+	msgReasonThroughputImproved
+	msgReasonThrottled
+	// 格式化文本
+	msgFormatShardRetryError
+	msgFormatMonoRetryError
+	msgFormatProcessLookup
+	msgFormatStreamLookup
+	msgFormatRemainTime
+	msgRemainTimeUnknown
+)
+
+// 消息目录，存放每种语言下全部消息键对应的文本
+var messageCatalog = map[Language]map[messageKey]string{
+	LanguageChinese: {
+		msgUnsupportedLanguage:      "不支持的语言！",
+		msgChecksumError:            "计算文件摘要出错！",
+		msgChecksumDone:             "计算摘要完成！",
+		msgCreateFileError:          "创建文件出错！",
+		msgTruncateFileError:        "调整文件大小出错！",
+		msgFilePreallocated:         "已为下载文件预分配磁盘空间！",
+		msgReadProcessFileError:     "读取进度文件出错！",
+		msgDeserializeTaskError:     "反序列化任务内容出错！",
+		msgProcessFileFallback:      "进度文件缺失或已损坏，将从上一次保存的备份恢复！",
+		msgProcessFileMigrated:      "已将旧版本的进度文件升级至当前格式！",
+		msgSkipProcessFile:          "跳过无法恢复的进度文件！",
+		msgCreateRequestError:       "创建请求对象出错！",
+		msgSendRequestError:         "发送HTTP请求失败！",
+		msgHeadRequestError:         "发送HEAD请求出错！",
+		msgHeadNotAllowed:           "无法使用HEAD请求，将使用GET请求重试...",
+		msgGetLengthError:           "发送GET请求获取大小出错！",
+		msgContentLength:            "已获取下载文件大小！",
+		msgUnknownLength:            "服务器未给出文件大小，将在不预分配空间的情况下进行下载！",
+		msgRangeProbed:              "服务器未声明是否支持分片获取，已主动探测！",
+		msgRangeProbeFailed:         "探测是否支持分片获取失败，本次视为不支持！",
+		msgInvalidProxy:             "代理服务器配置有误！",
+		msgUseProxy:                 "将使用代理服务器进行下载！",
+		msgEnvironmentProxy:         "将从环境变量获取代理配置",
+		msgDisableProxy:             "将不使用代理进行下载",
+		msgMonoRecovered:            "从进度文件恢复单线程下载任务！",
+		msgParallelRecovered:        "从进度文件恢复多线程下载任务！",
+		msgRangeUnsupported:         "下载任务不支持断点续传！",
+		msgConcurrencyAdjusted:      "并发数大于文件总大小，将调整并发数！",
+		msgShardsAllocated:          "已完成分片计算！",
+		msgShardAlreadyDone:         "分片任务已下载完成，无需继续下载！",
+		msgShardDownloadStart:       "开始执行分片下载...",
+		msgShardRetrying:            "分片出现错误，将进行重试...",
+		msgShardThrottled:           "服务器限流，分片将在等待后重试...",
+		msgMonoRetrying:             "单线程下载任务出现错误，将进行重试...",
+		msgFileDownloaded:           "文件下载完成！",
+		msgFileIncomplete:           "文件未能完成下载！",
+		msgSaveProcessError:         "保存下载任务进度文件出错！",
+		msgRemoveProcessError:       "删除进度文件失败！请稍后手动删除！",
+		msgStrategySelected:         "已选择下载方式！",
+		msgConcurrencyLimitChanged:  "已调整并发数上限！",
+		msgHedgeStart:               "最后一个分片下载缓慢，对其剩余部分发送对冲请求！",
+		msgHedgeWon:                 "对冲请求先完成了分片的下载！",
+		msgHedgeFailed:              "对冲请求失败！",
+		msgProbeRetrying:            "探测服务器超时，正在重试...",
+		msgInsecureTLS:              "已跳过服务器证书校验，连接可能被窃听或篡改！",
+		msgProxyQuarantined:         "代理服务器连续失败，暂时停止使用！",
+		msgUrlRefreshed:             "下载地址已刷新！",
+		msgUrlRefreshFailed:         "刷新下载地址失败！",
+		msgUrlResolved:              "已记录重定向后的下载地址，之后直接请求该地址",
+		msgResolvedUrlRejected:      "重定向后的下载地址被拒绝，重新从原地址跟随重定向",
+		msgNotModified:              "服务器上的文件未修改，跳过下载",
+		msgSetModTimeError:          "设定下载文件的修改时间失败！",
+		msgSaveMetadataError:        "保存下载文件的校验信息失败！",
+		msgReasonOpenFile:           "打开下载文件失败",
+		msgReasonSeekFile:           "设定文件指针失败",
+		msgReasonSendRequest:        "发送下载请求失败",
+		msgReasonBadStatus:          "状态码错误",
+		msgReasonRangeMismatch:      "响应范围不正确",
+		msgReasonReadBody:           "读取响应体错误",
+		msgReasonWriteFile:          "下载任务写入文件出错",
+		msgReasonFlushFile:          "下载任务刷新文件缓冲区出错",
+		msgReasonStalled:            "下载连接停滞",
+		msgReasonTimeout:            "请求超时",
+		msgReasonUrlExpired:         "下载地址已失效",
+		msgStrategyProgressFound:    "存在已保存的下载进度，恢复该任务",
+		msgStrategyRangeSupported:   "服务器支持分片获取",
+		msgStrategySmallFile:        "文件较小，不足以分为多个分片",
+		msgStrategyUnknownLength:    "文件大小未知，无法分片下载",
+		msgStrategyRangeUnsupported: "服务器不支持分片获取，无法断点续传",
+		msgReasonThroughputImproved: "吞吐量仍在提升",
+		msgReasonThrottled:          "服务器限流或者连接被重置",
+		msgFormatShardRetryError:    "分片%d出现错误！原因：%s，将进行第%d次重试...",
+		msgFormatMonoRetryError:     "单线程下载任务出现错误！原因：%s，将进行第%d次重试...",
+		msgFormatProcessLookup:      "\033[2K当前下载进度：%.2f %% 实际并发数：%d 当前速度：%s 预计剩余时间：%s\n",
+		msgFormatStreamLookup:       "\033[2K已下载：%s 实际并发数：%d 当前速度：%s\n",
+		msgFormatRemainTime:         "%.1f秒",
+		msgRemainTimeUnknown:        "未知",
+	},
+	LanguageEnglish: {
+		msgUnsupportedLanguage:      "unsupported language",
+		msgChecksumError:            "failed to compute file checksum",
+		msgChecksumDone:             "file checksum computed",
+		msgCreateFileError:          "failed to create file",
+		msgTruncateFileError:        "failed to resize file",
+		msgFilePreallocated:         "disk space preallocated for download file",
+		msgReadProcessFileError:     "failed to read process file",
+		msgDeserializeTaskError:     "failed to deserialize task",
+		msgProcessFileFallback:      "process file missing or corrupted, recovering from previous checkpoint",
+		msgProcessFileMigrated:      "process file migrated from an older format",
+		msgSkipProcessFile:          "skipping unrecoverable process file",
+		msgCreateRequestError:       "failed to create request",
+		msgSendRequestError:         "failed to send HTTP request",
+		msgHeadRequestError:         "HEAD request failed",
+		msgHeadNotAllowed:           "HEAD request not allowed, retrying with GET",
+		msgGetLengthError:           "GET request for content length failed",
+		msgContentLength:            "content length resolved",
+		msgUnknownLength:            "server did not report file size, downloading without preallocation",
+		msgRangeProbed:              "server did not advertise range support, probed actively",
+		msgRangeProbeFailed:         "range support probe failed, treating as unsupported for now",
+		msgInvalidProxy:             "invalid proxy configuration",
+		msgUseProxy:                 "downloading through proxy server",
+		msgEnvironmentProxy:         "proxy configuration will be read from environment",
+		msgDisableProxy:             "proxy disabled",
+		msgMonoRecovered:            "single-connection task recovered from process file",
+		msgParallelRecovered:        "parallel task recovered from process file",
+		msgRangeUnsupported:         "server does not support resuming this download",
+		msgConcurrencyAdjusted:      "concurrency exceeds file size, adjusting concurrency",
+		msgShardsAllocated:          "shards allocated",
+		msgShardAlreadyDone:         "shard already completed, skipping",
+		msgShardDownloadStart:       "starting shard downloads",
+		msgShardRetrying:            "shard failed, retrying",
+		msgShardThrottled:           "server throttled, shard will retry after backoff",
+		msgMonoRetrying:             "single-connection download failed, retrying",
+		msgFileDownloaded:           "file downloaded",
+		msgFileIncomplete:           "file download did not complete",
+		msgSaveProcessError:         "failed to save process file",
+		msgRemoveProcessError:       "failed to remove process file, please remove it manually",
+		msgStrategySelected:         "download mode selected",
+		msgConcurrencyLimitChanged:  "concurrency limit adjusted",
+		msgHedgeStart:               "last shard is slow, sending a hedged request for its remaining range",
+		msgHedgeWon:                 "hedged request finished the shard first",
+		msgHedgeFailed:              "hedged request failed",
+		msgProbeRetrying:            "probing server timed out, retrying...",
+		msgInsecureTLS:              "server certificate verification is disabled, the connection may be intercepted or tampered with",
+		msgProxyQuarantined:         "proxy failed repeatedly, quarantined for a while",
+		msgUrlRefreshed:             "download url refreshed",
+		msgUrlRefreshFailed:         "failed to refresh download url",
+		msgUrlResolved:              "recorded redirected download url, requesting it directly from now on",
+		msgResolvedUrlRejected:      "redirected download url rejected, following redirects from the original url again",
+		msgNotModified:              "file not modified on server, download skipped",
+		msgSetModTimeError:          "failed to set modification time of downloaded file",
+		msgSaveMetadataError:        "failed to save metadata of downloaded file",
+		msgReasonOpenFile:           "failed to open download file",
+		msgReasonSeekFile:           "failed to seek download file",
+		msgReasonSendRequest:        "failed to send download request",
+		msgReasonBadStatus:          "unexpected status code",
+		msgReasonRangeMismatch:      "response range mismatch",
+		msgReasonReadBody:           "failed to read response body",
+		msgReasonWriteFile:          "failed to write download file",
+		msgReasonFlushFile:          "failed to flush download file",
+		msgReasonStalled:            "download connection stalled",
+		msgReasonTimeout:            "request timed out",
+		msgReasonUrlExpired:         "download url expired",
+		msgStrategyProgressFound:    "saved progress found, resuming the task",
+		msgStrategyRangeSupported:   "server supports range requests",
+		msgStrategySmallFile:        "file too small to split into multiple shards",
+		msgStrategyUnknownLength:    "file size unknown, cannot split into shards",
+		msgStrategyRangeUnsupported: "server does not support range requests, download cannot be resumed",
+		msgReasonThroughputImproved: "throughput still improving",
+		msgReasonThrottled:          "server throttling or connection reset",
+		msgFormatShardRetryError:    "shard %d failed: %s, retry attempt %d...",
+		msgFormatMonoRetryError:     "single-connection download failed: %s, retry attempt %d...",
+		msgFormatProcessLookup:      "\033[2KProgress: %.2f %% Concurrency: %d Speed: %s ETA: %s\n",
+		msgFormatStreamLookup:       "\033[2KDownloaded: %s Concurrency: %d Speed: %s\n",
+		msgFormatRemainTime:         "%.1fs",
+		msgRemainTimeUnknown:        "unknown",
+	},
+}
+
+// 获取消息键在当前语言下的文本，若当前语言缺少该消息则使用中文文本
+func (key messageKey) text() string {
+	if message, ok := messageCatalog[currentLanguage][key]; ok {
+		return message
+	}
+	return messageCatalog[LanguageChinese][key]
+}
+
+// 使用消息键在当前语言下的文本作为格式化模板，生成格式化字符串
+//
+//   - args 格式化参数
+func (key messageKey) format(args ...any) string {
+	return fmt.Sprintf(key.text(), args...)
+}
\ No newline at end of file
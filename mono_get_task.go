@@ -1,10 +1,8 @@
 package gopher_fetch
 
 import (
-	"errors"
 	"fmt"
 	"gitee.com/swsk33/gopher-notify"
-	"os"
 	"time"
 )
 
@@ -12,6 +10,10 @@ import (
 type MonoGetTask struct {
 	// 继承基本任务对象
 	baseTask
+	// 服务器是否支持分片获取，不支持时每次重试都需要从头下载
+	supportRange bool
+	// 上次重试是否由于刷新了下载地址，避免新的地址仍被拒绝时无限重试
+	urlRefreshed bool
 }
 
 // NewMonoGetTask 构造函数，用于创建单线程下载任务对象
@@ -21,17 +23,21 @@ type MonoGetTask struct {
 //   - processFile 下载进度文件的保存位置，若传入空字符串""表示不记录为进度文件
 func NewMonoGetTask(url, filePath, processFile string) *MonoGetTask {
 	return &MonoGetTask{
-		baseTask{
-			Url:           url,
+		baseTask: baseTask{
+			url:           url,
 			FilePath:      filePath,
 			processFile:   processFile,
+			progressStore: globalProgressStore,
 			isRecover:     false,
+			kind:          taskKindMono,
+			createdAt:     time.Now(),
 			TotalSize:     0,
-			DownloadSize:  0,
-			taskDone:      false,
 			retryCount:    0,
 			statusSubject: gopher_notify.NewSubject[*TaskStatus](GlobalConfig.StatusNotifyDuration),
+			eventSubject:  newEventSubject(),
+			logger:        logger.with(fieldUrl(url)),
 		},
+		supportRange: false,
 	}
 }
 
@@ -57,18 +63,41 @@ func NewSimpleMonoGetTask(url, filePath string) *MonoGetTask {
 //
 //   - file 进度文件位置
 func NewMonoGetTaskFromFile(file string) (*MonoGetTask, error) {
-	// 加载任务
-	task, e := loadTaskFromJson[MonoGetTask](file)
+	return NewMonoGetTaskFromStore(globalProgressStore, file)
+}
+
+// NewMonoGetTaskFromStore 从进度存储读取并恢复一个单线程下载任务对象，恢复后的任务继续使用该进度存储保存进度
+//
+//   - store 进度存储
+//   - key 任务进度的键，即创建任务时传入的processFile参数
+func NewMonoGetTaskFromStore(store ProgressStore, key string) (*MonoGetTask, error) {
+	envelope, e := loadProcessFile(store, key)
 	if e != nil {
 		return nil, e
 	}
-	// 设定对应字段
-	task.processFile = file
-	task.isRecover = true
-	// 创建观察者主题
-	task.statusSubject = gopher_notify.NewSubject[*TaskStatus](GlobalConfig.StatusNotifyDuration)
-	logger.Info("从文件%s恢复单线程下载任务！\n", file)
-	return &task, nil
+	return restoreMonoGetTask(store, key, envelope)
+}
+
+// 从进度文件信封恢复一个单线程下载任务对象
+//
+//   - store 进度存储
+//   - key 任务进度的键
+//   - envelope 读取到的进度文件信封
+func restoreMonoGetTask(store ProgressStore, key string, envelope *processFileEnvelope) (*MonoGetTask, error) {
+	// 加载任务快照
+	snapshot, e := decodeProcessTask[monoTaskSnapshot](envelope, taskKindMono)
+	if e != nil {
+		return nil, e
+	}
+	// 恢复对应配置与状态
+	task := NewMonoGetTask(snapshot.Url, snapshot.FilePath, key)
+	task.progressStore = store
+	task.restoreEnvelope(envelope)
+	task.resolvedUrl = snapshot.ResolvedUrl
+	task.TotalSize = snapshot.TotalSize
+	task.downloadSize.Store(snapshot.DownloadSize)
+	task.logger.info(msgMonoRecovered, fieldPath(key))
+	return task, nil
 }
 
 // 单线程任务重试逻辑
@@ -77,11 +106,18 @@ func NewMonoGetTaskFromFile(file string) (*MonoGetTask, error) {
 //   - e 实际发生的错误
 //
 // 若未达到最大重试次数，则返回可重试错误对象，否则返回实际错误对象
-func (task *MonoGetTask) retry(reason string, e error) error {
+func (task *MonoGetTask) retry(reason messageKey, e error) error {
 	// 未到最大重试次数，返回重试错误
 	if task.retryCount < GlobalConfig.Retry {
 		task.retryCount++
-		return createMonoRetryError(task, reason)
+		task.logger.warn(msgMonoRetrying, fieldAttempt(task.retryCount), fieldReason(reason), fieldError(e))
+		task.publishEvent(&ShardRetryingEvent{
+			Order:   0,
+			Attempt: task.retryCount,
+			Reason:  reason.text(),
+			Error:   e,
+		})
+		return createMonoRetryError(task, reason, e)
 	}
 	// 否则，中断并返回错误
 	return e
@@ -89,45 +125,103 @@ func (task *MonoGetTask) retry(reason string, e error) error {
 
 // 获取下载文件大小
 func (task *MonoGetTask) getLength() error {
-	length, supportRange, e := getContentLength(task.Url)
+	length, supportRange, e := task.probeLength()
 	if e != nil {
 		return e
 	}
+	task.supportRange = supportRange
 	// 不支持断点续传，则重设下载起始位置
 	if !supportRange {
-		task.DownloadSize = 0
-		logger.Warn("下载任务：%s 不支持断点续传！\n", task.Url)
+		task.downloadSize.Store(0)
+		task.logger.warn(msgRangeUnsupported)
 	}
 	// 检查恢复的任务总大小是否和获取的一致
 	if task.isRecover && task.TotalSize != length {
 		return fmt.Errorf("恢复任务文件大小和请求大小不一致！(%d != %d)，请删除进度文件和已下载文件，重新创建任务！", task.TotalSize, length)
 	}
 	// 设定总大小
-	task.TotalSize = length
+	task.setTotalSize(length)
+	task.publishEvent(&LengthResolvedEvent{
+		TotalSize:    length,
+		SupportRange: supportRange,
+	})
 	return nil
 }
 
 // 发送下载请求
 func (task *MonoGetTask) fetchFile() error {
+	// 不支持断点续传时，重试需要从头下载
+	if !task.supportRange {
+		task.downloadSize.Store(0)
+	}
+	start := task.downloadSize.Load()
+	// 文件大小未知时，将文件截断至下载起始位置，清除之前写入的内容，恢复的任务中可能还有保存进度之后写入的部分
+	if task.totalSize() < 0 {
+		e := createBlankFile(task.FilePath, start, task.logger)
+		if e != nil {
+			return e
+		}
+	}
 	// 下载文件
-	errorMessage, e := downloadFile(task.Url, task.FilePath, task.DownloadSize, -1, &task.DownloadSize, &task.taskDone,
-		nil,
+	url := task.requestUrl()
+	errorMessage, e := downloadFile(task.requestContext(), url, task.FilePath, start, -1,
+		func() {
+			task.publishEvent(&ShardStartedEvent{
+				Order:      0,
+				RangeStart: start,
+				RangeEnd:   -1,
+			})
+		},
 		func(addSize int64) {
+			task.downloadSize.Add(addSize)
 			publishMonoTaskStatus(task, false)
 		},
 		func() {
+			// 文件大小未知时，以实际下载的大小作为最终大小
+			if task.totalSize() < 0 {
+				task.setTotalSize(task.downloadSize.Load())
+			}
+			task.taskDone.Store(true)
 			publishMonoTaskStatus(task, true)
-		})
-	// 出现错误视情况返回重试错误
+			task.publishEvent(&ShardDoneEvent{
+				Order:      0,
+				RangeStart: 0,
+				RangeEnd:   -1,
+			})
+		},
+		task.logger)
+	// 出现错误视情况返回重试错误，下载地址被拒绝时，刷新地址后重试，不计入重试次数
 	if e != nil {
+		if isUrlExpiredError(e) && !task.urlRefreshed {
+			if _, ok := task.refreshUrl(url); ok {
+				task.urlRefreshed = true
+				return createMonoRetryError(task, msgReasonUrlExpired, e)
+			}
+		}
+		task.urlRefreshed = false
 		return task.retry(errorMessage, e)
 	}
-	logger.Info("文件%s下载完成！\n", task.FilePath)
+	task.logger.info(msgFileDownloaded, fieldPath(task.FilePath))
 	return nil
 }
 
 // Run 启动单线程下载任务
 func (task *MonoGetTask) Run() error {
+	startTime := time.Now()
+	task.running.Store(true)
+	defer task.running.Store(false)
+	task.publishEvent(&TaskStartedEvent{
+		Url:      task.Url(),
+		FilePath: task.FilePath,
+		Recover:  task.isRecover,
+	})
+	e := task.run()
+	task.publishFinishEvent(e, startTime)
+	return e
+}
+
+// 执行单线程下载任务的全部流程
+func (task *MonoGetTask) run() error {
 	// 获取文件大小
 	e := task.getLength()
 	if e != nil {
@@ -141,42 +235,62 @@ func (task *MonoGetTask) Run() error {
 		}
 	}
 	// 在新的线程中定时保存进度
-	if task.processFile != "" {
-		go func() {
-			for !task.taskDone {
-				// 保存下载文件
-				saveProcessError := saveTaskToJson[*MonoGetTask](task, task.processFile)
-				if saveProcessError != nil {
-					logger.ErrorLine("保存单线程任务进度文件出错！")
-					logger.ErrorLine(saveProcessError.Error())
-				}
-				time.Sleep(350 * time.Millisecond)
+	stopCheckpoint := make(chan struct{})
+	checkpointDone := make(chan struct{})
+	go func() {
+		defer close(checkpointDone)
+		for {
+			task.saveCheckpoint(func() any {
+				return task.snapshot()
+			})
+			select {
+			case <-stopCheckpoint:
+				return
+			case <-time.After(GlobalConfig.checkpointInterval()):
 			}
-		}()
-	}
+		}
+	}()
 	// 下载文件，失败视情况重试
 	for {
 		e = task.fetchFile()
-		if e != nil {
-			// 如果是可重试错误则重试
-			if errors.As(e, &retryErrorType) {
-				logger.ErrorLine(e.Error())
-				continue
-			}
-			// 否则返回错误
-			return e
+		// 如果是可重试错误则重试，否则结束下载
+		if e != nil && isRetryError(e) {
+			continue
 		}
 		break
 	}
-	// 删除进度文件
-	if task.processFile != "" {
-		e = os.Remove(task.processFile)
-		if e != nil {
-			logger.Warn("删除进度文件：%s失败！请稍后手动删除！\n", task.processFile)
-			logger.ErrorLine(e.Error())
-		}
+	// 停止定时保存，并等待最后一次保存完成
+	close(stopCheckpoint)
+	<-checkpointDone
+	if e != nil {
+		// 未能完成下载时，保存最终的进度
+		task.saveCheckpoint(func() any {
+			return task.snapshot()
+		})
+		task.flushProgress()
+		return e
 	}
+	// 删除进度文件
+	task.removeProcessFile()
+	// 时间戳模式下记录文件的校验信息
+	task.saveValidators()
 	// 释放部分资源
 	task.statusSubject.RemoveAll()
 	return nil
+}
+
+// Status 获取该任务当前的下载状态，其中的速度与剩余时间需要持续观察才能计算，因此不会被设定，如需获取请使用 SubscribeStatus 方法
+func (task *MonoGetTask) Status() *TaskStatus {
+	concurrency := 0
+	if task.running.Load() {
+		concurrency = 1
+	}
+	return &TaskStatus{
+		TotalSize:    task.totalSize(),
+		DownloadSize: task.downloadSize.Load(),
+		Concurrency:  concurrency,
+		RemainTime:   -1,
+		IsShutdown:   !task.running.Load(),
+		Connections:  task.connections.stats(),
+	}
 }
\ No newline at end of file
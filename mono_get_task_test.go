@@ -1,6 +1,14 @@
 package gopher_fetch
 
-import "testing"
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 // 测试单线程下载运行
 func TestMonoGetTask_Run(t *testing.T) {
@@ -26,9 +34,9 @@ func TestMonoGetTask_Run(t *testing.T) {
 		return
 	}
 	if result {
-		logger.InfoLine("文件未损坏！")
+		consoleLogger.InfoLine("文件未损坏！")
 	} else {
-		logger.ErrorLine("文件损坏！")
+		consoleLogger.ErrorLine("文件损坏！")
 		t.Error("文件下载损坏！")
 		t.Fail()
 	}
@@ -58,10 +66,113 @@ func TestMonoGetTask_Recover(t *testing.T) {
 		return
 	}
 	if result {
-		logger.InfoLine("文件未损坏！")
+		consoleLogger.InfoLine("文件未损坏！")
 	} else {
-		logger.ErrorLine("文件损坏！")
+		consoleLogger.ErrorLine("文件损坏！")
 		t.Error("文件下载损坏！")
 		t.Fail()
 	}
+}
+
+// 测试单线程下载任务从本地服务器下载，需配合 go test -race 运行
+func TestMonoGetTask_Local(t *testing.T) {
+	server, content := newTestFileServer(t, 2*1024*1024)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewDefaultMonoGetTask(server.URL, filePath)
+	task.SubscribeStatus(func(status *TaskStatus) {})
+	e := task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+}
+
+// 测试下载服务器未给出大小的文件
+func TestMonoGetTask_UnknownLength(t *testing.T) {
+	notifyDuration := GlobalConfig.StatusNotifyDuration
+	GlobalConfig.StatusNotifyDuration = 0
+	defer func() {
+		GlobalConfig.StatusNotifyDuration = notifyDuration
+	}()
+	content := make([]byte, 300*1024+3)
+	rand.New(rand.NewSource(1)).Read(content)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// 使用分块传输编码，不给出Content-Length
+		if request.Method == http.MethodGet {
+			for i := 0; i < len(content); i += 64 * 1024 {
+				_, _ = writer.Write(content[i:min(i+64*1024, len(content))])
+				writer.(http.Flusher).Flush()
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	// 预先存在的旧文件应被清空
+	e := os.WriteFile(filePath, make([]byte, 2*len(content)), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	task := NewDefaultMonoGetTask(server.URL, filePath)
+	var lastStatus *TaskStatus
+	task.SubscribeStatus(func(status *TaskStatus) {
+		lastStatus = status
+	})
+	// 下载完成时设定文件大小，与读取任务状态并发进行，需配合 go test -race 运行
+	stop := make(chan struct{})
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				task.Status()
+				_ = task.snapshot()
+			}
+		}
+	}()
+	e = task.Run()
+	close(stop)
+	<-polled
+	if e != nil {
+		t.Fatal(e)
+	}
+	if task.TotalSize != int64(len(content)) || lastStatus == nil || lastStatus.TotalSize != int64(len(content)) {
+		t.Errorf("下载完成后未确定文件大小：%d %+v", task.TotalSize, lastStatus)
+	}
+	checkDownloadedFile(t, filePath, content)
+}
+
+// 测试恢复服务器不支持断点续传且未给出大小的任务时，从头下载并清空旧文件的内容
+func TestMonoGetTask_RecoverNoRange(t *testing.T) {
+	content := make([]byte, 100*1024)
+	rand.New(rand.NewSource(2)).Read(content)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// 使用分块传输编码，不给出Content-Length
+		if request.Method == http.MethodGet {
+			writer.(http.Flusher).Flush()
+			_, _ = writer.Write(content)
+		}
+	}))
+	t.Cleanup(server.Close)
+	dir := t.TempDir()
+	filePath, processFile := filepath.Join(dir, "file.bin"), filepath.Join(dir, "file.bin.process.json")
+	// 上次下载的内容比本次的响应更长
+	e := os.WriteFile(filePath, make([]byte, 2*len(content)), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	e = saveProcessFile(globalProgressStore, processFile, taskKindMono, time.Now(), &monoTaskSnapshot{Url: server.URL, FilePath: filePath, TotalSize: -1, DownloadSize: 4096})
+	if e != nil {
+		t.Fatal(e)
+	}
+	task, e := NewMonoGetTaskFromFile(processFile)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e = task.Run(); e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
 }
\ No newline at end of file
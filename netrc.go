@@ -0,0 +1,129 @@
+package gopher_fetch
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// NetrcCredentials 从.netrc文件读取的认证信息，按照请求主机名匹配machine项，未匹配时使用default项
+type NetrcCredentials struct {
+	// 各主机的认证信息，键为小写的主机名
+	machines map[string]*Credential
+	// default项的认证信息，不存在时为nil
+	defaultCredential *Credential
+}
+
+// 将.netrc文件内容拆分为记号，支持双引号包围的记号，跳过macdef定义的宏
+//
+//   - content 文件内容
+func netrcTokens(content string) []string {
+	var tokens []string
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		for {
+			line = strings.TrimLeftFunc(line, unicode.IsSpace)
+			if line == "" || line[0] == '#' {
+				break
+			}
+			var token string
+			if line[0] == '"' {
+				end := strings.IndexByte(line[1:], '"')
+				if end < 0 {
+					token, line = line[1:], ""
+				} else {
+					token, line = line[1:end+1], line[end+2:]
+				}
+			} else {
+				end := strings.IndexFunc(line, unicode.IsSpace)
+				if end < 0 {
+					end = len(line)
+				}
+				token, line = line[:end], line[end:]
+			}
+			tokens = append(tokens, token)
+		}
+		// 宏定义持续到下一个空行，其内容不是记号
+		if len(tokens) > 1 && tokens[len(tokens)-2] == "macdef" {
+			tokens = tokens[:len(tokens)-2]
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+			}
+		}
+	}
+	return tokens
+}
+
+// LoadNetrc 读取.netrc文件中的认证信息，支持machine、default、login、password项，其余项会被忽略
+//
+//   - file .netrc文件路径
+func LoadNetrc(file string) (*NetrcCredentials, error) {
+	content, e := os.ReadFile(file)
+	if e != nil {
+		return nil, fmt.Errorf("读取netrc文件%s失败！%w", file, e)
+	}
+	result := &NetrcCredentials{machines: make(map[string]*Credential)}
+	tokens := netrcTokens(string(content))
+	var current *Credential
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] == "default" {
+			current = &Credential{}
+			result.defaultCredential = current
+			continue
+		}
+		if i+1 >= len(tokens) {
+			return nil, fmt.Errorf("netrc文件%s格式错误，%s项缺少值！", file, tokens[i])
+		}
+		value := tokens[i+1]
+		switch tokens[i] {
+		case "machine":
+			current = &Credential{}
+			// 同一主机出现多次时，使用第一项
+			host := strings.ToLower(value)
+			if _, exists := result.machines[host]; !exists {
+				result.machines[host] = current
+			}
+		case "login":
+			if current == nil {
+				return nil, fmt.Errorf("netrc文件%s格式错误，login项应位于machine或者default项之后！", file)
+			}
+			current.Username = value
+		case "password":
+			if current == nil {
+				return nil, fmt.Errorf("netrc文件%s格式错误，password项应位于machine或者default项之后！", file)
+			}
+			current.Password = value
+		}
+		i++
+	}
+	return result, nil
+}
+
+// LoadDefaultNetrc 读取默认位置的.netrc文件，即环境变量NETRC指定的文件，未指定时为用户主目录下的.netrc文件（Windows下为_netrc）
+func LoadDefaultNetrc() (*NetrcCredentials, error) {
+	file := os.Getenv("NETRC")
+	if file == "" {
+		home, e := os.UserHomeDir()
+		if e != nil {
+			return nil, fmt.Errorf("获取用户主目录失败！%w", e)
+		}
+		name := ".netrc"
+		if runtime.GOOS == "windows" {
+			name = "_netrc"
+		}
+		file = filepath.Join(home, name)
+	}
+	return LoadNetrc(file)
+}
+
+// Credential 获取请求主机对应machine项的认证信息，未匹配时使用default项，均不存在时返回nil
+func (netrc *NetrcCredentials) Credential(u *url.URL) (*Credential, error) {
+	if credential, ok := netrc.machines[strings.ToLower(u.Hostname())]; ok {
+		return credential, nil
+	}
+	return netrc.defaultCredential, nil
+}
\ No newline at end of file
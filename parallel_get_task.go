@@ -5,7 +5,8 @@ import (
 	"fmt"
 	tp "gitee.com/swsk33/concurrent-task-pool/v2"
 	"gitee.com/swsk33/gopher-notify"
-	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,11 +19,23 @@ type ParallelGetTask struct {
 	Concurrent int `json:"concurrent"`
 	// 分片请求时间间隔，若设为0则开始下载时所有分片同时开始请求
 	ShardStartDelay time.Duration `json:"shardStartDelay"`
+	// 自适应并发配置，为nil表示始终使用 Concurrent 个并发，否则 Concurrent 为最大并发数
+	Adaptive *AdaptiveConcurrency `json:"adaptive,omitempty"`
 	// 其它状态性质属性
 	// 当前实际并发任务数
-	concurrentTaskCount int
-	// 存放全部分片任务的列表
+	concurrentTaskCount atomic.Int32
+	// 启用自适应并发时，下载过程中使用的并发限制器，未在下载时为nil
+	limiter atomic.Pointer[concurrencyLimiter]
+	// 发布任务状态的锁，保证状态发布与分片速度计算不会并发进行
+	publishLock sync.Mutex
+	// 上次发布任务状态的时间，仅在持有发布锁时访问
+	lastPublishTime time.Time
+	// 是否已发布过结束状态，仅在持有发布锁时访问
+	shutdownPublished bool
+	// 存放全部分片任务的列表，分配分片后不再变化，分配期间需持有 shardLock 访问
 	ShardList []*shardTask `json:"shardList"`
+	// 保护分片列表的锁，分配分片可能与更新分片下载地址、获取任务状态并发进行
+	shardLock sync.Mutex
 	// 接收每个分片任务的下载事件变化的事件总线
 	shardBroker *gopher_notify.Broker[string, int64]
 }
@@ -36,24 +49,46 @@ type ParallelGetTask struct {
 //   - concurrent 多线程下载并发数
 func NewParallelGetTask(url, filePath, processFile string, shardRequestDelay time.Duration, concurrent int) *ParallelGetTask {
 	// 创建任务对象
-	return &ParallelGetTask{
+	task := &ParallelGetTask{
 		baseTask: baseTask{
-			Url:           url,
+			url:           url,
 			FilePath:      filePath,
 			processFile:   processFile,
+			progressStore: globalProgressStore,
 			isRecover:     false,
+			kind:          taskKindParallel,
+			createdAt:     time.Now(),
 			TotalSize:     0,
-			DownloadSize:  0,
-			taskDone:      false,
 			retryCount:    0,
 			statusSubject: gopher_notify.NewSubject[*TaskStatus](GlobalConfig.StatusNotifyDuration),
+			eventSubject:  newEventSubject(),
+			logger:        logger.with(fieldUrl(url)),
 		},
-		Concurrent:          concurrent,
-		ShardStartDelay:     shardRequestDelay,
-		concurrentTaskCount: 0,
-		ShardList:           make([]*shardTask, 0),
-		shardBroker:         gopher_notify.NewBroker[string, int64](concurrent * 3),
+		Concurrent:      concurrent,
+		ShardStartDelay: shardRequestDelay,
+		ShardList:       make([]*shardTask, 0),
+		shardBroker:     gopher_notify.NewBroker[string, int64](concurrent * 3),
 	}
+	task.urlChanged = task.updateShardUrls
+	return task
+}
+
+// NewAdaptiveParallelGetTask 创建一个自适应并发的分片下载任务
+// 文件被分为 maxConcurrent 个分片，开始下载时只使用 minConcurrent 个并发，此后根据吞吐量的变化自动增减并发数
+//
+//   - url 下载地址
+//   - filePath 下载文件的保存路径
+//   - processFile 下载进度文件的保存位置，若传入空字符串""表示不记录为进度文件
+//   - shardRequestDelay 分片请求时间间隔
+//   - minConcurrent 最小并发数
+//   - maxConcurrent 最大并发数
+func NewAdaptiveParallelGetTask(url, filePath, processFile string, shardRequestDelay time.Duration, minConcurrent, maxConcurrent int) *ParallelGetTask {
+	task := NewParallelGetTask(url, filePath, processFile, shardRequestDelay, maxConcurrent)
+	task.Adaptive = &AdaptiveConcurrency{
+		Min: minConcurrent,
+		Max: maxConcurrent,
+	}
+	return task
 }
 
 // NewDefaultParallelGetTask 创建一个并发任务对象
@@ -80,48 +115,89 @@ func NewSimpleParallelGetTask(url, filePath string, concurrent int) *ParallelGet
 //
 // file 进度文件位置
 func NewParallelGetTaskFromFile(file string) (*ParallelGetTask, error) {
-	// 加载任务
-	task, e := loadTaskFromJson[ParallelGetTask](file)
+	return NewParallelGetTaskFromStore(globalProgressStore, file)
+}
+
+// NewParallelGetTaskFromStore 从进度存储读取并恢复一个多线程下载任务对象，恢复后的任务继续使用该进度存储保存进度
+//
+//   - store 进度存储
+//   - key 任务进度的键，即创建任务时传入的processFile参数
+func NewParallelGetTaskFromStore(store ProgressStore, key string) (*ParallelGetTask, error) {
+	envelope, e := loadProcessFile(store, key)
+	if e != nil {
+		return nil, e
+	}
+	return restoreParallelGetTask(store, key, envelope)
+}
+
+// 从进度文件信封恢复一个多线程下载任务对象
+//
+//   - store 进度存储
+//   - key 任务进度的键
+//   - envelope 读取到的进度文件信封
+func restoreParallelGetTask(store ProgressStore, key string, envelope *processFileEnvelope) (*ParallelGetTask, error) {
+	// 加载任务快照
+	snapshot, e := decodeProcessTask[parallelTaskSnapshot](envelope, taskKindParallel)
 	if e != nil {
 		return nil, e
 	}
 	// 恢复对应配置
-	task.processFile = file
-	task.isRecover = true
-	// 恢复对应状态
-	task.DownloadSize = 0
-	for _, shard := range task.ShardList {
-		task.DownloadSize += shard.Status.DownloadSize
+	task := NewParallelGetTask(snapshot.Url, snapshot.FilePath, key, snapshot.ShardStartDelay, snapshot.Concurrent)
+	task.progressStore = store
+	task.restoreEnvelope(envelope)
+	task.resolvedUrl = snapshot.ResolvedUrl
+	task.TotalSize = snapshot.TotalSize
+	if adaptive := snapshot.Adaptive; adaptive != nil {
+		task.Adaptive = &AdaptiveConcurrency{
+			Min:       adaptive.Min,
+			Max:       adaptive.Max,
+			Interval:  adaptive.Interval,
+			Threshold: adaptive.Threshold,
+		}
 	}
-	// 创建事件总线与主题对象
-	task.shardBroker = gopher_notify.NewBroker[string, int64](task.Concurrent * 3)
-	task.statusSubject = gopher_notify.NewSubject[*TaskStatus](GlobalConfig.StatusNotifyDuration)
-	for _, shard := range task.ShardList {
-		shard.statusPublisher = gopher_notify.NewBasePublisher[string, int64](task.shardBroker)
+	// 恢复的任务不再探测服务器，使用快照中的校验信息
+	if validators := snapshot.Validators; validators != nil {
+		task.conditional = &conditionalProbe{received: fileValidators{ETag: validators.ETag, LastModified: validators.LastModified}}
 	}
-	logger.Info("从文件%s恢复多线程下载任务！\n", file)
-	return &task, nil
+	// 恢复每个分片及其状态
+	for _, shardSnapshot := range snapshot.ShardList {
+		shard := newShardTask(shardSnapshot.Config.Url, shardSnapshot.Config.Order, shardSnapshot.Config.FilePath, shardSnapshot.Config.RangeStart, shardSnapshot.Config.RangeEnd, task.shardBroker)
+		shard.restore(shardSnapshot.Status)
+		task.downloadSize.Add(shardSnapshot.Status.DownloadSize)
+		task.ShardList = append(task.ShardList, shard)
+	}
+	task.logger.info(msgParallelRecovered, fieldPath(key))
+	return task, nil
 }
 
 // 获取待下载文件大小
 func (task *ParallelGetTask) getLength() error {
-	length, supportRange, e := getContentLength(task.Url)
+	length, supportRange, e := task.probeLength()
 	if e != nil {
 		return e
 	}
+	task.publishEvent(&LengthResolvedEvent{
+		TotalSize:    length,
+		SupportRange: supportRange,
+	})
 	if !supportRange {
 		return fmt.Errorf("该请求不支持部分获取，无法分片下载！")
 	}
+	if length <= 0 {
+		return fmt.Errorf("无法获取目标文件大小，无法分片下载！")
+	}
 	// 获取成功则设定大小
-	task.TotalSize = length
+	task.setTotalSize(length)
 	return nil
 }
 
 // 获取文件大小并分配任务
 func (task *ParallelGetTask) allocateTask() {
+	task.shardLock.Lock()
+	defer task.shardLock.Unlock()
 	// 检查并发数与大小
 	if int64(task.Concurrent) > task.TotalSize {
-		logger.Warn("并发数：%d大于总大小：%d，将调整并发数为：%d\n", task.Concurrent, task.TotalSize, task.TotalSize)
+		task.logger.warn(msgConcurrencyAdjusted, field("concurrent", task.Concurrent), field("totalSize", task.TotalSize))
 		task.Concurrent = int(task.TotalSize)
 	}
 	// 计算分片下载范围
@@ -129,7 +205,7 @@ func (task *ParallelGetTask) allocateTask() {
 	// 创建分片任务对象
 	for i := 0; i < task.Concurrent; i++ {
 		task.ShardList = append(task.ShardList, newShardTask(
-			task.Url,
+			task.ResolvedUrl(),
 			i+1,
 			task.FilePath,
 			int64(i)*eachSize,
@@ -141,69 +217,156 @@ func (task *ParallelGetTask) allocateTask() {
 	if task.TotalSize%int64(task.Concurrent) != 0 {
 		task.ShardList[task.Concurrent-1].Config.RangeEnd = task.TotalSize - 1
 	}
-	logger.Info("已完成分片计算！分片数：%d\n", task.Concurrent)
+	task.logger.info(msgShardsAllocated, field("shards", task.Concurrent))
 }
 
 // 开始下载全部分片
 func (task *ParallelGetTask) downloadShard() error {
-	// 全局错误
+	// 全局错误，可能被多个分片协程同时设定，只保留第一个错误
 	var totalError error
+	errorLock := &sync.Mutex{}
+	setTotalError := func(e error) {
+		errorLock.Lock()
+		defer errorLock.Unlock()
+		if totalError == nil {
+			totalError = e
+		}
+	}
+	// 为每个分片创建日志对象，并关联所属任务的状态与事件主题
+	for _, shard := range task.ShardList {
+		shard.logger = task.logger.with(fieldOrder(shard.Config.Order), fieldRange(shard.Config.RangeStart, shard.Config.RangeEnd))
+		shard.eventSubject = task.eventSubject
+		shard.parentDownloadSize = &task.downloadSize
+		shard.parentConcurrency = &task.concurrentTaskCount
+		shard.parentContext = task.requestContext()
+		shard.prepareUrl = task.requestUrl
+		shard.refreshUrl = task.refreshUrl
+	}
+	task.publishLock.Lock()
+	task.shutdownPublished = false
+	task.publishLock.Unlock()
+	// 启用自适应并发时，创建并发限制器，任务池的并发数即为最大并发数
+	var limiter *concurrencyLimiter
+	if task.Adaptive != nil {
+		limiter = newConcurrencyLimiter(*task.Adaptive, task.logger)
+		task.limiter.Store(limiter)
+	}
+	// 中断任务时，需唤醒全部等待并发名额以及等待限流退避的分片
+	interrupted := make(chan struct{})
+	closeLimiter := sync.OnceFunc(func() {
+		close(interrupted)
+		if limiter != nil {
+			limiter.close()
+		}
+	})
 	// 创建并发任务池，下载分片数据
 	taskPool := tp.NewTaskPool[*shardTask](task.Concurrent, task.ShardStartDelay, 0, task.ShardList,
 		// 每个分片任务下载逻辑
 		func(shardTask *shardTask, pool *tp.TaskPool[*shardTask]) {
 			// 如果任务已下载完成，则直接退出
-			if shardTask.Status.TaskDone {
-				logger.Warn("分片任务%d已下载完成，无需继续下载！\n", shardTask.Config.Order)
+			if shardTask.Status.getState() == ShardDone {
+				shardTask.logger.warn(msgShardAlreadyDone)
+				return
+			}
+			// 服务器限流时先等待退避时间，等待期间不占用并发名额
+			if !shardTask.waitThrottle(interrupted) {
 				return
 			}
+			// 等待并发名额
+			if limiter != nil {
+				if !limiter.acquire() {
+					return
+				}
+				defer limiter.release()
+			}
 			// 发送分片请求进行下载
 			e := shardTask.getShard()
 			if e != nil {
+				// 服务器限流时降低并发数
+				if limiter != nil && isThrottleError(e) {
+					limiter.backoff()
+				}
 				// 判断是否是可重试错误，若是则执行重试逻辑
-				if errors.As(e, &retryErrorType) {
-					logger.WarnLine(e.Error())
+				if isRetryError(e) {
 					pool.Retry(shardTask)
 					return
 				}
 				// 否则，中断整个任务
-				totalError = e
+				setTotalError(e)
+				closeLimiter()
 				pool.Interrupt()
 			}
 		},
 		// 接收到停机信号处理逻辑
 		func(pool *tp.TaskPool[*shardTask]) {
-			totalError = errors.New("任务被中断！")
+			setTotalError(errors.New("任务被中断！"))
+			closeLimiter()
 		},
 		// 下载时每隔一段时间保存状态
 		func(pool *tp.TaskPool[*shardTask]) {
-			e := saveTaskToJson(task, task.processFile)
-			if e != nil {
-				logger.ErrorLine("保存下载任务出错！")
-				logger.ErrorLine(e.Error())
-			}
-			time.Sleep(350 * time.Millisecond)
+			task.saveCheckpoint(func() any {
+				return task.snapshot()
+			})
+			time.Sleep(GlobalConfig.checkpointInterval())
 		})
 	// 创建订阅者，接收分片任务的下载变化事件
 	task.shardBroker.Subscribe(sizeAdd, &sizeChangeSubscriber{task})
 	task.shardBroker.Subscribe(shardStart, &shardStartSubscriber{task: task})
 	task.shardBroker.Subscribe(shardDone, &shardDoneSubscriber{task})
 	// 启动分片下载任务
-	logger.InfoLine("开始执行分片下载...")
-	// 启动分片下载
+	task.logger.info(msgShardDownloadStart)
+	// 启动分片下载，启用自适应并发时定时调整并发上限，启用对冲请求时监视最后一个分片
+	stopAdjust := make(chan struct{})
+	if limiter != nil {
+		go limiter.run(task.downloadSize.Load, stopAdjust)
+	}
+	// 监视协程在启动前计入等待组，对冲请求在监视协程中执行，下载结束后等待其退出，避免任务返回后仍写入文件
+	hedgeGroup := &sync.WaitGroup{}
+	if GlobalConfig.HedgeAfter > 0 {
+		hedgeGroup.Add(1)
+		go func() {
+			defer hedgeGroup.Done()
+			task.watchLastShard(GlobalConfig.HedgeAfter, stopAdjust, interrupted)
+		}()
+	}
 	taskPool.Start()
+	close(stopAdjust)
+	hedgeGroup.Wait()
+	task.limiter.Store(nil)
 	// 完成下载，发布结束状态
 	publishParallelTaskStatus(task, true)
+	// 未能完成下载时，保存最终的进度
+	if totalError != nil {
+		task.saveCheckpoint(func() any {
+			return task.snapshot()
+		})
+		task.flushProgress()
+	}
 	if !taskPool.IsInterrupt() {
-		logger.Info("文件：%s下载完成！\n", task.FilePath)
+		task.logger.info(msgFileDownloaded, fieldPath(task.FilePath))
 	} else {
-		logger.Warn("文件：%s未能完成下载！\n", task.FilePath)
+		task.logger.warn(msgFileIncomplete, fieldPath(task.FilePath))
 	}
 	return totalError
 }
 
 // Run 开始执行多线程分片下载任务
 func (task *ParallelGetTask) Run() error {
+	startTime := time.Now()
+	task.running.Store(true)
+	defer task.running.Store(false)
+	task.publishEvent(&TaskStartedEvent{
+		Url:      task.Url(),
+		FilePath: task.FilePath,
+		Recover:  task.isRecover,
+	})
+	e := task.run()
+	task.publishFinishEvent(e, startTime)
+	return e
+}
+
+// 执行多线程分片下载任务的全部流程
+func (task *ParallelGetTask) run() error {
 	// 如果是新建的任务，则执行任务分配
 	if !task.isRecover {
 		// 获取文件长度
@@ -225,15 +388,81 @@ func (task *ParallelGetTask) Run() error {
 		return e
 	}
 	// 删除进度文件
-	if task.processFile != "" {
-		e = os.Remove(task.processFile)
-		if e != nil {
-			logger.Warn("删除进度文件：%s失败！请稍后手动删除！\n", task.processFile)
-			logger.ErrorLine(e.Error())
-		}
-	}
+	task.removeProcessFile()
+	// 时间戳模式下记录文件的校验信息
+	task.saveValidators()
 	// 释放部分资源
 	task.statusSubject.RemoveAll()
 	task.shardBroker.Close()
 	return nil
+}
+
+// Status 获取该任务当前的下载状态，其中的速度与剩余时间需要持续观察才能计算，因此不会被设定，如需获取请使用 SubscribeStatus 方法
+func (task *ParallelGetTask) Status() *TaskStatus {
+	return &TaskStatus{
+		TotalSize:    task.totalSize(),
+		DownloadSize: task.downloadSize.Load(),
+		Concurrency:  task.concurrency(),
+		RemainTime:   -1,
+		IsShutdown:   !task.running.Load(),
+		Shards:       task.shardStatus(),
+		Connections:  task.connections.stats(),
+	}
+}
+
+// 获取任务当前的并发数，启用自适应并发时为限制器当前的并发上限，否则为实际并发任务数
+func (task *ParallelGetTask) concurrency() int {
+	if limiter := task.limiter.Load(); limiter != nil {
+		return limiter.currentLimit()
+	}
+	return int(task.concurrentTaskCount.Load())
+}
+
+// 下载地址变化后，更新全部未完成分片的下载地址
+// 持有分片列表的锁后再读取最新的地址，多次更新并发进行时，分片最终使用的总是最新的地址
+func (task *ParallelGetTask) updateShardUrls() {
+	task.shardLock.Lock()
+	defer task.shardLock.Unlock()
+	url := task.ResolvedUrl()
+	for _, shard := range task.ShardList {
+		shard.setUrl(url)
+	}
+}
+
+// 监视最后一个未完成的分片，其运行超过指定时间仍未完成时，对其剩余部分发送一个对冲请求，并等待对冲请求结束后返回
+//
+//   - hedgeAfter 最后一个分片运行超过该时间后发送对冲请求
+//   - stop 停止信号
+//   - interrupted 任务中断信号，中断时取消对冲请求
+func (task *ParallelGetTask) watchLastShard(hedgeAfter time.Duration, stop, interrupted <-chan struct{}) {
+	ticker := time.NewTicker(max(hedgeAfter/4, minStallCheckInterval))
+	defer ticker.Stop()
+	// 最后一个分片及其开始被监视的时间
+	var last *shardTask
+	var since time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			var pending []*shardTask
+			for _, shard := range task.ShardList {
+				if shard.Status.getState() != ShardDone {
+					pending = append(pending, shard)
+				}
+			}
+			if len(pending) != 1 || pending[0].Status.getState() != ShardRunning {
+				last = nil
+				continue
+			}
+			if pending[0] != last {
+				last, since = pending[0], now
+				continue
+			}
+			if now.Sub(since) >= hedgeAfter {
+				last.hedge(interrupted)
+				return
+			}
+		}
+	}
 }
\ No newline at end of file
@@ -1,6 +1,9 @@
 package gopher_fetch
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -28,9 +31,9 @@ func TestParallelGetTask_Run(t *testing.T) {
 		return
 	}
 	if result {
-		logger.InfoLine("文件未损坏！")
+		consoleLogger.InfoLine("文件未损坏！")
 	} else {
-		logger.ErrorLine("文件损坏！")
+		consoleLogger.ErrorLine("文件损坏！")
 		t.Error("文件下载损坏！")
 		t.Fail()
 	}
@@ -60,10 +63,118 @@ func TestParallelGetTask_Recover(t *testing.T) {
 		return
 	}
 	if result {
-		logger.InfoLine("文件未损坏！")
+		consoleLogger.InfoLine("文件未损坏！")
 	} else {
-		logger.ErrorLine("文件损坏！")
+		consoleLogger.ErrorLine("文件损坏！")
 		t.Error("文件下载损坏！")
 		t.Fail()
 	}
+}
+
+// 测试多线程下载任务状态中的分片下载状态
+func TestParallelGetTask_ShardStatus(t *testing.T) {
+	notifyDuration := GlobalConfig.StatusNotifyDuration
+	GlobalConfig.StatusNotifyDuration = 0
+	defer func() {
+		GlobalConfig.StatusNotifyDuration = notifyDuration
+	}()
+	server, content := newTestFileServer(t, 512*1024)
+	task := NewSimpleParallelGetTask(server.URL, filepath.Join(t.TempDir(), "file.bin"), 8)
+	// 记录最终状态
+	var lastStatus *TaskStatus
+	task.SubscribeStatus(func(status *TaskStatus) {
+		if status.IsShutdown {
+			lastStatus = status
+		}
+	})
+	e := task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if lastStatus == nil || len(lastStatus.Shards) != 8 {
+		t.Fatalf("未接收到正确的最终状态：%v", lastStatus)
+	}
+	var total int64
+	for i, shard := range lastStatus.Shards {
+		if shard.Order != i+1 || shard.State != ShardDone {
+			t.Errorf("分片%d状态不正确：%+v", i+1, shard)
+		}
+		if shard.DownloadSize != shard.RangeEnd-shard.RangeStart+1 {
+			t.Errorf("分片%d下载大小不正确：%+v", i+1, shard)
+		}
+		total += shard.DownloadSize
+	}
+	if total != int64(len(content)) {
+		t.Errorf("分片下载大小总和不正确：%d", total)
+	}
+}
+
+// 测试高并发下的状态统计与进度保存，需配合 go test -race 运行
+func TestParallelGetTask_Concurrency(t *testing.T) {
+	notifyDuration := GlobalConfig.StatusNotifyDuration
+	GlobalConfig.StatusNotifyDuration = 0
+	defer func() {
+		GlobalConfig.StatusNotifyDuration = notifyDuration
+	}()
+	server, content := newTestFileServer(t, 4*1024*1024+7)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewDefaultParallelGetTask(server.URL, filePath, 64)
+	// 订阅状态与事件
+	var lastStatus *TaskStatus
+	task.SubscribeStatus(func(status *TaskStatus) {
+		if status.IsShutdown {
+			lastStatus = status
+		}
+	})
+	task.SubscribeEvent(func(event TaskEvent) {})
+	e := task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	if lastStatus == nil || lastStatus.DownloadSize != int64(len(content)) || lastStatus.Concurrency != 0 {
+		t.Errorf("最终状态不正确：%+v", lastStatus)
+	}
+	if _, e = os.Stat(filePath + ".process.json"); !os.IsNotExist(e) {
+		t.Error("下载完成后进度文件未被删除！")
+	}
+}
+
+// 测试任务对象序列化后保持原有的字段形式，并可通过 DownloadSize 方法获取已下载大小
+func TestParallelGetTask_MarshalJSON(t *testing.T) {
+	server, content := newTestFileServer(t, 64*1024)
+	task := NewSimpleParallelGetTask(server.URL, filepath.Join(t.TempDir(), "file.bin"), 4)
+	if e := task.Run(); e != nil {
+		t.Fatal(e)
+	}
+	if task.DownloadSize() != int64(len(content)) {
+		t.Errorf("已下载大小不正确：%d", task.DownloadSize())
+	}
+	data, e := json.Marshal(task)
+	if e != nil {
+		t.Fatal(e)
+	}
+	var result struct {
+		Url          string `json:"url"`
+		TotalSize    int64  `json:"totalSize"`
+		DownloadSize int64  `json:"downloadSize"`
+		Concurrent   int    `json:"concurrent"`
+		ShardList    []struct {
+			Status struct {
+				DownloadSize int64 `json:"downloadSize"`
+				TaskDone     bool  `json:"taskDone"`
+			} `json:"status"`
+		} `json:"shardList"`
+	}
+	if e = json.Unmarshal(data, &result); e != nil {
+		t.Fatal(e)
+	}
+	if result.Url != server.URL || result.TotalSize != int64(len(content)) || result.DownloadSize != int64(len(content)) || result.Concurrent != 4 || len(result.ShardList) != 4 {
+		t.Errorf("序列化的任务不正确：%s", data)
+	}
+	for _, shard := range result.ShardList {
+		if !shard.Status.TaskDone || shard.Status.DownloadSize == 0 {
+			t.Errorf("序列化的分片状态不正确：%s", data)
+		}
+	}
 }
\ No newline at end of file
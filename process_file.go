@@ -0,0 +1,192 @@
+package gopher_fetch
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// 当前的进度文件格式版本
+//
+//   - 版本1：直接保存任务快照，不包含任何元信息（1.8.0及之前的版本）
+//   - 版本2：使用包含格式版本、任务类型、库版本与创建时间的信封包装任务快照
+//   - 版本3：任务快照增加重定向后的下载地址，多线程任务快照增加自适应并发配置与时间戳模式的校验信息，新增字段均可省略
+const processFileVersion = 3
+
+// 当前库的版本号，会被记录至进度文件中
+const libraryVersion = "1.8.0"
+
+// 下载任务的类型，记录在进度文件中，用于恢复任务时识别任务类型
+type taskKind string
+
+// 任务类型常量
+const (
+	// 多线程下载任务
+	taskKindParallel taskKind = "parallel"
+	// 单线程下载任务
+	taskKindMono taskKind = "mono"
+)
+
+// 进度文件的信封，是进度文件在磁盘上的完整形式，任务快照保存在其中的Task字段
+type processFileEnvelope struct {
+	// 进度文件格式版本
+	FormatVersion int `json:"formatVersion"`
+	// 任务类型
+	Kind taskKind `json:"kind"`
+	// 保存该进度文件的库版本，从旧格式升级而来的进度文件为空字符串
+	LibraryVersion string `json:"libraryVersion"`
+	// 任务的创建时间，从旧格式升级而来的进度文件为零值
+	CreatedAt time.Time `json:"createdAt"`
+	// 任务快照，其结构由任务类型决定
+	Task json.RawMessage `json:"task"`
+}
+
+// 进度文件格式的迁移函数，键为迁移前的格式版本，每个函数将进度文件升级至下一个版本
+// 修改任务快照结构时，需要增加 processFileVersion 并在此注册对应的迁移函数
+var processFileMigrations = map[int]func(envelope *processFileEnvelope) error{
+	1: migrateProcessFileV1,
+	2: migrateProcessFileV2,
+}
+
+// 将版本1的进度文件升级至版本2
+// 版本1的进度文件即为任务快照本身，根据是否包含分片列表判断任务类型
+func migrateProcessFileV1(envelope *processFileEnvelope) error {
+	var fields map[string]json.RawMessage
+	e := json.Unmarshal(envelope.Task, &fields)
+	if e != nil {
+		return e
+	}
+	if _, ok := fields["url"]; !ok {
+		return fmt.Errorf("无法识别的进度文件内容！")
+	}
+	if _, ok := fields["shardList"]; ok {
+		envelope.Kind = taskKindParallel
+	} else {
+		envelope.Kind = taskKindMono
+	}
+	return nil
+}
+
+// 将版本2的进度文件升级至版本3
+// 版本3只增加了可省略的字段，版本2的任务快照无需修改，增加版本号是为了使旧版本的库拒绝读取包含新字段的进度文件，而不是静默丢弃这些字段
+func migrateProcessFileV2(envelope *processFileEnvelope) error {
+	return nil
+}
+
+// 解析进度文件内容，若为旧版本格式则依次执行迁移，升级至当前格式
+//
+//   - content 进度文件内容
+//
+// 返回当前格式的进度文件信封
+func parseProcessFile(content []byte) (*processFileEnvelope, error) {
+	// 先读取格式版本，版本1的进度文件不包含该字段
+	var header struct {
+		FormatVersion int `json:"formatVersion"`
+	}
+	e := json.Unmarshal(content, &header)
+	if e != nil {
+		return nil, e
+	}
+	envelope := &processFileEnvelope{}
+	if header.FormatVersion == 0 {
+		envelope.FormatVersion = 1
+		envelope.Task = content
+	} else {
+		e = json.Unmarshal(content, envelope)
+		if e != nil {
+			return nil, e
+		}
+	}
+	if envelope.FormatVersion > processFileVersion {
+		return nil, fmt.Errorf("进度文件格式版本%d高于当前支持的版本%d，请升级gopher-fetch！", envelope.FormatVersion, processFileVersion)
+	}
+	// 依次执行迁移
+	fromVersion := envelope.FormatVersion
+	for envelope.FormatVersion < processFileVersion {
+		migrate, ok := processFileMigrations[envelope.FormatVersion]
+		if !ok {
+			return nil, fmt.Errorf("不支持升级格式版本为%d的进度文件！", envelope.FormatVersion)
+		}
+		e = migrate(envelope)
+		if e != nil {
+			return nil, e
+		}
+		envelope.FormatVersion++
+	}
+	if fromVersion != envelope.FormatVersion {
+		logger.info(msgProcessFileMigrated, field("from", fromVersion), field("to", envelope.FormatVersion))
+	}
+	return envelope, nil
+}
+
+// 将下载任务快照保存至进度存储
+//
+//   - store 进度存储
+//   - key 任务进度的键，若为空字符串""则不会进行任何操作
+//   - kind 任务类型
+//   - createdAt 任务的创建时间
+//   - task 任务快照
+//
+// 出现错误返回错误对象
+func saveProcessFile(store ProgressStore, key string, kind taskKind, createdAt time.Time, task any) error {
+	if key == "" {
+		return nil
+	}
+	// 序列化
+	content, e := json.Marshal(task)
+	if e != nil {
+		return e
+	}
+	content, e = json.Marshal(&processFileEnvelope{
+		FormatVersion:  processFileVersion,
+		Kind:           kind,
+		LibraryVersion: libraryVersion,
+		CreatedAt:      createdAt,
+		Task:           content,
+	})
+	if e != nil {
+		return e
+	}
+	return store.Save(key, content)
+}
+
+// 从进度存储读取进度文件，若为旧版本格式则升级至当前格式
+//
+//   - store 进度存储
+//   - key 任务进度的键
+//
+// 返回进度文件信封，出现错误返回错误对象
+func loadProcessFile(store ProgressStore, key string) (*processFileEnvelope, error) {
+	// 读取内容
+	content, e := store.Load(key)
+	if e != nil {
+		logger.error(msgReadProcessFileError, fieldPath(key), fieldError(e))
+		return nil, e
+	}
+	// 解析并升级格式
+	envelope, e := parseProcessFile(content)
+	if e != nil {
+		logger.error(msgDeserializeTaskError, fieldPath(key), fieldError(e))
+		return nil, e
+	}
+	return envelope, nil
+}
+
+// 从进度文件信封中反序列化任务快照
+//
+//   - T 任务快照类型，不要传递指针
+//   - envelope 进度文件信封
+//   - kind 期望的任务类型，和进度文件记录的类型不一致时返回错误
+//
+// 出现错误返回错误对象
+func decodeProcessTask[T any](envelope *processFileEnvelope, kind taskKind) (T, error) {
+	var task T
+	if envelope.Kind != kind {
+		return task, fmt.Errorf("进度文件记录的任务类型为%s，无法恢复为%s类型的任务！", envelope.Kind, kind)
+	}
+	e := json.Unmarshal(envelope.Task, &task)
+	if e != nil {
+		logger.error(msgDeserializeTaskError, fieldError(e))
+	}
+	return task, e
+}
\ No newline at end of file
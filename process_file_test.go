@@ -0,0 +1,109 @@
+package gopher_fetch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试读取旧版本格式的进度文件，以及进度文件版本与类型的校验
+func TestLoadProcessFile_Migration(t *testing.T) {
+	dir := t.TempDir()
+	// 版本1的多线程任务进度文件
+	parallelPath := filepath.Join(dir, "parallel.process.json")
+	e := os.WriteFile(parallelPath, []byte(`{"url":"http://example.com/a","filePath":"a.bin","totalSize":100,"downloadSize":40,"concurrent":2,"shardStartDelay":0,"shardList":[{"config":{"url":"http://example.com/a","order":1,"filePath":"a.bin","rangeStart":0,"rangeEnd":49},"status":{"downloadSize":40,"taskDone":false}},{"config":{"url":"http://example.com/a","order":2,"filePath":"a.bin","rangeStart":50,"rangeEnd":99},"status":{"downloadSize":0,"taskDone":false}}]}`), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	parallelTask, e := NewParallelGetTaskFromFile(parallelPath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(parallelTask.ShardList) != 2 || parallelTask.downloadSize.Load() != 40 || !parallelTask.isRecover {
+		t.Errorf("恢复旧版本多线程任务不正确：%+v", parallelTask.snapshot())
+	}
+	// 版本1的单线程任务进度文件不能恢复为多线程任务
+	monoPath := filepath.Join(dir, "mono.process.json")
+	e = os.WriteFile(monoPath, []byte(`{"url":"http://example.com/b","filePath":"b.bin","totalSize":100,"downloadSize":60}`), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e = NewParallelGetTaskFromFile(monoPath); e == nil {
+		t.Error("单线程任务的进度文件被恢复为多线程任务！")
+	}
+	monoTask, e := NewMonoGetTaskFromFile(monoPath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if monoTask.downloadSize.Load() != 60 {
+		t.Errorf("恢复旧版本单线程任务不正确：%+v", monoTask.snapshot())
+	}
+	// 保存后升级为当前格式，并保留创建时间
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	monoTask.createdAt = createdAt
+	monoTask.FilePath = filepath.Join(dir, "b.bin")
+	e = os.WriteFile(monoTask.FilePath, make([]byte, 100), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	monoTask.saveCheckpoint(func() any {
+		return monoTask.snapshot()
+	})
+	envelope, e := loadProcessFile(globalProgressStore, monoPath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if envelope.FormatVersion != processFileVersion || envelope.LibraryVersion != libraryVersion || !envelope.CreatedAt.Equal(createdAt) {
+		t.Errorf("进度文件信封不正确：%+v", envelope)
+	}
+	// 版本2的进度文件无需修改内容即可读取
+	v2Path := filepath.Join(dir, "v2.process.json")
+	e = os.WriteFile(v2Path, []byte(`{"formatVersion":2,"kind":"mono","libraryVersion":"1.8.0","createdAt":"2024-01-02T03:04:05Z","task":{"url":"http://example.com/c","filePath":"c.bin","totalSize":100,"downloadSize":30}}`), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	v2Task, e := NewMonoGetTaskFromFile(v2Path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v2Task.downloadSize.Load() != 30 || !v2Task.createdAt.Equal(createdAt) {
+		t.Errorf("恢复版本2单线程任务不正确：%+v", v2Task.snapshot())
+	}
+	// 更高版本的进度文件无法读取
+	futurePath := filepath.Join(dir, "future.process.json")
+	e = os.WriteFile(futurePath, []byte(`{"formatVersion":99,"kind":"mono","task":{}}`), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e = NewMonoGetTaskFromFile(futurePath); e == nil {
+		t.Error("读取了更高版本的进度文件！")
+	}
+}
+
+// 测试多线程任务的快照保存后恢复，分片配置与自适应并发配置保持不变
+func TestParallelGetTask_SnapshotRoundTrip(t *testing.T) {
+	task := NewAdaptiveParallelGetTask("http://example.com/a", "a.bin", "a.process.json", 0, 2, 4)
+	task.Adaptive.Interval, task.Adaptive.Threshold = time.Second, 0.2
+	task.TotalSize = 100
+	task.allocateTask()
+	store := NewMemoryProgressStore()
+	if e := saveProcessFile(store, "a.process.json", taskKindParallel, time.Now(), task.snapshot()); e != nil {
+		t.Fatal(e)
+	}
+	restored, e := NewParallelGetTaskFromStore(store, "a.process.json")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if restored.Adaptive == nil || *restored.Adaptive != *task.Adaptive {
+		t.Errorf("恢复的自适应并发配置不正确：%+v", restored.Adaptive)
+	}
+	if len(restored.ShardList) != len(task.ShardList) {
+		t.Fatalf("恢复的分片数量不正确：%d", len(restored.ShardList))
+	}
+	for i, shard := range restored.ShardList {
+		if shard.Config != task.ShardList[i].Config {
+			t.Errorf("恢复的分片配置不正确：%+v", shard.Config)
+		}
+	}
+}
\ No newline at end of file
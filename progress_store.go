@@ -0,0 +1,341 @@
+package gopher_fetch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrProgressNotFound 读取的任务进度不存在
+var ErrProgressNotFound = errors.New("任务进度不存在！")
+
+// ProgressStore 下载进度存储接口，下载任务通过该接口保存与读取进度，可自定义实现以将进度保存至任意位置
+//
+// 每个任务的进度使用一个键标识，即创建任务时传入的processFile参数，保存的内容为JSON格式的字节
+type ProgressStore interface {
+	// Save 保存一个任务的进度，若已存在则覆盖，实现需要保证保存过程中出错时不会破坏已保存的进度
+	Save(key string, data []byte) error
+	// Load 读取一个任务的进度，若不存在则返回 ErrProgressNotFound
+	Load(key string) ([]byte, error)
+	// Delete 删除一个任务的进度，若不存在则不进行任何操作
+	Delete(key string) error
+	// List 列出全部已保存进度的任务的键
+	List() ([]string, error)
+}
+
+// 全局的默认进度存储，未单独设定进度存储的下载任务都将使用该存储
+var globalProgressStore ProgressStore = NewFileProgressStore("")
+
+// ConfigSetProgressStore 设定全局的默认进度存储，对设定之后创建的下载任务生效
+//
+//   - store 进度存储对象，传入nil表示恢复默认的JSON文件存储
+func ConfigSetProgressStore(store ProgressStore) {
+	if store == nil {
+		store = NewFileProgressStore("")
+	}
+	globalProgressStore = store
+}
+
+// FileProgressStore 将每个任务的进度保存为一个单独JSON文件的进度存储，是默认的进度存储
+// 保存时以原子方式写入并保留上一次的进度作为备份，读取时若进度文件缺失或者损坏则从备份恢复
+type FileProgressStore struct {
+	// 进度文件所在目录
+	dir string
+}
+
+// NewFileProgressStore 创建一个JSON文件进度存储
+//
+//   - dir 进度文件所在目录，任务的键若为相对路径则相对于该目录，传入空字符串""表示相对于当前工作目录
+func NewFileProgressStore(dir string) *FileProgressStore {
+	return &FileProgressStore{dir: dir}
+}
+
+// 获取键对应的进度文件位置
+func (store *FileProgressStore) path(key string) string {
+	if store.dir == "" || filepath.IsAbs(key) {
+		return key
+	}
+	return filepath.Join(store.dir, key)
+}
+
+// Save 实现 ProgressStore 接口
+func (store *FileProgressStore) Save(key string, data []byte) error {
+	return writeFileAtomic(data, store.path(key), 0755)
+}
+
+// Load 实现 ProgressStore 接口
+func (store *FileProgressStore) Load(key string) ([]byte, error) {
+	path := store.path(key)
+	content, e := readFile(path)
+	if e == nil && json.Valid(content) {
+		return content, nil
+	}
+	// 进度文件缺失或者损坏，尝试从备份恢复
+	backupPath := path + backupFileSuffix
+	backupContent, backupError := readFile(backupPath)
+	if backupError == nil && json.Valid(backupContent) {
+		logger.warn(msgProcessFileFallback, fieldPath(backupPath))
+		return backupContent, nil
+	}
+	if os.IsNotExist(e) {
+		return nil, ErrProgressNotFound
+	}
+	if e == nil {
+		e = fmt.Errorf("进度文件%s内容已损坏！", path)
+	}
+	return nil, e
+}
+
+// Delete 实现 ProgressStore 接口
+func (store *FileProgressStore) Delete(key string) error {
+	e := removeProcessFile(store.path(key))
+	if os.IsNotExist(e) {
+		return nil
+	}
+	return e
+}
+
+// List 实现 ProgressStore 接口，列出目录下全部以.json为后缀的进度文件
+func (store *FileProgressStore) List() ([]string, error) {
+	dir := store.dir
+	if dir == "" {
+		dir = "."
+	}
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		return nil, e
+	}
+	keys := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		keys = append(keys, name)
+	}
+	return keys, nil
+}
+
+// MemoryProgressStore 将进度保存在内存中的进度存储，程序退出后进度即丢失，适用于测试
+type MemoryProgressStore struct {
+	// 全部任务的进度
+	data map[string][]byte
+	// 保证并发安全的锁
+	lock sync.RWMutex
+}
+
+// NewMemoryProgressStore 创建一个内存进度存储
+func NewMemoryProgressStore() *MemoryProgressStore {
+	return &MemoryProgressStore{data: make(map[string][]byte)}
+}
+
+// Save 实现 ProgressStore 接口
+func (store *MemoryProgressStore) Save(key string, data []byte) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.data[key] = append([]byte(nil), data...)
+	return nil
+}
+
+// Load 实现 ProgressStore 接口
+func (store *MemoryProgressStore) Load(key string) ([]byte, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	data, ok := store.data[key]
+	if !ok {
+		return nil, ErrProgressNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Delete 实现 ProgressStore 接口
+func (store *MemoryProgressStore) Delete(key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.data, key)
+	return nil
+}
+
+// List 实现 ProgressStore 接口
+func (store *MemoryProgressStore) List() ([]string, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	keys := make([]string, 0, len(store.data))
+	for key := range store.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ProgressFlusher 可以延迟写入任务进度的进度存储，下载任务未完成并结束时会调用 Flush 方法，保证最后一次保存的进度被写入
+type ProgressFlusher interface {
+	// Flush 立即写入全部尚未写入的任务进度
+	Flush() error
+}
+
+// SingleFileProgressStore 将多个任务的进度共同保存在一个JSON文件中的进度存储，文件内容为任务的键到其进度的映射
+// 全部任务的进度缓存在内存中，保存进度时只更新缓存，每个 FetchConfig.CheckpointInterval 内最多以原子方式重写一次文件，
+// 多个任务同时下载时，它们的进度合并为一次写入，删除进度时立即写入文件，同一个文件只应被一个进度存储对象使用
+type SingleFileProgressStore struct {
+	// 保存文件位置
+	path string
+	// 全部任务进度的缓存，为nil表示尚未从文件读取
+	entries map[string]json.RawMessage
+	// 缓存中是否有尚未写入文件的修改
+	dirty bool
+	// 延迟写入文件的定时器，为nil表示没有等待中的写入
+	flushTimer *time.Timer
+	// 延迟写入文件时出现的错误，下次保存或者调用 Flush 时返回
+	flushError error
+	// 保证读写缓存与文件不会并发进行的锁
+	lock sync.Mutex
+}
+
+// NewSingleFileProgressStore 创建一个单文件多任务进度存储
+//
+//   - path 保存全部任务进度的文件位置，文件不存在时会在第一次写入时创建
+func NewSingleFileProgressStore(path string) *SingleFileProgressStore {
+	return &SingleFileProgressStore{path: path}
+}
+
+// 获取全部任务进度的缓存，第一次调用时从文件读取，需要在持有锁时调用
+func (store *SingleFileProgressStore) load() (map[string]json.RawMessage, error) {
+	if store.entries != nil {
+		return store.entries, nil
+	}
+	all := make(map[string]json.RawMessage)
+	content, e := (&FileProgressStore{}).Load(store.path)
+	if e != nil && !errors.Is(e, ErrProgressNotFound) {
+		return nil, e
+	}
+	if e == nil {
+		e = json.Unmarshal(content, &all)
+		if e != nil {
+			return nil, e
+		}
+	}
+	store.entries = all
+	return all, nil
+}
+
+// 将缓存中的全部任务进度写入文件，全部进度均被删除时删除文件，需要在持有锁时调用
+func (store *SingleFileProgressStore) writeAll() error {
+	if store.flushTimer != nil {
+		store.flushTimer.Stop()
+		store.flushTimer = nil
+	}
+	if !store.dirty {
+		return nil
+	}
+	var e error
+	if len(store.entries) == 0 {
+		e = removeProcessFile(store.path)
+	} else {
+		var content []byte
+		content, e = json.Marshal(store.entries)
+		if e == nil {
+			e = writeFileAtomic(content, store.path, 0755)
+		}
+	}
+	if e != nil {
+		return e
+	}
+	store.dirty = false
+	return nil
+}
+
+// 延迟写入文件的定时器到期时调用，出现的错误留待下次保存时返回
+func (store *SingleFileProgressStore) flushPending() {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.flushTimer = nil
+	if e := store.writeAll(); e != nil {
+		store.flushError = e
+	}
+}
+
+// Save 实现 ProgressStore 接口，保存的内容必须是合法的JSON
+// 只更新缓存，并在 FetchConfig.CheckpointInterval 后写入文件，返回的错误可能来自上一次延迟写入
+func (store *SingleFileProgressStore) Save(key string, data []byte) error {
+	if !json.Valid(data) {
+		return fmt.Errorf("保存的任务进度不是合法的JSON内容！")
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	all, e := store.load()
+	if e != nil {
+		return e
+	}
+	all[key] = append(json.RawMessage(nil), data...)
+	store.dirty = true
+	if store.flushTimer == nil {
+		store.flushTimer = time.AfterFunc(GlobalConfig.checkpointInterval(), store.flushPending)
+	}
+	e, store.flushError = store.flushError, nil
+	return e
+}
+
+// Flush 实现 ProgressFlusher 接口，立即将尚未写入的任务进度写入文件
+func (store *SingleFileProgressStore) Flush() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	e := store.writeAll()
+	if e == nil {
+		e, store.flushError = store.flushError, nil
+	}
+	return e
+}
+
+// Load 实现 ProgressStore 接口
+func (store *SingleFileProgressStore) Load(key string) ([]byte, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	all, e := store.load()
+	if e != nil {
+		return nil, e
+	}
+	data, ok := all[key]
+	if !ok {
+		return nil, ErrProgressNotFound
+	}
+	return data, nil
+}
+
+// Delete 实现 ProgressStore 接口，立即写入文件，删除最后一个任务的进度时同时删除文件
+func (store *SingleFileProgressStore) Delete(key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	all, e := store.load()
+	if e != nil {
+		return e
+	}
+	if _, ok := all[key]; !ok {
+		return nil
+	}
+	delete(all, key)
+	store.dirty = true
+	return store.writeAll()
+}
+
+// List 实现 ProgressStore 接口
+func (store *SingleFileProgressStore) List() ([]string, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	all, e := store.load()
+	if e != nil {
+		return nil, e
+	}
+	keys := make([]string, 0, len(all))
+	for key := range all {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
\ No newline at end of file
@@ -0,0 +1,117 @@
+package gopher_fetch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 测试各个进度存储实现的基本行为
+func TestProgressStore(t *testing.T) {
+	dir := t.TempDir()
+	stores := map[string]ProgressStore{
+		"file":       NewFileProgressStore(filepath.Join(dir, "file")),
+		"memory":     NewMemoryProgressStore(),
+		"singleFile": NewSingleFileProgressStore(filepath.Join(dir, "all.process.json")),
+	}
+	e := os.Mkdir(filepath.Join(dir, "file"), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if _, e := store.Load("a.process.json"); !errors.Is(e, ErrProgressNotFound) {
+				t.Errorf("读取不存在的进度应返回ErrProgressNotFound：%v", e)
+			}
+			for _, key := range []string{"a.process.json", "b.process.json", "a.process.json"} {
+				if e := store.Save(key, []byte(`{"key":"`+key+`"}`)); e != nil {
+					t.Fatal(e)
+				}
+			}
+			data, e := store.Load("b.process.json")
+			if e != nil || string(data) != `{"key":"b.process.json"}` {
+				t.Errorf("读取进度不正确：%s %v", data, e)
+			}
+			keys, e := store.List()
+			if e != nil || !reflect.DeepEqual(keys, []string{"a.process.json", "b.process.json"}) {
+				t.Errorf("列出进度不正确：%v %v", keys, e)
+			}
+			for _, key := range []string{"a.process.json", "a.process.json", "b.process.json"} {
+				if e := store.Delete(key); e != nil {
+					t.Fatal(e)
+				}
+			}
+			keys, e = store.List()
+			if e != nil || len(keys) != 0 {
+				t.Errorf("删除进度后仍有剩余：%v %v", keys, e)
+			}
+		})
+	}
+}
+
+// 测试使用自定义进度存储保存并恢复下载任务
+func TestParallelGetTask_FromStore(t *testing.T) {
+	store := NewMemoryProgressStore()
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	e := os.WriteFile(filePath, make([]byte, 100), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	task := NewParallelGetTask("http://example.com/file.bin", filePath, "task-1", 0, 4)
+	task.SetProgressStore(store)
+	task.TotalSize = 100
+	task.allocateTask()
+	task.ShardList[0].Status.downloadSize.Store(10)
+	task.saveCheckpoint(func() any {
+		return task.snapshot()
+	})
+	recovered, e := NewParallelGetTaskFromStore(store, "task-1")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(recovered.ShardList) != 4 || recovered.downloadSize.Load() != 10 || recovered.progressStore != store {
+		t.Errorf("从进度存储恢复任务不正确：%+v", recovered.snapshot())
+	}
+	recovered.removeProcessFile()
+	if keys, _ := store.List(); len(keys) != 0 {
+		t.Errorf("进度未被删除：%v", keys)
+	}
+}
+
+// 测试单文件进度存储合并多个任务的保存，并在 Flush 时写入文件
+func TestSingleFileProgressStore_Batch(t *testing.T) {
+	interval := GlobalConfig.CheckpointInterval
+	GlobalConfig.CheckpointInterval = time.Hour
+	defer func() {
+		GlobalConfig.CheckpointInterval = interval
+	}()
+	path := filepath.Join(t.TempDir(), "all.process.json")
+	store := NewSingleFileProgressStore(path)
+	for i := 0; i < 100; i++ {
+		if e := store.Save(fmt.Sprintf("task-%d", i%10), []byte(fmt.Sprintf(`{"size":%d}`, i))); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if _, e := os.Stat(path); !errors.Is(e, os.ErrNotExist) {
+		t.Fatalf("保存进度时不应立即写入文件：%v", e)
+	}
+	if e := store.Flush(); e != nil {
+		t.Fatal(e)
+	}
+	// 从文件读取写入的进度
+	data, e := NewSingleFileProgressStore(path).Load("task-3")
+	if e != nil || string(data) != `{"size":93}` {
+		t.Errorf("写入文件的进度不正确：%s %v", data, e)
+	}
+	// 删除进度时立即写入文件
+	if e = store.Delete("task-3"); e != nil {
+		t.Fatal(e)
+	}
+	if keys, e := NewSingleFileProgressStore(path).List(); e != nil || len(keys) != 9 {
+		t.Errorf("删除进度后文件中的任务不正确：%v %v", keys, e)
+	}
+}
\ No newline at end of file
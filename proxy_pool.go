@@ -0,0 +1,255 @@
+package gopher_fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的连续失败多少次后隔离代理服务器
+const defaultProxyMaxFailures = 3
+
+// 默认的代理服务器隔离时间
+const defaultProxyCooldown = time.Minute
+
+// ProxyPoolStrategy 从代理池选择代理服务器的策略
+type ProxyPoolStrategy int
+
+// 代理池策略常量
+const (
+	// ProxyRoundRobin 依次轮流使用每个代理服务器
+	ProxyRoundRobin ProxyPoolStrategy = iota
+	// ProxyLeastLoaded 使用当前进行中的请求最少的代理服务器
+	ProxyLeastLoaded
+)
+
+// 代理池中的一个代理服务器
+type pooledProxy struct {
+	// 代理服务器地址
+	url *url.URL
+	// 当前进行中的请求数
+	active int
+	// 连续失败次数
+	failures int
+	// 隔离结束时间，在此之前不会被选择
+	quarantinedUntil time.Time
+}
+
+// ProxyPool 代理池，每个请求从中选择一个代理服务器，多线程下载时不同的分片将通过不同的代理服务器下载
+// 连续失败多次的代理服务器会被隔离一段时间，隔离期间的重试将使用其它代理服务器
+// 同一个代理池可以被多个下载任务共享，此时请求数会在全部任务之间统计
+type ProxyPool struct {
+	// 选择代理服务器的策略
+	Strategy ProxyPoolStrategy
+	// 代理服务器连续失败该次数后将被隔离，为0时使用默认值3
+	MaxFailures int
+	// 代理服务器的隔离时间，为0时使用默认值1分钟
+	Cooldown time.Duration
+	// 全部代理服务器
+	proxies []*pooledProxy
+	// 轮流使用时下一个代理服务器的下标
+	next int
+	// 保护代理服务器状态的锁
+	lock sync.Mutex
+}
+
+// NewProxyPool 创建代理池，代理服务器地址有误时返回错误
+//
+//   - strategy 选择代理服务器的策略
+//   - proxyUrls 代理服务器地址，支持的格式同 ConfigSetProxy ，至少需要一个
+func NewProxyPool(strategy ProxyPoolStrategy, proxyUrls ...string) (*ProxyPool, error) {
+	if len(proxyUrls) == 0 {
+		return nil, fmt.Errorf("代理池中至少需要一个代理服务器！")
+	}
+	pool := &ProxyPool{Strategy: strategy}
+	for _, proxyUrl := range proxyUrls {
+		proxy, e := parseProxyUrl(proxyUrl)
+		if e != nil {
+			return nil, e
+		}
+		if proxy == nil {
+			return nil, fmt.Errorf("代理池中的代理服务器地址不能为空！")
+		}
+		pool.proxies = append(pool.proxies, &pooledProxy{url: proxy})
+	}
+	return pool, nil
+}
+
+// 选择一个代理服务器，并增加其进行中的请求数
+// 全部代理服务器均被隔离时，选择最早结束隔离的代理服务器，而不会改为直接连接
+func (pool *ProxyPool) acquire() *pooledProxy {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	now := time.Now()
+	var chosen *pooledProxy
+	chosenIndex := 0
+	for i := range pool.proxies {
+		index := (pool.next + i) % len(pool.proxies)
+		proxy := pool.proxies[index]
+		if proxy.quarantinedUntil.After(now) {
+			continue
+		}
+		if chosen == nil || (pool.Strategy == ProxyLeastLoaded && proxy.active < chosen.active) {
+			chosen, chosenIndex = proxy, index
+			if pool.Strategy == ProxyRoundRobin {
+				break
+			}
+		}
+	}
+	if chosen == nil {
+		for index, proxy := range pool.proxies {
+			if chosen == nil || proxy.quarantinedUntil.Before(chosen.quarantinedUntil) {
+				chosen, chosenIndex = proxy, index
+			}
+		}
+	}
+	pool.next = chosenIndex + 1
+	chosen.active++
+	return chosen
+}
+
+// 归还代理服务器，并记录请求是否成功，连续失败达到上限时隔离该代理服务器
+//
+//   - proxy 代理服务器
+//   - success 请求是否成功
+//   - log 输出日志的日志对象
+func (pool *ProxyPool) release(proxy *pooledProxy, success bool, log *fetchLogger) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	proxy.active--
+	if success {
+		proxy.failures = 0
+		return
+	}
+	proxy.failures++
+	maxFailures := pool.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = defaultProxyMaxFailures
+	}
+	if proxy.failures < maxFailures {
+		return
+	}
+	cooldown := pool.Cooldown
+	if cooldown <= 0 {
+		cooldown = defaultProxyCooldown
+	}
+	proxy.failures = 0
+	proxy.quarantinedUntil = time.Now().Add(cooldown)
+	log.warn(msgProxyQuarantined, field("proxy", proxy.url.Redacted()), field("cooldown", cooldown))
+}
+
+// 代理服务器拒绝了建立隧道的CONNECT请求
+type proxyConnectError struct {
+	// 代理服务器响应的状态
+	status string
+}
+
+// 实现error接口
+func (e *proxyConnectError) Error() string {
+	return fmt.Sprintf("代理服务器拒绝建立隧道：%s", e.status)
+}
+
+// 检查代理服务器对CONNECT请求的响应，非200时返回 proxyConnectError ，用于区分代理服务器的错误与源站的错误
+func checkProxyConnectResponse(_ context.Context, _ *url.URL, _ *http.Request, response *http.Response) error {
+	if response.StatusCode != http.StatusOK {
+		return &proxyConnectError{status: response.Status}
+	}
+	return nil
+}
+
+// 判断请求的错误是否由代理服务器引起，只有连接代理服务器、CONNECT或者SOCKS5握手失败时才计入
+// 源站的域名解析失败、TLS握手失败以及请求超时等错误与代理服务器无关，不计入，以免一个无法访问的源站使整个代理池被隔离
+//
+//   - e 发送请求时发生的错误
+func isProxyFailure(e error) bool {
+	var connectError *proxyConnectError
+	if errors.As(e, &connectError) {
+		return true
+	}
+	var opError *net.OpError
+	return errors.As(e, &opError) && (opError.Op == "proxyconnect" || strings.HasPrefix(opError.Op, "socks "))
+}
+
+// 判断代理服务器返回的状态码是否表示代理服务器出错
+//
+//   - statusCode 响应状态码
+func isProxyFailureStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusProxyAuthRequired, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// 通过代理池中的代理服务器读取的响应体，关闭时归还代理服务器
+type pooledResponseBody struct {
+	io.ReadCloser
+	// 所属代理池
+	pool *ProxyPool
+	// 使用的代理服务器
+	proxy *pooledProxy
+	// 响应状态码
+	statusCode int
+	// 输出日志的日志对象
+	logger *fetchLogger
+	// 保证只归还一次
+	closeOnce sync.Once
+}
+
+// Close 关闭响应体，并归还代理服务器
+func (body *pooledResponseBody) Close() error {
+	e := body.ReadCloser.Close()
+	body.closeOnce.Do(func() {
+		body.pool.release(body.proxy, !isProxyFailureStatus(body.statusCode), body.logger)
+	})
+	return e
+}
+
+// 上下文中代理池的键类型
+type proxyPoolKey struct{}
+
+// 上下文中本次请求选择的代理服务器的键类型
+type selectedProxyKey struct{}
+
+// 返回一个携带代理池的上下文，使用该上下文发送的每个请求都会从代理池选择代理服务器
+//
+//   - ctx 父上下文
+//   - pool 代理池，为nil时直接返回父上下文
+func withProxyPool(ctx context.Context, pool *ProxyPool) context.Context {
+	if pool == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, proxyPoolKey{}, pool)
+}
+
+// 若请求的上下文携带代理池，则为请求选择代理服务器
+//
+//   - request 将要发送的请求
+//
+// 返回值分别是：
+//   - 上下文携带的代理池，未携带时为nil
+//   - 选择的代理服务器，未携带代理池时为nil
+//   - 携带所选代理服务器的请求，未携带代理池时为原有的请求
+func selectPooledProxy(request *http.Request) (*ProxyPool, *pooledProxy, *http.Request) {
+	pool, ok := request.Context().Value(proxyPoolKey{}).(*ProxyPool)
+	if !ok {
+		return nil, nil, request
+	}
+	proxy := pool.acquire()
+	return pool, proxy, request.WithContext(context.WithValue(request.Context(), selectedProxyKey{}, proxy.url))
+}
+
+// 获取请求选择的代理服务器，即传输层按请求选择代理服务器的钩子，请求未选择时返回false
+//
+//   - request 发送的请求
+func selectedProxy(request *http.Request) (*url.URL, bool) {
+	proxy, ok := request.Context().Value(selectedProxyKey{}).(*url.URL)
+	return proxy, ok
+}
\ No newline at end of file
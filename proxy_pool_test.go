@@ -0,0 +1,145 @@
+package gopher_fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试代理池的选择策略与隔离
+func TestProxyPool(t *testing.T) {
+	pool, e := NewProxyPool(ProxyLeastLoaded, "http://127.0.0.1:1", "http://127.0.0.1:2", "socks5://127.0.0.1:3")
+	if e != nil {
+		t.Fatal(e)
+	}
+	// 最少请求数优先时，三个请求分别使用三个代理服务器
+	first, second, third := pool.acquire(), pool.acquire(), pool.acquire()
+	if first == second || second == third || first == third {
+		t.Fatal("未选择请求最少的代理服务器！")
+	}
+	pool.release(second, true, logger)
+	if pool.acquire() != second {
+		t.Error("未选择请求最少的代理服务器！")
+	}
+	// 连续失败后隔离
+	pool.MaxFailures = 2
+	pool.release(first, false, logger)
+	if !first.quarantinedUntil.IsZero() {
+		t.Fatal("未达到失败次数上限时隔离了代理服务器！")
+	}
+	first.active++
+	pool.release(first, false, logger)
+	if first.quarantinedUntil.IsZero() {
+		t.Fatal("连续失败的代理服务器未被隔离！")
+	}
+	pool.Strategy = ProxyRoundRobin
+	for i := 0; i < 6; i++ {
+		if pool.acquire() == first {
+			t.Fatal("选择了被隔离的代理服务器！")
+		}
+	}
+	// 错误的代理池
+	if _, e := NewProxyPool(ProxyRoundRobin); e == nil {
+		t.Error("空的代理池未返回错误！")
+	}
+	if _, e := NewProxyPool(ProxyRoundRobin, "ftp://127.0.0.1:21"); e == nil {
+		t.Error("错误的代理服务器地址未返回错误！")
+	}
+}
+
+// 测试只有代理服务器引起的错误才计入代理服务器的失败
+func TestIsProxyFailure(t *testing.T) {
+	// 拒绝CONNECT请求的代理服务器
+	rejectProxy := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusForbidden)
+	}))
+	defer rejectProxy.Close()
+	request := func(proxy string) error {
+		proxyUrl, _ := url.Parse(proxy)
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl), OnProxyConnectResponse: checkProxyConnectResponse}}
+		response, e := client.Get("https://127.0.0.1:1/file")
+		if e == nil {
+			response.Body.Close()
+		}
+		return e
+	}
+	cases := []struct {
+		name   string
+		e      error
+		expect bool
+	}{
+		{"代理服务器拒绝CONNECT请求", request(rejectProxy.URL), true},
+		{"无法连接代理服务器", request("http://127.0.0.1:1"), true},
+		{"SOCKS5握手失败", &net.OpError{Op: "socks connect", Err: errors.New("unexpected protocol version")}, true},
+		{"源站域名解析失败", &url.Error{Op: "Get", Err: &net.DNSError{Err: "no such host", Name: "example.invalid"}}, false},
+		{"源站TLS握手失败", &url.Error{Op: "Get", Err: errors.New("tls: failed to verify certificate")}, false},
+		{"请求超时", &TimeoutError{Phase: TimeoutResponseHeader, Err: context.DeadlineExceeded}, false},
+		{"请求被取消", context.Canceled, false},
+	}
+	for _, c := range cases {
+		if isProxyFailure(c.e) != c.expect {
+			t.Errorf("%s：判断结果应为%v，错误：%v", c.name, c.expect, c.e)
+		}
+	}
+	if !isProxyFailureStatus(http.StatusBadGateway) || isProxyFailureStatus(http.StatusNotFound) {
+		t.Error("代理服务器状态码判断错误！")
+	}
+}
+
+// 测试多线程下载时每个分片通过代理池中不同的代理服务器下载，失败的代理服务器被隔离
+func TestParallelGetTask_ProxyPool(t *testing.T) {
+	retry := GlobalConfig.Retry
+	GlobalConfig.Retry = 5
+	defer func() {
+		GlobalConfig.Retry = retry
+	}()
+	content := make([]byte, 1024*1024)
+	for i := range content {
+		content[i] = byte(i % 253)
+	}
+	// 作为HTTP代理服务器，直接返回请求的文件
+	newProxy := func(healthy bool) (*httptest.Server, *atomic.Int32) {
+		requests := &atomic.Int32{}
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			requests.Add(1)
+			if !healthy || request.URL.Host != "files.gopher-fetch.test" {
+				writer.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			http.ServeContent(writer, request, "file.bin", time.Time{}, bytes.NewReader(content))
+		}))
+		t.Cleanup(server.Close)
+		return server, requests
+	}
+	bad, badRequests := newProxy(false)
+	good1, good1Requests := newProxy(true)
+	good2, good2Requests := newProxy(true)
+	pool, e := NewProxyPool(ProxyRoundRobin, bad.URL, good1.URL, good2.URL)
+	if e != nil {
+		t.Fatal(e)
+	}
+	pool.MaxFailures = 1
+	pool.Cooldown = time.Hour
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewParallelGetTask("http://files.gopher-fetch.test/file.bin", filePath, "", 0, 4)
+	task.SetProxyPool(pool)
+	e = task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	if badRequests.Load() != 1 {
+		t.Errorf("失败的代理服务器未被隔离，请求次数：%d", badRequests.Load())
+	}
+	if good1Requests.Load() == 0 || good2Requests.Load() == 0 {
+		t.Errorf("分片未轮流使用代理服务器：%d %d", good1Requests.Load(), good2Requests.Load())
+	}
+}
\ No newline at end of file
@@ -0,0 +1,102 @@
+package gopher_fetch
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 每个主机是否支持分片获取的探测结果缓存，在程序运行期间有效，键为主机名与端口，值为bool
+// 只缓存服务器明确的回应，即忽略Range返回200，或者返回206
+var rangeSupportCache sync.Map
+
+// 发送一个获取第一个字节的GET请求，主动探测服务器是否支持分片获取
+// 用于服务器未在响应头声明Accept-Ranges的情况，响应状态码为206且Content-Range正确时视为支持，
+// 状态码为200时视为不支持，探测结果按主机缓存，其它状态码视为本次不支持但不缓存
+//
+//   - ctx 探测请求的上下文
+//   - requestUrl 请求地址
+//   - needLength 是否需要通过探测得到文件总大小，为true时即使存在缓存的探测结果也会发送探测请求
+//   - log 输出日志的日志对象
+//
+// 返回值分别是：
+//   - 服务器是否支持分片获取
+//   - 从Content-Range或者状态码为200的响应得到的文件总大小，无法得到时返回-1，使用缓存的探测结果时也返回-1
+func probeRangeSupport(ctx context.Context, requestUrl string, needLength bool, log *fetchLogger) (bool, int64) {
+	host := requestUrl
+	if parsed, e := url.Parse(requestUrl); e == nil {
+		host = parsed.Host
+	}
+	if cached, ok := rangeSupportCache.Load(host); ok && !needLength {
+		return cached.(bool), -1
+	}
+	response, e := sendRequestContext(ctx, requestUrl, http.MethodGet, 0, 0, log)
+	// 请求失败时不缓存结果，视为不支持
+	if e != nil {
+		return false, -1
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	supportRange, length := false, int64(-1)
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		supportRange, length = parseContentRange(response.Header.Get("Content-Range"))
+	case http.StatusOK:
+		length = response.ContentLength
+	default:
+		// 服务器出错或者限流等情况下无法得知是否支持，不缓存结果
+		log.warn(msgRangeProbeFailed, field("host", host), field("status", response.StatusCode))
+		return false, -1
+	}
+	rangeSupportCache.Store(host, supportRange)
+	log.info(msgRangeProbed, field("host", host), field("supportRange", supportRange))
+	return supportRange, length
+}
+
+// 判断分片请求响应的Content-Range响应头是否与请求的范围一致，其格式应为：bytes 起始-终止/总大小
+//
+//   - contentRange Content-Range响应头的值
+//   - start 请求的起始位置
+//   - end 请求的终止位置，-1表示直到文件末尾
+func matchContentRange(contentRange string, start, end int64) bool {
+	rangePart, _, found := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "/")
+	if !found {
+		return false
+	}
+	startPart, endPart, found := strings.Cut(rangePart, "-")
+	if !found {
+		return false
+	}
+	responseStart, e := strconv.ParseInt(startPart, 10, 64)
+	if e != nil || responseStart != start {
+		return false
+	}
+	responseEnd, e := strconv.ParseInt(endPart, 10, 64)
+	if e != nil || responseEnd < responseStart {
+		return false
+	}
+	return end < 0 || responseEnd == end
+}
+
+// 解析探测请求响应的Content-Range响应头，其格式应为：bytes 0-0/总大小，总大小未知时为*
+//
+//   - contentRange Content-Range响应头的值
+//
+// 返回值分别是：
+//   - 响应头是否为探测请求对应的范围
+//   - 文件总大小，未知时返回-1
+func parseContentRange(contentRange string) (bool, int64) {
+	rangePart, totalPart, found := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "/")
+	if !found || rangePart != "0-0" {
+		return false, -1
+	}
+	total, e := strconv.ParseInt(totalPart, 10, 64)
+	if e != nil || total <= 0 {
+		return true, -1
+	}
+	return true, total
+}
\ No newline at end of file
@@ -0,0 +1,163 @@
+package gopher_fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrTooManyRedirects 请求的重定向次数超过了 FetchConfig.MaxRedirects ，可通过 errors.Is 从下载任务返回的错误中判断
+var ErrTooManyRedirects = errors.New("重定向次数过多！")
+
+// ErrRedirectNotAllowed 请求被重定向至 FetchConfig.RedirectHosts 之外的主机，可通过 errors.Is 从下载任务返回的错误中判断
+var ErrRedirectNotAllowed = errors.New("不允许重定向至该主机！")
+
+// 上下文中任务原下载地址的键类型
+type originUrlKey struct{}
+
+// 返回一个携带任务原下载地址的上下文，自定义请求头只会发送给原下载地址的主机，即使请求的是重定向后的地址
+// 原下载地址在每个请求发送时重新获取，因此刷新或者更换下载地址后，之后的请求使用新的原下载地址判断
+//
+//   - ctx 父上下文
+//   - origin 获取任务当前原下载地址的函数
+func withOriginUrl(ctx context.Context, origin func() string) context.Context {
+	return context.WithValue(ctx, originUrlKey{}, origin)
+}
+
+// 获取上下文携带的任务当前的原下载地址
+//
+//   - ctx 请求的上下文
+//   - fallback 上下文未携带或者原下载地址无法解析时返回的地址，通常为请求本身的地址
+func originUrlFrom(ctx context.Context, fallback *url.URL) *url.URL {
+	if origin, ok := ctx.Value(originUrlKey{}).(func() string); ok {
+		if u, e := url.Parse(origin()); e == nil {
+			return u
+		}
+	}
+	return fallback
+}
+
+// 获取地址的主机名与端口，主机名转为小写，未指定端口时使用协议的默认端口
+//
+//   - u 地址
+func hostWithPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch strings.ToLower(u.Scheme) {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// 判断两个地址是否属于同一主机，主机名与端口均相同时视为同一主机，未指定端口时视为协议的默认端口
+//
+//   - a, b 比较的地址
+func isSameHost(a, b *url.URL) bool {
+	return hostWithPort(a) == hostWithPort(b)
+}
+
+// 判断自定义请求头是否允许发送给其它主机
+//
+//   - key 请求头名称
+func isCrossHostHeader(key string) bool {
+	for _, name := range GlobalConfig.CrossHostHeaders {
+		if strings.EqualFold(name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按照请求的主机设定自定义请求头，发往原下载地址主机的请求设定全部自定义请求头，发往其它主机的请求只设定 FetchConfig.CrossHostHeaders 中的请求头
+//
+//   - request 将要发送的请求
+//   - origin 任务的原下载地址
+func setCustomHeaders(request *http.Request, origin *url.URL) {
+	sameHost := isSameHost(request.URL, origin)
+	for key, value := range GlobalConfig.Headers {
+		if sameHost || isCrossHostHeader(key) {
+			request.Header.Set(key, value)
+		} else {
+			request.Header.Del(key)
+		}
+	}
+}
+
+// http请求客户端的重定向策略，限制重定向次数与目标主机，并按照目标主机重新设定自定义请求头
+//
+//   - request 即将发送的重定向请求，其请求头复制自第一个请求
+//   - via 已发送的请求，第一个为原请求
+func checkRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) > GlobalConfig.MaxRedirects {
+		return fmt.Errorf("%w 最大重定向次数：%d", ErrTooManyRedirects, GlobalConfig.MaxRedirects)
+	}
+	origin := originUrlFrom(request.Context(), via[0].URL)
+	if !isSameHost(request.URL, origin) && len(GlobalConfig.RedirectHosts) > 0 {
+		allowed := false
+		for _, pattern := range GlobalConfig.RedirectHosts {
+			matched, e := matchHost(pattern, request.URL)
+			if e != nil {
+				return e
+			}
+			if matched {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w 主机：%s", ErrRedirectNotAllowed, request.URL.Host)
+		}
+	}
+	setCustomHeaders(request, origin)
+	return nil
+}
+
+// 获取任务实际请求的下载地址，发生过重定向时为重定向后最终的地址，需持有 urlLock 调用
+func (task *baseTask) targetUrlLocked() string {
+	if task.resolvedUrl != "" {
+		return task.resolvedUrl
+	}
+	return task.url
+}
+
+// ResolvedUrl 获取该任务重定向后最终的下载地址，未发生重定向时与原下载地址相同
+// 探测服务器后记录该地址，全部分片以及从进度文件恢复的任务都直接请求该地址，不再经过重定向
+func (task *baseTask) ResolvedUrl() string {
+	task.urlLock.Lock()
+	defer task.urlLock.Unlock()
+	return task.targetUrlLocked()
+}
+
+// 获取已记录的重定向后的下载地址，未发生重定向时为空，用于保存进度
+func (task *baseTask) resolvedUrlSnapshot() string {
+	task.urlLock.Lock()
+	defer task.urlLock.Unlock()
+	return task.resolvedUrl
+}
+
+// 记录探测服务器时重定向后最终的下载地址，全部未完成的分片都将使用该地址
+//
+//   - resolved 探测请求最终的地址
+func (task *baseTask) setResolvedUrl(resolved string) {
+	task.urlLock.Lock()
+	if resolved == "" || resolved == task.targetUrlLocked() {
+		task.urlLock.Unlock()
+		return
+	}
+	if resolved == task.url {
+		task.resolvedUrl = ""
+	} else {
+		task.resolvedUrl = resolved
+		task.logger.info(msgUrlResolved, field("resolvedUrl", resolved))
+	}
+	task.urlLock.Unlock()
+	task.notifyUrlChanged()
+}
\ No newline at end of file
@@ -0,0 +1,174 @@
+package gopher_fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 创建一个将全部请求重定向至其它地址的测试服务器
+//
+//   - target 返回重定向的目标地址
+//
+// 返回服务器对象与收到的请求次数
+func newRedirectServer(t *testing.T, target func() string) (*httptest.Server, *atomic.Int32) {
+	count := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		count.Add(1)
+		http.Redirect(writer, request, target(), http.StatusFound)
+	}))
+	t.Cleanup(server.Close)
+	return server, count
+}
+
+// 测试重定向次数与目标主机的限制
+func TestGetContentLength_RedirectPolicy(t *testing.T) {
+	maxRedirects, redirectHosts := GlobalConfig.MaxRedirects, GlobalConfig.RedirectHosts
+	defer func() {
+		GlobalConfig.MaxRedirects, GlobalConfig.RedirectHosts = maxRedirects, redirectHosts
+	}()
+	fileServer, content := newTestFileServer(t, 4096)
+	target := fileServer.URL + "/file.bin"
+	server, _ := newRedirectServer(t, func() string {
+		return target
+	})
+	// 不允许重定向
+	GlobalConfig.MaxRedirects = 0
+	_, _, _, e := getContentLength(context.Background(), server.URL, logger)
+	if !errors.Is(e, ErrTooManyRedirects) {
+		t.Errorf("超过最大重定向次数时的错误不正确：%v", e)
+	}
+	// 目标主机不在允许列表中，重定向至原主机不受限制
+	GlobalConfig.MaxRedirects = 10
+	GlobalConfig.RedirectHosts = []string{"*.example.com"}
+	_, _, _, e = getContentLength(context.Background(), server.URL, logger)
+	if !errors.Is(e, ErrRedirectNotAllowed) {
+		t.Errorf("重定向至不允许的主机时的错误不正确：%v", e)
+	}
+	sameHost, _ := newWrappedFileServer(t, 4096, func(fileHandler http.Handler) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			if request.URL.Path == "/file.bin" {
+				fileHandler.ServeHTTP(writer, request)
+				return
+			}
+			http.Redirect(writer, request, "/file.bin", http.StatusFound)
+		}
+	})
+	_, _, resolved, e := getContentLength(context.Background(), sameHost.URL+"/old.bin", logger)
+	if e != nil || resolved != sameHost.URL+"/file.bin" {
+		t.Errorf("重定向至原主机失败！最终地址：%s，错误：%v", resolved, e)
+	}
+	// 目标主机在允许列表中
+	GlobalConfig.RedirectHosts = []string{"127.0.0.1"}
+	length, _, resolved, e := getContentLength(context.Background(), server.URL, logger)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if length != int64(len(content)) || resolved != target {
+		t.Errorf("重定向后获取的文件信息不正确！大小：%d，最终地址：%s", length, resolved)
+	}
+}
+
+// 测试同一主机的判断，以及请求使用任务当前的原下载地址
+func TestOriginUrl(t *testing.T) {
+	cases := map[[2]string]bool{
+		{"https://Example.com/a", "https://example.com:443/b"}: true,
+		{"http://example.com/a", "http://example.com:80/b"}:    true,
+		{"http://example.com/a", "https://example.com/b"}:      false,
+		{"http://example.com:8080/a", "http://example.com/b"}:  false,
+		{"http://[::1]/a", "http://[::1]:80/b"}:                true,
+	}
+	for pair, excepted := range cases {
+		a, _ := url.Parse(pair[0])
+		b, _ := url.Parse(pair[1])
+		if isSameHost(a, b) != excepted {
+			t.Errorf("%s 与 %s 是否为同一主机的判断不正确", pair[0], pair[1])
+		}
+	}
+	// 更换下载地址后，已创建的请求上下文使用新的原下载地址
+	task := NewDefaultMonoGetTask("http://a.example.com/file.bin", filepath.Join(t.TempDir(), "file.bin"))
+	ctx := task.requestContext()
+	task.SetUrl("http://b.example.com/file.bin", time.Time{})
+	if origin := originUrlFrom(ctx, nil); origin == nil || origin.Host != "b.example.com" {
+		t.Errorf("请求使用的原下载地址不正确：%v", origin)
+	}
+}
+
+// 测试分片直接请求重定向后的地址，且只有允许的自定义请求头被发送给其它主机
+func TestParallelGetTask_Redirect(t *testing.T) {
+	headers, crossHostHeaders := GlobalConfig.Headers, GlobalConfig.CrossHostHeaders
+	GlobalConfig.Headers = map[string]string{"X-Secret": "secret", "X-Forward": "forward"}
+	GlobalConfig.CrossHostHeaders = []string{"x-forward"}
+	defer func() {
+		GlobalConfig.Headers, GlobalConfig.CrossHostHeaders = headers, crossHostHeaders
+	}()
+	var leaked, forwarded atomic.Int32
+	fileServer, content := newWrappedFileServer(t, 256*1024, func(fileHandler http.Handler) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			if request.Header.Get("X-Secret") != "" {
+				leaked.Add(1)
+			}
+			if request.Header.Get("X-Forward") == "forward" {
+				forwarded.Add(1)
+			}
+			fileHandler.ServeHTTP(writer, request)
+		}
+	})
+	server, redirects := newRedirectServer(t, func() string {
+		return fileServer.URL + "/file.bin"
+	})
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewParallelGetTask(server.URL+"/download", filePath, "", 0, 4)
+	e := task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	if redirects.Load() != 1 {
+		t.Errorf("分片未直接请求重定向后的地址，重定向次数：%d", redirects.Load())
+	}
+	if task.ResolvedUrl() != fileServer.URL+"/file.bin" || task.snapshot().ResolvedUrl != fileServer.URL+"/file.bin" {
+		t.Errorf("未记录重定向后的地址：%s", task.ResolvedUrl())
+	}
+	if leaked.Load() != 0 || forwarded.Load() == 0 {
+		t.Errorf("发送给其它主机的自定义请求头不正确！泄露次数：%d，转发次数：%d", leaked.Load(), forwarded.Load())
+	}
+}
+
+// 测试重定向后的地址失效时，重新从原地址跟随重定向
+func TestParallelGetTask_ResolvedUrlRejected(t *testing.T) {
+	retry := GlobalConfig.Retry
+	GlobalConfig.Retry = 0
+	defer func() {
+		GlobalConfig.Retry = retry
+	}()
+	signature := &atomic.Value{}
+	signature.Store("a")
+	signedServer, content, forbidden := newSignedServer(t, signature)
+	server, redirects := newRedirectServer(t, func() string {
+		return signedServer.URL + "/file.bin?sig=" + signature.Load().(string)
+	})
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewParallelGetTask(server.URL+"/download", filePath, "", 0, 4)
+	// 分片开始下载时重定向后的地址失效
+	task.SubscribeEvent(func(event TaskEvent) {
+		signature.Store("b")
+	}, EventFilePreallocated)
+	e := task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	if forbidden.Load() == 0 || redirects.Load() < 2 {
+		t.Errorf("未重新跟随重定向！403次数：%d，重定向次数：%d", forbidden.Load(), redirects.Load())
+	}
+	if task.ResolvedUrl() != server.URL+"/download" {
+		t.Errorf("失效的重定向地址未被清除：%s", task.ResolvedUrl())
+	}
+}
\ No newline at end of file
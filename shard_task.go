@@ -1,12 +1,17 @@
 package gopher_fetch
 
 import (
+	"context"
+	"errors"
 	"gitee.com/swsk33/gopher-notify"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // 一个分片下载任务的配置性质属性
 type shardTaskConfig struct {
-	// 下载链接
+	// 下载链接，下载过程中可能被刷新，需持有分片的锁访问
 	Url string `json:"url"`
 	// 分片序号，从1开始
 	Order int `json:"order"`
@@ -19,13 +24,26 @@ type shardTaskConfig struct {
 }
 
 // 一个分片下载任务的状态性质属性
+// 会被分片下载协程、状态发布协程以及进度保存协程并发访问，因此均使用原子类型
 type shardTaskStatus struct {
 	// 已下载的部分（字节）
-	DownloadSize int64 `json:"downloadSize"`
-	// 该任务是否完成
-	TaskDone bool `json:"taskDone"`
+	downloadSize atomic.Int64
 	// 当前分片重试次数
-	retryCount int
+	retryCount atomic.Int32
+	// 当前分片的下载状态
+	state atomic.Int32
+	// 上次发布任务状态时该分片的已下载大小，用于计算分片速度，仅在持有任务状态发布锁时访问
+	lastNotifySize int64
+}
+
+// 获取分片的下载状态
+func (status *shardTaskStatus) getState() ShardState {
+	return ShardState(status.state.Load())
+}
+
+// 设定分片的下载状态
+func (status *shardTaskStatus) setState(state ShardState) {
+	status.state.Store(int32(state))
 }
 
 // shardTask 单个分片下载任务对象
@@ -34,8 +52,36 @@ type shardTask struct {
 	Config shardTaskConfig `json:"config"`
 	// 分片任务执行状态
 	Status shardTaskStatus `json:"status"`
+	// 所属下载任务的已下载大小，分片下载数据时同步累加
+	parentDownloadSize *atomic.Int64
+	// 所属下载任务的实际并发数，分片启动和完成时同步增减
+	parentConcurrency *atomic.Int32
+	// 所属下载任务发送请求使用的上下文，携带任务的http请求客户端与连接统计
+	parentContext context.Context
+	// 获取所属下载任务的请求地址，地址即将过期时会先刷新，并同步更新各分片的地址
+	prepareUrl func() string
+	// 刷新所属下载任务被拒绝的下载地址，返回新的地址以及是否可以重试
+	refreshUrl func(rejected string) (string, bool)
+	// 上次重试是否由于刷新了下载地址，避免新的地址仍被拒绝时无限重试，仅在分片下载协程中访问
+	urlRefreshed bool
+	// 连续遇到服务器限流的次数，仅在分片下载协程中访问
+	throttleCount int
+	// 下次请求前需要等待的限流退避时间，仅在分片下载协程中访问
+	throttleDelay time.Duration
 	// 用于实时发布下载状态变化的发布者
 	statusPublisher *gopher_notify.BasePublisher[string, int64]
+	// 该分片的日志对象，附带分片序号与下载范围
+	logger *fetchLogger
+	// 所属下载任务的生命周期事件主题
+	eventSubject *gopher_notify.Subject[TaskEvent]
+	// 取消当前下载请求的函数，对冲请求先完成时调用
+	cancelDownload context.CancelFunc
+	// 取消对冲请求的函数，分片先完成时调用
+	cancelHedge context.CancelFunc
+	// 是否已经发送过对冲请求
+	hedged bool
+	// 保证分片完成与已下载大小累加不会并发进行的锁，同时保护上述取消函数与分片的下载链接
+	lock sync.Mutex
 }
 
 // newShardTask 分片任务对象构造函数
@@ -48,50 +94,249 @@ func newShardTask(url string, order int, filePath string, rangeStart int64, rang
 			RangeStart: rangeStart,
 			RangeEnd:   rangeEnd,
 		},
-		Status: shardTaskStatus{
-			DownloadSize: 0,
-			TaskDone:     false,
-			retryCount:   0,
-		},
 		statusPublisher: gopher_notify.NewBasePublisher[string, int64](broker),
 	}
 }
 
+// 获取分片当前的下载链接
+func (task *shardTask) url() string {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	return task.Config.Url
+}
+
+// 更新分片的下载链接，已完成的分片不更新
+//
+//   - url 新的下载链接
+func (task *shardTask) setUrl(url string) {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	if task.Status.getState() != ShardDone {
+		task.Config.Url = url
+	}
+}
+
 // 分片重试逻辑
 //
 //   - reason 重试原因
 //   - e 实际发生的错误
 //
 // 若未达到最大重试次数，则返回可重试错误对象，否则返回实际错误对象
-func (task *shardTask) retry(reason string, e error) error {
+func (task *shardTask) retry(reason messageKey, e error) error {
 	// 未到最大重试次数，返回重试错误
-	if task.Status.retryCount < GlobalConfig.Retry {
-		task.Status.retryCount++
-		return createShardRetryError(task, reason)
+	if int(task.Status.retryCount.Load()) < GlobalConfig.Retry {
+		attempt := int(task.Status.retryCount.Add(1))
+		task.Status.setState(ShardRetrying)
+		task.logger.warn(msgShardRetrying, fieldAttempt(attempt), fieldReason(reason), fieldError(e))
+		task.publishEvent(&ShardRetryingEvent{
+			Order:   task.Config.Order,
+			Attempt: attempt,
+			Reason:  reason.text(),
+			Error:   e,
+		})
+		return createShardRetryError(task, reason, e)
 	}
 	// 否则，中断并返回错误
+	task.Status.setState(ShardFailed)
 	return e
 }
 
+// 服务器限流时的重试逻辑，等待一段退避时间后重试，不计入重试次数
+//
+//   - e 实际发生的错误
+//
+// 未达到最大退避次数时返回可重试错误对象，否则返回nil，由调用者按普通错误处理
+func (task *shardTask) throttle(e error) error {
+	if task.throttleCount >= GlobalConfig.ThrottleRetry {
+		return nil
+	}
+	task.throttleCount++
+	task.throttleDelay = throttleBackoff(e, task.throttleCount)
+	task.Status.setState(ShardRetrying)
+	task.logger.warn(msgShardThrottled, fieldAttempt(task.throttleCount), field("delay", task.throttleDelay), fieldError(e))
+	return createShardRetryError(task, msgReasonThrottled, e)
+}
+
+// 等待服务器限流的退避时间结束
+//
+//   - interrupted 任务中断信号，中断时立即停止等待
+//
+// 等待结束或无需等待时返回true，任务被中断返回false
+func (task *shardTask) waitThrottle(interrupted <-chan struct{}) bool {
+	if task.throttleDelay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(task.throttleDelay)
+	defer timer.Stop()
+	task.throttleDelay = 0
+	select {
+	case <-timer.C:
+		return true
+	case <-interrupted:
+		return false
+	}
+}
+
+// 计算服务器限流后的退避时间，服务器给出 Retry-After 响应头时以其为准，
+// 否则从 ThrottleBackoff 开始每次翻倍，最长不超过 maxThrottleBackoff
+//
+//   - e 实际发生的错误
+//   - attempt 连续遇到限流的次数，从1开始
+func throttleBackoff(e error, attempt int) time.Duration {
+	var statusError *StatusCodeError
+	if errors.As(e, &statusError) && statusError.RetryAfter > 0 {
+		return statusError.RetryAfter
+	}
+	delay := GlobalConfig.ThrottleBackoff
+	for i := 1; i < attempt && delay < maxThrottleBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxThrottleBackoff)
+}
+
+// 发布一个生命周期事件至所属下载任务的事件主题
+//
+//   - event 发布的事件
+func (task *shardTask) publishEvent(event TaskEvent) {
+	task.eventSubject.UpdateAndNotify(event, false)
+}
+
+// 累加分片的已下载大小，分片已完成时忽略，避免对冲请求完成后原请求继续累加
+//
+//   - addSize 本次下载的字节数
+func (task *shardTask) addSize(addSize int64) {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	if task.Status.getState() == ShardDone {
+		return
+	}
+	task.Status.downloadSize.Add(addSize)
+	task.parentDownloadSize.Add(addSize)
+	task.statusPublisher.Publish(gopher_notify.NewEvent(sizeAdd, addSize), false)
+}
+
+// 标记分片完成，并取消仍在进行的另一个请求，分片下载请求与对冲请求中只有先完成者生效
+//
+// 本次调用使分片完成时返回true，分片此前已完成返回false
+func (task *shardTask) finish() bool {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	if task.Status.getState() == ShardDone {
+		return false
+	}
+	// 对冲请求先完成时，原请求尚未累加的部分需要补齐
+	remain := task.Config.RangeEnd - task.Config.RangeStart + 1 - task.Status.downloadSize.Load()
+	if remain > 0 {
+		task.Status.downloadSize.Add(remain)
+		task.parentDownloadSize.Add(remain)
+	}
+	task.Status.setState(ShardDone)
+	for _, cancel := range []context.CancelFunc{task.cancelDownload, task.cancelHedge} {
+		if cancel != nil {
+			cancel()
+		}
+	}
+	task.statusPublisher.Publish(gopher_notify.NewEvent(shardDone, int64(0)), false)
+	task.publishEvent(&ShardDoneEvent{
+		Order:      task.Config.Order,
+		RangeStart: task.Config.RangeStart,
+		RangeEnd:   task.Config.RangeEnd,
+	})
+	return true
+}
+
 // 下载对应分片，该方法在并发任务池中作为一个异步任务并发调用
 func (task *shardTask) getShard() error {
+	ctx, cancel := context.WithCancel(task.parentContext)
+	defer cancel()
+	task.lock.Lock()
+	task.cancelDownload = cancel
+	task.lock.Unlock()
+	// 下载地址即将过期时先刷新
+	if task.prepareUrl != nil {
+		task.prepareUrl()
+	}
+	url := task.url()
 	// 进行下载
-	errorMessage, e := downloadFile(task.Config.Url, task.Config.FilePath, task.Config.RangeStart+task.Status.DownloadSize, task.Config.RangeEnd, &task.Status.DownloadSize, &task.Status.TaskDone,
+	errorMessage, e := downloadFile(ctx, url, task.Config.FilePath, task.Config.RangeStart+task.Status.downloadSize.Load(), task.Config.RangeEnd,
 		func() {
-			// 发布分片启动事件
+			// 更新状态并发布分片启动事件
+			task.Status.setState(ShardRunning)
+			task.parentConcurrency.Add(1)
 			task.statusPublisher.Publish(gopher_notify.NewEvent(shardStart, int64(0)), false)
+			task.publishEvent(&ShardStartedEvent{
+				Order:      task.Config.Order,
+				RangeStart: task.Config.RangeStart,
+				RangeEnd:   task.Config.RangeEnd,
+			})
 		},
-		func(addSize int64) {
-			// 发布下载大小变化事件
-			task.statusPublisher.Publish(gopher_notify.NewEvent(sizeAdd, addSize), false)
-		},
+		task.addSize,
 		func() {
-			// 发布分片任务完成事件
-			task.statusPublisher.Publish(gopher_notify.NewEvent(shardDone, int64(0)), false)
-		})
+			// 减少实际并发数，并更新状态发布分片任务完成事件
+			task.parentConcurrency.Add(-1)
+			task.finish()
+		},
+		task.logger)
 	// 视情况重试
 	if e != nil {
+		// 分片已启动但未能完成，减少实际并发数
+		task.parentConcurrency.Add(-1)
+		// 对冲请求已完成该分片
+		if task.Status.getState() == ShardDone {
+			return nil
+		}
+		// 下载地址被拒绝时，刷新地址后重试，不计入重试次数
+		if isUrlExpiredError(e) && !task.urlRefreshed && task.refreshUrl != nil {
+			if _, ok := task.refreshUrl(url); ok {
+				task.urlRefreshed = true
+				return createShardRetryError(task, msgReasonUrlExpired, e)
+			}
+		}
+		task.urlRefreshed = false
+		// 服务器限流时，退避一段时间后重试，不计入重试次数
+		if isThrottleError(e) {
+			if throttleError := task.throttle(e); throttleError != nil {
+				return throttleError
+			}
+		}
+		task.throttleCount = 0
 		return task.retry(errorMessage, e)
 	}
 	return nil
+}
+
+// 对分片的剩余部分发送一个对冲请求，与分片原本的下载请求竞争，先完成者生效
+// 对冲请求写入的数据与原请求相同，因此两者同时写入文件不会造成错误，对冲请求的进度在其完成时一并计入
+//
+//   - interrupted 任务中断信号，中断时立即取消对冲请求
+func (task *shardTask) hedge(interrupted <-chan struct{}) {
+	ctx, cancel := context.WithCancel(task.parentContext)
+	defer cancel()
+	go func() {
+		select {
+		case <-interrupted:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	task.lock.Lock()
+	if task.hedged || task.Status.getState() == ShardDone {
+		task.lock.Unlock()
+		return
+	}
+	task.hedged = true
+	task.cancelHedge = cancel
+	start := task.Config.RangeStart + task.Status.downloadSize.Load()
+	task.lock.Unlock()
+	task.logger.info(msgHedgeStart, field("hedgeStart", start))
+	_, e := downloadFile(ctx, task.url(), task.Config.FilePath, start, task.Config.RangeEnd, nil, func(int64) {},
+		func() {
+			if task.finish() {
+				task.logger.info(msgHedgeWon)
+			}
+		},
+		task.logger)
+	if e != nil && task.Status.getState() != ShardDone {
+		task.logger.warn(msgHedgeFailed, fieldError(e))
+	}
 }
\ No newline at end of file
@@ -0,0 +1,145 @@
+package gopher_fetch
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 时钟接口，用于获取当前时间，可注入自定义实现以便进行确定性的测试
+type Clock interface {
+	// Now 返回当前时间
+	Now() time.Time
+}
+
+// 使用系统时间的默认时钟
+type systemClock struct{}
+
+// Now 实现 Clock 接口
+func (clock systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// 一次速度采样
+type speedSample struct {
+	// 采样时间
+	time time.Time
+	// 采样时的已下载大小（字节）
+	size int64
+}
+
+// SpeedEstimator 下载速度估算器，基于滑动窗口与指数移动平均计算平滑的下载速度，并计算平均速度与剩余时间
+type SpeedEstimator struct {
+	// 滑动窗口长度
+	window time.Duration
+	// 指数移动平均的平滑系数
+	smoothing float64
+	// 获取当前时间的时钟
+	clock Clock
+	// 开始计算时的时间
+	startTime time.Time
+	// 开始计算时的已下载大小（字节）
+	startSize int64
+	// 滑动窗口内的采样
+	samples []speedSample
+	// 平滑后的速度，单位：字节/毫秒
+	speed float64
+	// 是否已计算出速度
+	hasSpeed bool
+	// 保证并发安全的锁
+	lock sync.Mutex
+}
+
+// NewSpeedEstimator 创建一个下载速度估算器
+//
+//   - window 滑动窗口长度，每次更新时先计算该窗口内的平均速度，若小于等于0则只使用最近一次更新的间隔
+//   - smoothing 指数移动平均的平滑系数，取值范围(0, 1]，越小则速度变化越平滑，设为1表示不进行平滑
+//   - clock 获取当前时间的时钟，传入nil表示使用系统时间
+//   - startSize 开始计算时的已下载大小（字节）
+func NewSpeedEstimator(window time.Duration, smoothing float64, clock Clock, startSize int64) *SpeedEstimator {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 1
+	}
+	now := clock.Now()
+	return &SpeedEstimator{
+		window:    window,
+		smoothing: smoothing,
+		clock:     clock,
+		startTime: now,
+		startSize: startSize,
+		samples:   []speedSample{{time: now, size: startSize}},
+	}
+}
+
+// 使用全局配置创建一个下载速度估算器
+//
+//   - startSize 开始计算时的已下载大小（字节）
+func newDefaultSpeedEstimator(startSize int64) *SpeedEstimator {
+	return NewSpeedEstimator(GlobalConfig.SpeedWindow, GlobalConfig.SpeedSmoothing, nil, startSize)
+}
+
+// Update 记录当前的已下载大小，并更新速度
+//
+//   - downloadSize 当前的已下载大小（字节）
+func (estimator *SpeedEstimator) Update(downloadSize int64) {
+	estimator.lock.Lock()
+	defer estimator.lock.Unlock()
+	now := estimator.clock.Now()
+	estimator.samples = append(estimator.samples, speedSample{time: now, size: downloadSize})
+	// 移除滑动窗口之外的采样，至少保留一个窗口起点之前的采样作为基准
+	for len(estimator.samples) > 2 && !estimator.samples[1].time.After(now.Add(-estimator.window)) {
+		estimator.samples = estimator.samples[1:]
+	}
+	first := estimator.samples[0]
+	elapsed := now.Sub(first.time)
+	if elapsed <= 0 {
+		return
+	}
+	windowSpeed := float64(downloadSize-first.size) / (float64(elapsed) / float64(time.Millisecond))
+	// 指数移动平均
+	if !estimator.hasSpeed {
+		estimator.speed = windowSpeed
+		estimator.hasSpeed = true
+	} else {
+		estimator.speed = estimator.smoothing*windowSpeed + (1-estimator.smoothing)*estimator.speed
+	}
+}
+
+// Speed 返回平滑后的当前下载速度，单位：字节/毫秒
+func (estimator *SpeedEstimator) Speed() float64 {
+	estimator.lock.Lock()
+	defer estimator.lock.Unlock()
+	return estimator.speed
+}
+
+// AverageSpeed 返回自开始计算以来的平均下载速度，单位：字节/毫秒
+func (estimator *SpeedEstimator) AverageSpeed() float64 {
+	estimator.lock.Lock()
+	defer estimator.lock.Unlock()
+	last := estimator.samples[len(estimator.samples)-1]
+	elapsed := last.time.Sub(estimator.startTime)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.size-estimator.startSize) / (float64(elapsed) / float64(time.Millisecond))
+}
+
+// RemainTime 根据平滑后的下载速度计算剩余下载时间
+//
+//   - totalSize 下载文件的总大小（字节）
+//
+// 返回剩余时间，若下载速度为0或者总大小未知，则返回-1
+func (estimator *SpeedEstimator) RemainTime(totalSize int64) time.Duration {
+	estimator.lock.Lock()
+	defer estimator.lock.Unlock()
+	if estimator.speed <= 0 || totalSize < 0 {
+		return -1
+	}
+	remain := totalSize - estimator.samples[len(estimator.samples)-1].size
+	if remain <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remain) / estimator.speed * float64(time.Millisecond))
+}
\ No newline at end of file
@@ -0,0 +1,66 @@
+package gopher_fetch
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// 测试用的手动时钟
+type manualClock struct {
+	// 当前时间
+	now time.Time
+}
+
+// Now 实现 Clock 接口
+func (clock *manualClock) Now() time.Time {
+	return clock.now
+}
+
+// 将时钟向后拨动
+func (clock *manualClock) advance(duration time.Duration) {
+	clock.now = clock.now.Add(duration)
+}
+
+// 测试滑动窗口与指数移动平均的速度计算
+func TestSpeedEstimator(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	estimator := NewSpeedEstimator(2*time.Second, 0.5, clock, 0)
+	// 前两秒每秒下载1000字节，速度为1字节/毫秒
+	clock.advance(time.Second)
+	estimator.Update(1000)
+	if estimator.Speed() != 1 {
+		t.Errorf("首次速度不正确：%f", estimator.Speed())
+	}
+	clock.advance(time.Second)
+	estimator.Update(2000)
+	if estimator.Speed() != 1 {
+		t.Errorf("速度不正确：%f", estimator.Speed())
+	}
+	// 第三秒下载4000字节，窗口为最近两秒，窗口速度为(6000-1000)/2000=2.5字节/毫秒
+	clock.advance(time.Second)
+	estimator.Update(6000)
+	if math.Abs(estimator.Speed()-1.75) > 1e-9 {
+		t.Errorf("平滑速度不正确：%f", estimator.Speed())
+	}
+	if estimator.AverageSpeed() != 2 {
+		t.Errorf("平均速度不正确：%f", estimator.AverageSpeed())
+	}
+	// 剩余7000字节，速度1.75字节/毫秒，剩余4秒
+	if estimator.RemainTime(13000) != 4*time.Second {
+		t.Errorf("剩余时间不正确：%s", estimator.RemainTime(13000))
+	}
+	if estimator.RemainTime(-1) != -1 {
+		t.Error("总大小未知时剩余时间应为-1")
+	}
+}
+
+// 测试剩余时间的格式化，无法估算时输出未知
+func TestFormatRemainTime(t *testing.T) {
+	if text := formatRemainTime(&TaskStatus{RemainTime: 1500 * time.Millisecond, RemainTimeKnown: true}); text != "1.5秒" {
+		t.Errorf("剩余时间格式化不正确：%s", text)
+	}
+	if text := formatRemainTime(&TaskStatus{RemainTime: -1}); text != msgRemainTimeUnknown.text() {
+		t.Errorf("无法估算的剩余时间格式化不正确：%s", text)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,150 @@
+package gopher_fetch
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 检查连接是否停滞的最小间隔
+const minStallCheckInterval = 10 * time.Millisecond
+
+// StallError 下载连接停滞，即一段时间内没有读取到数据或者速度过低，属于可重试的错误
+type StallError struct {
+	// 停滞的持续时间
+	Duration time.Duration
+	// 停滞期间的平均速度，单位：字节/秒
+	Speed float64
+}
+
+// 实现error接口
+func (e *StallError) Error() string {
+	return fmt.Sprintf("下载连接停滞！%s内的速度仅为%.2f字节/秒", e.Duration, e.Speed)
+}
+
+// 连接停滞监视器，定时检查读取进度，发现停滞时取消下载请求
+type stallWatchdog struct {
+	// 空闲读取超时
+	idleTimeout time.Duration
+	// 最低速度，单位：字节/秒
+	minSpeed int64
+	// 计算最低速度的时间窗口
+	speedWindow time.Duration
+	// 取消下载请求的函数
+	cancel func()
+	// 已读取的字节数
+	received atomic.Int64
+	// 最后一次读取到数据的时间，Unix纳秒
+	lastRead atomic.Int64
+	// 发现停滞时记录的错误
+	stall atomic.Pointer[StallError]
+	// 停止监视的信号
+	done chan struct{}
+	// 保证只停止一次
+	stopOnce sync.Once
+}
+
+// 根据全局配置启动一个连接停滞监视器，若未配置空闲读取超时与最低速度，则返回nil
+//
+//   - cancel 发现停滞时调用的取消下载请求的函数
+func startStallWatchdog(cancel func()) *stallWatchdog {
+	idleTimeout := GlobalConfig.IdleReadTimeout
+	minSpeed := GlobalConfig.MinSpeed
+	speedWindow := GlobalConfig.MinSpeedWindow
+	if minSpeed <= 0 || speedWindow <= 0 {
+		minSpeed, speedWindow = 0, 0
+	}
+	if idleTimeout <= 0 && minSpeed <= 0 {
+		return nil
+	}
+	watchdog := &stallWatchdog{
+		idleTimeout: idleTimeout,
+		minSpeed:    minSpeed,
+		speedWindow: speedWindow,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	watchdog.lastRead.Store(time.Now().UnixNano())
+	go watchdog.run()
+	return watchdog
+}
+
+// 记录一次读取
+//
+//   - size 本次读取的字节数
+func (watchdog *stallWatchdog) record(size int64) {
+	if watchdog == nil || size <= 0 {
+		return
+	}
+	watchdog.received.Add(size)
+	watchdog.lastRead.Store(time.Now().UnixNano())
+}
+
+// 返回发现停滞时记录的错误，未停滞返回nil
+func (watchdog *stallWatchdog) stallError() *StallError {
+	if watchdog == nil {
+		return nil
+	}
+	return watchdog.stall.Load()
+}
+
+// 停止监视
+func (watchdog *stallWatchdog) stop() {
+	if watchdog == nil {
+		return
+	}
+	watchdog.stopOnce.Do(func() {
+		close(watchdog.done)
+	})
+}
+
+// 定时检查是否停滞，直到停止监视或者发现停滞
+func (watchdog *stallWatchdog) run() {
+	interval := watchdog.idleTimeout
+	if interval <= 0 || (watchdog.speedWindow > 0 && watchdog.speedWindow < interval) {
+		interval = watchdog.speedWindow
+	}
+	interval = max(interval/4, minStallCheckInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// 时间窗口内的采样，第一个采样为窗口起点之前的最后一个采样
+	samples := []speedSample{{time: time.Now(), size: 0}}
+	for {
+		select {
+		case <-watchdog.done:
+			return
+		case now := <-ticker.C:
+			size := watchdog.received.Load()
+			// 检查空闲读取超时
+			idle := now.Sub(time.Unix(0, watchdog.lastRead.Load()))
+			if watchdog.idleTimeout > 0 && idle > watchdog.idleTimeout {
+				watchdog.trigger(&StallError{Duration: idle, Speed: 0})
+				return
+			}
+			if watchdog.minSpeed <= 0 {
+				continue
+			}
+			// 检查时间窗口内的速度
+			samples = append(samples, speedSample{time: now, size: size})
+			for len(samples) > 2 && !samples[1].time.After(now.Add(-watchdog.speedWindow)) {
+				samples = samples[1:]
+			}
+			elapsed := now.Sub(samples[0].time)
+			if elapsed < watchdog.speedWindow {
+				continue
+			}
+			speed := float64(size-samples[0].size) / elapsed.Seconds()
+			if speed < float64(watchdog.minSpeed) {
+				watchdog.trigger(&StallError{Duration: elapsed, Speed: speed})
+				return
+			}
+		}
+	}
+}
+
+// 记录停滞错误并取消下载请求
+func (watchdog *stallWatchdog) trigger(e *StallError) {
+	watchdog.stall.Store(e)
+	watchdog.cancel()
+}
\ No newline at end of file
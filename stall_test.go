@@ -0,0 +1,116 @@
+package gopher_fetch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 创建一个测试服务器，对最后一个分片的第一次请求只返回一小部分数据，然后一直等待直到请求被取消
+//
+//   - content 下载文件内容
+//   - requests 记录最后一个分片被请求的次数
+func newStallServer(t *testing.T, content []byte, requests *atomic.Int32) *httptest.Server {
+	lastRange := fmt.Sprintf("-%d", len(content)-1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		rangeHeader := request.Header.Get("Range")
+		if request.Method != http.MethodGet || rangeHeader == "bytes=0-0" || !strings.HasSuffix(rangeHeader, lastRange) || requests.Add(1) > 1 {
+			http.ServeContent(writer, request, "file.bin", time.Time{}, bytes.NewReader(content))
+			return
+		}
+		start, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rangeHeader, "bytes="), lastRange))
+		writer.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		writer.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+		writer.WriteHeader(http.StatusPartialContent)
+		_, _ = writer.Write(content[start : start+4096])
+		writer.(http.Flusher).Flush()
+		<-request.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// 下载并校验文件内容
+func checkStallDownload(t *testing.T, task *ParallelGetTask, content []byte) {
+	done := make(chan error)
+	go func() {
+		done <- task.Run()
+	}()
+	select {
+	case e := <-done:
+		if e != nil {
+			t.Fatal(e)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("下载任务未能结束！")
+	}
+	checkDownloadedFile(t, task.FilePath, content)
+	if task.downloadSize.Load() != int64(len(content)) {
+		t.Errorf("已下载大小不正确：%d", task.downloadSize.Load())
+	}
+}
+
+// 测试连接停滞时中断并重试
+func TestParallelGetTask_Stall(t *testing.T) {
+	idleReadTimeout := GlobalConfig.IdleReadTimeout
+	GlobalConfig.IdleReadTimeout = 100 * time.Millisecond
+	defer func() {
+		GlobalConfig.IdleReadTimeout = idleReadTimeout
+	}()
+	content := make([]byte, 512*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	requests := &atomic.Int32{}
+	server := newStallServer(t, content, requests)
+	task := NewParallelGetTask(server.URL, filepath.Join(t.TempDir(), "file.bin"), "", 0, 2)
+	stalled := &atomic.Bool{}
+	task.SubscribeEvent(func(event TaskEvent) {
+		var stallError *StallError
+		if errors.As(event.(*ShardRetryingEvent).Error, &stallError) {
+			stalled.Store(true)
+		}
+	}, EventShardRetrying)
+	checkStallDownload(t, task, content)
+	if requests.Load() != 2 {
+		t.Errorf("停滞的分片请求次数不正确：%d", requests.Load())
+	}
+	if !stalled.Load() {
+		t.Error("未因连接停滞而重试！")
+	}
+}
+
+// 测试对最后一个缓慢的分片发送对冲请求
+func TestParallelGetTask_Hedge(t *testing.T) {
+	idleReadTimeout, hedgeAfter := GlobalConfig.IdleReadTimeout, GlobalConfig.HedgeAfter
+	GlobalConfig.IdleReadTimeout, GlobalConfig.HedgeAfter = 0, 100*time.Millisecond
+	defer func() {
+		GlobalConfig.IdleReadTimeout, GlobalConfig.HedgeAfter = idleReadTimeout, hedgeAfter
+	}()
+	content := make([]byte, 512*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	requests := &atomic.Int32{}
+	server := newStallServer(t, content, requests)
+	task := NewParallelGetTask(server.URL, filepath.Join(t.TempDir(), "file.bin"), "", 0, 2)
+	retried := &atomic.Bool{}
+	task.SubscribeEvent(func(event TaskEvent) {
+		retried.Store(true)
+	}, EventShardRetrying)
+	checkStallDownload(t, task, content)
+	if requests.Load() != 2 {
+		t.Errorf("最后一个分片的请求次数不正确：%d", requests.Load())
+	}
+	if retried.Load() {
+		t.Error("对冲请求完成后原请求不应重试！")
+	}
+}
\ No newline at end of file
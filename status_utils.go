@@ -30,7 +30,27 @@ func ComputeSpeed(size float64, elapsed time.Duration) string {
 	return fmt.Sprintf("%.2f GB/s", bytePerSecond/math.Pow(1024, 3))
 }
 
+// ComputeSize 将数据大小换算为合适的单位
+//
+//   - size 数据大小，单位字节
+//
+// 返回换算单位后的大小字符串
+func ComputeSize(size int64) string {
+	value := float64(size)
+	if value <= 1024 {
+		return fmt.Sprintf("%d Byte", size)
+	}
+	if value <= math.Pow(1024, 2) {
+		return fmt.Sprintf("%.2f KB", value/1024)
+	}
+	if value <= math.Pow(1024, 3) {
+		return fmt.Sprintf("%.2f MB", value/math.Pow(1024, 2))
+	}
+	return fmt.Sprintf("%.2f GB", value/math.Pow(1024, 3))
+}
+
 // ComputeRemainTime 根据当前速度，计算剩余下载时间
+// 下载状态对象中的 TaskStatus.RemainTime 已包含经过平滑的剩余时间，推荐直接使用
 //
 //   - status 当前时刻的下载状态对象
 //
@@ -42,11 +62,26 @@ func ComputeRemainTime(status *TaskStatus) float64 {
 	return float64(status.TotalSize-status.DownloadSize) / status.Speed
 }
 
+// 格式化下载状态中的剩余时间，无法估算时返回表示未知的文本
+//
+//   - status 当前时刻的下载状态对象
+func formatRemainTime(status *TaskStatus) string {
+	if !status.RemainTimeKnown {
+		return msgRemainTimeUnknown.text()
+	}
+	return msgFormatRemainTime.format(status.RemainTime.Seconds())
+}
+
 // DefaultProcessLookup 默认的进度观察者回调函数，能够在控制台输出实时进度
+// 文件大小未知时，只输出已下载的大小
 //
 //   - status 当前时刻的下载状态对象
 func DefaultProcessLookup(status *TaskStatus) {
-	realTimeLogger.Info("\033[2K当前下载进度：%.2f %% 实际并发数：%d 当前速度：%s 预计剩余时间：%.1f秒\n", float64(status.DownloadSize)/float64(status.TotalSize)*100, status.Concurrency, ComputeSpeed(status.Speed, time.Millisecond), ComputeRemainTime(status)/1000)
+	if status.TotalSize < 0 {
+		realTimeLogger.Info(msgFormatStreamLookup.text(), ComputeSize(status.DownloadSize), status.Concurrency, ComputeSpeed(status.Speed, time.Millisecond))
+	} else {
+		realTimeLogger.Info(msgFormatProcessLookup.text(), float64(status.DownloadSize)/float64(status.TotalSize)*100, status.Concurrency, ComputeSpeed(status.Speed, time.Millisecond), formatRemainTime(status))
+	}
 	if !status.IsShutdown {
 		fmt.Print("\033[A")
 	}
@@ -0,0 +1,130 @@
+package gopher_fetch
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Task 下载任务接口， ParallelGetTask 与 MonoGetTask 均实现了该接口
+type Task interface {
+	// Run 开始执行下载任务
+	Run() error
+	// SubscribeStatus 订阅该下载任务的实时下载状态
+	SubscribeStatus(lookup func(status *TaskStatus))
+	// SubscribeEvent 订阅该下载任务的生命周期事件
+	SubscribeEvent(lookup func(event TaskEvent), types ...EventType)
+	// CheckFile 检查文件摘要值
+	CheckFile(algorithm, excepted string) (bool, error)
+	// Status 获取该任务当前的下载状态
+	Status() *TaskStatus
+}
+
+// 两种下载任务共有的设定方法，用于自动选择下载方式时统一设定任务
+// 这些方法不属于 Task 接口，以免增加设定项时破坏外部对 Task 接口的实现，需要设定时可将 Task 类型断言为具体的任务类型
+type configurableTask interface {
+	Task
+	// SetLogger 为该下载任务单独设定日志输出
+	SetLogger(target Logger)
+	// SetProgressStore 为该下载任务单独设定进度存储
+	SetProgressStore(store ProgressStore)
+	// SetTLSConfig 为该下载任务单独设定TLS配置
+	SetTLSConfig(config *TLSConfig) error
+	// SetProxy 为该下载任务单独设定代理服务器
+	SetProxy(config *ProxyConfig) error
+	// SetProxyPool 为该下载任务设定代理池
+	SetProxyPool(pool *ProxyPool)
+	// SetDialer 为该下载任务单独设定建立连接的配置
+	SetDialer(config *DialerConfig) error
+	// SetCookieJar 为该下载任务单独设定Cookie存储
+	SetCookieJar(jar http.CookieJar)
+	// SetCredentialProvider 为该下载任务单独设定认证信息提供者
+	SetCredentialProvider(provider CredentialProvider)
+	// SetUrl 更换该下载任务的下载地址
+	SetUrl(url string, expiresAt time.Time)
+	// SetUrlRefresher 为该下载任务设定刷新下载地址的回调函数
+	SetUrlRefresher(refresher UrlRefresher, expiresAt time.Time)
+	// ResolvedUrl 获取该下载任务重定向后最终的下载地址
+	ResolvedUrl() string
+	// SetTimestamping 设定该下载任务是否以时间戳模式下载
+	SetTimestamping(enabled bool)
+}
+
+// 确保两种下载任务均实现了 Task 接口以及全部设定方法
+var (
+	_ configurableTask = (*ParallelGetTask)(nil)
+	_ configurableTask = (*MonoGetTask)(nil)
+)
+
+// LoadTask 从进度文件恢复下载任务，根据进度文件记录的任务类型返回对应的任务对象
+//
+//   - file 进度文件位置
+//
+// 返回的任务对象可通过类型断言转换为 *ParallelGetTask 或者 *MonoGetTask
+func LoadTask(file string) (Task, error) {
+	return LoadTaskFromStore(globalProgressStore, file)
+}
+
+// LoadTaskFromStore 从进度存储恢复下载任务，根据进度文件记录的任务类型返回对应的任务对象
+//
+//   - store 进度存储
+//   - key 任务进度的键，即创建任务时传入的processFile参数
+func LoadTaskFromStore(store ProgressStore, key string) (Task, error) {
+	task, e := loadTask(store, key)
+	if e != nil {
+		return nil, e
+	}
+	return task, nil
+}
+
+// 从进度存储恢复下载任务，返回可统一设定的任务对象，参数同 LoadTaskFromStore
+func loadTask(store ProgressStore, key string) (configurableTask, error) {
+	envelope, e := loadProcessFile(store, key)
+	if e != nil {
+		return nil, e
+	}
+	switch envelope.Kind {
+	case taskKindParallel:
+		task, e := restoreParallelGetTask(store, key, envelope)
+		if e != nil {
+			return nil, e
+		}
+		return task, nil
+	case taskKindMono:
+		task, e := restoreMonoGetTask(store, key, envelope)
+		if e != nil {
+			return nil, e
+		}
+		return task, nil
+	default:
+		return nil, fmt.Errorf("未知的任务类型：%s", envelope.Kind)
+	}
+}
+
+// ScanTasks 扫描目录下的全部进度文件（以.json为后缀的文件），恢复其中全部可继续下载的任务
+// 无法恢复的文件会被跳过并输出警告日志
+//
+//   - dir 扫描的目录
+func ScanTasks(dir string) ([]Task, error) {
+	return ScanTasksFromStore(NewFileProgressStore(dir))
+}
+
+// ScanTasksFromStore 恢复进度存储中全部可继续下载的任务，无法恢复的进度会被跳过并输出警告日志
+//
+//   - store 进度存储
+func ScanTasksFromStore(store ProgressStore) ([]Task, error) {
+	keys, e := store.List()
+	if e != nil {
+		return nil, e
+	}
+	tasks := make([]Task, 0, len(keys))
+	for _, key := range keys {
+		task, e := LoadTaskFromStore(store, key)
+		if e != nil {
+			logger.warn(msgSkipProcessFile, fieldPath(key), fieldError(e))
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
\ No newline at end of file
@@ -0,0 +1,213 @@
+package gopher_fetch
+
+import (
+	"gitee.com/swsk33/gopher-notify"
+	"time"
+)
+
+// EventType 下载任务生命周期事件类型
+type EventType int
+
+// 事件类型常量
+const (
+	// EventTaskStarted 任务开始执行
+	EventTaskStarted EventType = iota + 1
+	// EventLengthResolved 已获取下载文件大小
+	EventLengthResolved
+	// EventFilePreallocated 已为下载文件预分配磁盘空间
+	EventFilePreallocated
+	// EventShardStarted 分片开始下载
+	EventShardStarted
+	// EventShardRetrying 分片出现错误，即将重试
+	EventShardRetrying
+	// EventShardDone 分片下载完成
+	EventShardDone
+	// EventCheckpointSaved 进度文件保存成功
+	EventCheckpointSaved
+	// EventCheckpointFailed 进度文件保存失败
+	EventCheckpointFailed
+	// EventTaskCompleted 任务下载完成
+	EventTaskCompleted
+	// EventTaskFailed 任务下载失败
+	EventTaskFailed
+	// EventTaskNotModified 以时间戳模式下载时，服务器上的文件未修改，任务未下载
+	EventTaskNotModified
+)
+
+// TaskEvent 下载任务生命周期事件，可通过类型断言转换为具体的事件类型，例如 *ShardRetryingEvent
+type TaskEvent interface {
+	// Type 返回事件类型
+	Type() EventType
+}
+
+// TaskStartedEvent 任务开始执行事件
+type TaskStartedEvent struct {
+	// 下载地址
+	Url string
+	// 下载文件位置
+	FilePath string
+	// 是否是从进度文件恢复的任务
+	Recover bool
+}
+
+// Type 实现 TaskEvent 接口
+func (event *TaskStartedEvent) Type() EventType {
+	return EventTaskStarted
+}
+
+// LengthResolvedEvent 已获取下载文件大小事件
+type LengthResolvedEvent struct {
+	// 下载文件的总大小（字节），为-1表示服务器未给出大小
+	TotalSize int64
+	// 服务器是否支持分片获取
+	SupportRange bool
+}
+
+// Type 实现 TaskEvent 接口
+func (event *LengthResolvedEvent) Type() EventType {
+	return EventLengthResolved
+}
+
+// FilePreallocatedEvent 已为下载文件预分配磁盘空间事件
+type FilePreallocatedEvent struct {
+	// 下载文件位置
+	FilePath string
+	// 预分配的大小（字节）
+	Size int64
+}
+
+// Type 实现 TaskEvent 接口
+func (event *FilePreallocatedEvent) Type() EventType {
+	return EventFilePreallocated
+}
+
+// ShardStartedEvent 分片开始下载事件
+type ShardStartedEvent struct {
+	// 分片序号，从1开始，单线程下载任务为0
+	Order int
+	// 分片的起始范围（字节，包含）
+	RangeStart int64
+	// 分片的结束范围（字节，包含），单线程下载任务为-1
+	RangeEnd int64
+}
+
+// Type 实现 TaskEvent 接口
+func (event *ShardStartedEvent) Type() EventType {
+	return EventShardStarted
+}
+
+// ShardRetryingEvent 分片出现错误即将重试事件
+type ShardRetryingEvent struct {
+	// 分片序号，从1开始，单线程下载任务为0
+	Order int
+	// 即将进行的重试次数
+	Attempt int
+	// 重试原因
+	Reason string
+	// 引发重试的实际错误
+	Error error
+}
+
+// Type 实现 TaskEvent 接口
+func (event *ShardRetryingEvent) Type() EventType {
+	return EventShardRetrying
+}
+
+// ShardDoneEvent 分片下载完成事件
+type ShardDoneEvent struct {
+	// 分片序号，从1开始，单线程下载任务为0
+	Order int
+	// 分片的起始范围（字节，包含）
+	RangeStart int64
+	// 分片的结束范围（字节，包含），单线程下载任务为-1
+	RangeEnd int64
+}
+
+// Type 实现 TaskEvent 接口
+func (event *ShardDoneEvent) Type() EventType {
+	return EventShardDone
+}
+
+// CheckpointSavedEvent 进度文件保存成功事件
+type CheckpointSavedEvent struct {
+	// 进度文件位置
+	ProcessFile string
+	// 保存时的已下载大小（字节）
+	DownloadSize int64
+}
+
+// Type 实现 TaskEvent 接口
+func (event *CheckpointSavedEvent) Type() EventType {
+	return EventCheckpointSaved
+}
+
+// CheckpointFailedEvent 进度文件保存失败事件
+type CheckpointFailedEvent struct {
+	// 进度文件位置
+	ProcessFile string
+	// 保存失败的原因
+	Error error
+}
+
+// Type 实现 TaskEvent 接口
+func (event *CheckpointFailedEvent) Type() EventType {
+	return EventCheckpointFailed
+}
+
+// TaskCompletedEvent 任务下载完成事件
+type TaskCompletedEvent struct {
+	// 下载文件位置
+	FilePath string
+	// 下载文件的总大小（字节）
+	TotalSize int64
+	// 本次运行任务所用的时间
+	Elapsed time.Duration
+}
+
+// Type 实现 TaskEvent 接口
+func (event *TaskCompletedEvent) Type() EventType {
+	return EventTaskCompleted
+}
+
+// TaskFailedEvent 任务下载失败事件
+type TaskFailedEvent struct {
+	// 导致任务失败的错误
+	Error error
+}
+
+// Type 实现 TaskEvent 接口
+func (event *TaskFailedEvent) Type() EventType {
+	return EventTaskFailed
+}
+
+// TaskNotModifiedEvent 以时间戳模式下载时，服务器上的文件未修改事件，本地文件保持不变
+type TaskNotModifiedEvent struct {
+	// 下载文件位置
+	FilePath string
+}
+
+// Type 实现 TaskEvent 接口
+func (event *TaskNotModifiedEvent) Type() EventType {
+	return EventTaskNotModified
+}
+
+// 创建一个事件主题，事件不做节流，每个事件都会同步通知到全部观察者
+func newEventSubject() *gopher_notify.Subject[TaskEvent] {
+	return gopher_notify.NewSubject[TaskEvent](0)
+}
+
+// 观察下载任务生命周期事件的观察者
+type eventObserver struct {
+	// 订阅的事件类型，为空表示订阅全部事件
+	types map[EventType]bool
+	// 用户传入的自定义接收事件的回调函数
+	subscribeFunction func(event TaskEvent)
+}
+
+// OnUpdate 当下载任务发布一个事件时，该方法被调用
+func (observer *eventObserver) OnUpdate(event TaskEvent) {
+	if len(observer.types) > 0 && !observer.types[event.Type()] {
+		return
+	}
+	observer.subscribeFunction(event)
+}
\ No newline at end of file
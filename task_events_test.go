@@ -0,0 +1,47 @@
+package gopher_fetch
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// 测试多线程下载任务的生命周期事件订阅
+func TestParallelGetTask_SubscribeEvent(t *testing.T) {
+	server, content := newTestFileServer(t, 256*1024)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewDefaultParallelGetTask(server.URL, filePath, 4)
+	// 订阅全部事件
+	lock := &sync.Mutex{}
+	received := make(map[EventType]int)
+	task.SubscribeEvent(func(event TaskEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		received[event.Type()]++
+	})
+	// 只订阅分片完成事件
+	doneOrders := make(map[int]bool)
+	task.SubscribeEvent(func(event TaskEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		doneOrders[event.(*ShardDoneEvent).Order] = true
+	}, EventShardDone)
+	e := task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	lock.Lock()
+	defer lock.Unlock()
+	for _, eventType := range []EventType{EventTaskStarted, EventLengthResolved, EventFilePreallocated, EventTaskCompleted} {
+		if received[eventType] != 1 {
+			t.Errorf("事件%d的接收次数不正确：%d", eventType, received[eventType])
+		}
+	}
+	if received[EventTaskFailed] != 0 {
+		t.Error("不应接收到任务失败事件！")
+	}
+	if len(doneOrders) != 4 {
+		t.Errorf("分片完成事件不正确：%v", doneOrders)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,188 @@
+package gopher_fetch
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 分片下载任务状态的快照
+type shardTaskStatusSnapshot struct {
+	// 已下载的部分（字节）
+	DownloadSize int64 `json:"downloadSize"`
+	// 该任务是否完成
+	TaskDone bool `json:"taskDone"`
+}
+
+// 分片下载任务配置的快照，与运行时的 shardTaskConfig 相互独立，修改运行时的结构不会改变进度文件格式
+type shardTaskConfigSnapshot struct {
+	// 下载链接
+	Url string `json:"url"`
+	// 分片序号，从1开始
+	Order int `json:"order"`
+	// 下载文件路径
+	FilePath string `json:"filePath"`
+	// 分片的起始范围（字节，包含）
+	RangeStart int64 `json:"rangeStart"`
+	// 分片的结束范围（字节，包含）
+	RangeEnd int64 `json:"rangeEnd"`
+}
+
+// 分片下载任务的快照，是分片任务保存至进度文件时的形式
+type shardTaskSnapshot struct {
+	// 分片任务配置
+	Config shardTaskConfigSnapshot `json:"config"`
+	// 分片任务执行状态
+	Status shardTaskStatusSnapshot `json:"status"`
+}
+
+// 自适应并发配置的快照，与公开的 AdaptiveConcurrency 相互独立，修改公开的结构不会改变进度文件格式
+type adaptiveSnapshot struct {
+	// 最小并发数
+	Min int `json:"min"`
+	// 最大并发数
+	Max int `json:"max"`
+	// 测量吞吐量并调整并发数的间隔
+	Interval time.Duration `json:"interval"`
+	// 继续增加并发数所需的吞吐量提升比例
+	Threshold float64 `json:"threshold"`
+}
+
+// 服务器给出的文件校验信息的快照，恢复的多线程任务不再探测服务器，需从快照中获取下载完成后保存的校验信息
+type validatorsSnapshot struct {
+	// 服务器给出的 ETag 响应头
+	ETag string `json:"etag,omitempty"`
+	// 服务器给出的 Last-Modified 响应头
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// 多线程下载任务的快照，是多线程下载任务保存至进度文件时的形式
+type parallelTaskSnapshot struct {
+	// 文件的下载链接
+	Url string `json:"url"`
+	// 重定向后最终的下载地址，未发生重定向时为空
+	ResolvedUrl string `json:"resolvedUrl,omitempty"`
+	// 下载文件位置
+	FilePath string `json:"filePath"`
+	// 下载文件的总大小（字节）
+	TotalSize int64 `json:"totalSize"`
+	// 已下载部分的大小（字节），等于全部分片已下载大小之和
+	DownloadSize int64 `json:"downloadSize"`
+	// 下载并发数
+	Concurrent int `json:"concurrent"`
+	// 分片请求时间间隔
+	ShardStartDelay time.Duration `json:"shardStartDelay"`
+	// 自适应并发配置，未启用时为空
+	Adaptive *adaptiveSnapshot `json:"adaptive,omitempty"`
+	// 时间戳模式下探测服务器时得到的校验信息，未启用时为空
+	Validators *validatorsSnapshot `json:"validators,omitempty"`
+	// 全部分片任务
+	ShardList []*shardTaskSnapshot `json:"shardList"`
+}
+
+// 单线程下载任务的快照，是单线程下载任务保存至进度文件时的形式
+type monoTaskSnapshot struct {
+	// 文件的下载链接
+	Url string `json:"url"`
+	// 重定向后最终的下载地址，未发生重定向时为空
+	ResolvedUrl string `json:"resolvedUrl,omitempty"`
+	// 下载文件位置
+	FilePath string `json:"filePath"`
+	// 下载文件的总大小（字节）
+	TotalSize int64 `json:"totalSize"`
+	// 已下载部分的大小（字节）
+	DownloadSize int64 `json:"downloadSize"`
+}
+
+// 获取分片下载任务当前的快照
+func (task *shardTask) snapshot() *shardTaskSnapshot {
+	task.lock.Lock()
+	config := task.Config
+	task.lock.Unlock()
+	return &shardTaskSnapshot{
+		Config: shardTaskConfigSnapshot{
+			Url:        config.Url,
+			Order:      config.Order,
+			FilePath:   config.FilePath,
+			RangeStart: config.RangeStart,
+			RangeEnd:   config.RangeEnd,
+		},
+		Status: shardTaskStatusSnapshot{
+			DownloadSize: task.Status.downloadSize.Load(),
+			TaskDone:     task.Status.getState() == ShardDone,
+		},
+	}
+}
+
+// 从快照恢复分片下载任务的状态
+//
+//   - status 分片状态快照
+func (task *shardTask) restore(status shardTaskStatusSnapshot) {
+	task.Status.downloadSize.Store(status.DownloadSize)
+	task.Status.lastNotifySize = status.DownloadSize
+	if status.TaskDone {
+		task.Status.setState(ShardDone)
+	}
+}
+
+// 获取多线程下载任务当前的快照
+// 任务的已下载大小由各分片快照累加得到，保证快照内部的一致性
+func (task *ParallelGetTask) snapshot() *parallelTaskSnapshot {
+	snapshot := &parallelTaskSnapshot{
+		Url:             task.Url(),
+		ResolvedUrl:     task.resolvedUrlSnapshot(),
+		FilePath:        task.FilePath,
+		TotalSize:       task.totalSize(),
+		DownloadSize:    0,
+		Concurrent:      task.Concurrent,
+		ShardStartDelay: task.ShardStartDelay,
+	}
+	if adaptive := task.Adaptive; adaptive != nil {
+		snapshot.Adaptive = &adaptiveSnapshot{
+			Min:       adaptive.Min,
+			Max:       adaptive.Max,
+			Interval:  adaptive.Interval,
+			Threshold: adaptive.Threshold,
+		}
+	}
+	if conditional := task.conditional; conditional != nil {
+		snapshot.Validators = &validatorsSnapshot{
+			ETag:         conditional.received.ETag,
+			LastModified: conditional.received.LastModified,
+		}
+	}
+	task.shardLock.Lock()
+	defer task.shardLock.Unlock()
+	snapshot.ShardList = make([]*shardTaskSnapshot, 0, len(task.ShardList))
+	for _, shard := range task.ShardList {
+		shardSnapshot := shard.snapshot()
+		snapshot.DownloadSize += shardSnapshot.Status.DownloadSize
+		snapshot.ShardList = append(snapshot.ShardList, shardSnapshot)
+	}
+	return snapshot
+}
+
+// 获取单线程下载任务当前的快照
+func (task *MonoGetTask) snapshot() *monoTaskSnapshot {
+	return &monoTaskSnapshot{
+		Url:          task.Url(),
+		ResolvedUrl:  task.resolvedUrlSnapshot(),
+		FilePath:     task.FilePath,
+		TotalSize:    task.totalSize(),
+		DownloadSize: task.downloadSize.Load(),
+	}
+}
+
+// MarshalJSON 将分片下载任务序列化为其快照，状态中的原子类型字段无法直接序列化
+func (task *shardTask) MarshalJSON() ([]byte, error) {
+	return json.Marshal(task.snapshot())
+}
+
+// MarshalJSON 将多线程下载任务序列化为其快照，与进度文件中记录的任务形式相同
+func (task *ParallelGetTask) MarshalJSON() ([]byte, error) {
+	return json.Marshal(task.snapshot())
+}
+
+// MarshalJSON 将单线程下载任务序列化为其快照，与进度文件中记录的任务形式相同
+func (task *MonoGetTask) MarshalJSON() ([]byte, error) {
+	return json.Marshal(task.snapshot())
+}
\ No newline at end of file
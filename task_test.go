@@ -0,0 +1,54 @@
+package gopher_fetch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 测试根据进度文件自动识别任务类型，以及扫描目录下全部可恢复的任务
+func TestScanTasks(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "a.bin")
+	e := os.WriteFile(filePath, make([]byte, 100), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// 多线程任务的进度文件
+	parallelTask := NewDefaultParallelGetTask("http://example.com/a.bin", filePath, 2)
+	parallelTask.TotalSize = 100
+	parallelTask.allocateTask()
+	parallelTask.saveCheckpoint(func() any {
+		return parallelTask.snapshot()
+	})
+	// 单线程任务的旧版本进度文件
+	e = os.WriteFile(filepath.Join(dir, "b.bin.process.json"), []byte(`{"url":"http://example.com/b.bin","filePath":"b.bin","totalSize":100,"downloadSize":60}`), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// 无法恢复的文件
+	e = os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"formatVersion":2,"kind":"unknown","task":{}}`), 0755)
+	if e != nil {
+		t.Fatal(e)
+	}
+	task, e := LoadTask(filePath + ".process.json")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if recovered, ok := task.(*ParallelGetTask); !ok || len(recovered.ShardList) != 2 {
+		t.Errorf("未能识别为多线程任务：%T", task)
+	}
+	tasks, e := ScanTasks(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("扫描到的任务数量不正确：%d", len(tasks))
+	}
+	if _, ok := tasks[0].(*ParallelGetTask); !ok {
+		t.Errorf("未能识别为多线程任务：%T", tasks[0])
+	}
+	if _, ok := tasks[1].(*MonoGetTask); !ok || tasks[1].Status().DownloadSize != 60 {
+		t.Errorf("未能识别为单线程任务：%T", tasks[1])
+	}
+}
\ No newline at end of file
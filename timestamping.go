@@ -0,0 +1,157 @@
+package gopher_fetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ErrNotModified 以时间戳模式下载时，服务器上的文件自上次下载后未修改，本地文件保持不变，可通过 errors.Is 从下载任务返回的错误中判断
+var ErrNotModified = errors.New("服务器上的文件未修改！")
+
+// 已下载文件的校验信息，时间戳模式下保存在下载文件旁的元数据文件中，用于下次下载时发送条件请求
+type fileValidators struct {
+	// 下载时使用的下载地址，再次下载的地址与之不同时不发送条件请求
+	Url string `json:"url"`
+	// 下载完成时的文件大小，本地文件大小与之不一致时视为已被修改，不发送条件请求
+	Size int64 `json:"size"`
+	// 服务器给出的 ETag 响应头
+	ETag string `json:"etag,omitempty"`
+	// 服务器给出的 Last-Modified 响应头
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// 获取下载文件对应的元数据文件位置，不使用.json后缀，避免与同目录下的进度文件混淆
+//
+//   - filePath 下载文件的保存路径
+func metadataFile(filePath string) string {
+	return fmt.Sprintf("%s.meta", filePath)
+}
+
+// 读取已下载文件的校验信息
+//
+//   - filePath 下载文件的保存路径
+//   - url 本次下载的下载地址
+//
+// 下载文件或者元数据文件不存在、无法解析、未记录任何校验信息、下载地址或者文件大小与记录不一致时返回nil
+func loadFileValidators(filePath, url string) *fileValidators {
+	info, e := os.Stat(filePath)
+	if e != nil {
+		return nil
+	}
+	content, e := os.ReadFile(metadataFile(filePath))
+	if e != nil {
+		return nil
+	}
+	validators := &fileValidators{}
+	if json.Unmarshal(content, validators) != nil || validators.Url != url || validators.Size != info.Size() || (validators.ETag == "" && validators.LastModified == "") {
+		return nil
+	}
+	return validators
+}
+
+// 下载文件的条件探测，携带上次下载时的校验信息，并记录本次探测响应中的校验信息
+type conditionalProbe struct {
+	// 上次下载时的校验信息，为nil表示不发送条件请求头
+	stored *fileValidators
+	// 本次探测响应中的校验信息
+	received fileValidators
+}
+
+// 上下文中条件探测的键类型
+type conditionalProbeKey struct{}
+
+// 返回一个携带条件探测的上下文，使用该上下文发送的请求都会带上条件请求头
+//
+//   - ctx 父上下文
+//   - probe 条件探测，为nil时直接返回父上下文
+func withConditionalProbe(ctx context.Context, probe *conditionalProbe) context.Context {
+	if probe == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, conditionalProbeKey{}, probe)
+}
+
+// 获取上下文携带的条件探测，未携带时返回nil
+//
+//   - ctx 请求的上下文
+func conditionalProbeFrom(ctx context.Context) *conditionalProbe {
+	probe, _ := ctx.Value(conditionalProbeKey{}).(*conditionalProbe)
+	return probe
+}
+
+// 按照上下文携带的条件探测，为请求设定 If-None-Match 与 If-Modified-Since 请求头
+//
+//   - request 将要发送的请求
+func setConditionalHeaders(request *http.Request) {
+	probe := conditionalProbeFrom(request.Context())
+	if probe == nil || probe.stored == nil {
+		return
+	}
+	if probe.stored.ETag != "" {
+		request.Header.Set("If-None-Match", probe.stored.ETag)
+	}
+	if probe.stored.LastModified != "" {
+		request.Header.Set("If-Modified-Since", probe.stored.LastModified)
+	}
+}
+
+// 记录探测响应中的校验信息
+//
+//   - ctx 探测请求的上下文
+//   - response 探测请求的响应
+func recordValidators(ctx context.Context, response *http.Response) {
+	if probe := conditionalProbeFrom(ctx); probe != nil {
+		probe.received.ETag = response.Header.Get("ETag")
+		probe.received.LastModified = response.Header.Get("Last-Modified")
+	}
+}
+
+// SetTimestamping 设定该任务是否以时间戳模式下载，请在调用 Run 方法之前调用
+// 启用后，若下载文件已存在且记录了上次下载时的 ETag 或者 Last-Modified ，则探测服务器时发送条件请求，服务器上的文件未修改时不改动本地文件，并返回 ErrNotModified
+// 下载完成后，按照 Last-Modified 设定本地文件的修改时间，并将校验信息保存至下载文件旁的元数据文件（下载文件路径加上.meta后缀）
+//
+//   - enabled 是否启用时间戳模式
+func (task *baseTask) SetTimestamping(enabled bool) {
+	task.timestamping = enabled
+}
+
+// 获取探测服务器使用的上下文，时间戳模式下携带条件探测，恢复的任务已有部分下载的内容，不发送条件请求头
+func (task *baseTask) probeContext() context.Context {
+	ctx := task.requestContext()
+	if !task.timestamping {
+		return ctx
+	}
+	task.conditional = &conditionalProbe{}
+	if !task.isRecover {
+		task.conditional.stored = loadFileValidators(task.FilePath, task.Url())
+	}
+	return withConditionalProbe(ctx, task.conditional)
+}
+
+// 时间戳模式下，下载完成后设定本地文件的修改时间，并保存文件的校验信息，失败时只输出警告
+func (task *baseTask) saveValidators() {
+	if !task.timestamping || task.conditional == nil {
+		return
+	}
+	validators := task.conditional.received
+	if modified, e := http.ParseTime(validators.LastModified); e == nil {
+		if e = os.Chtimes(task.FilePath, modified, modified); e != nil {
+			task.logger.warn(msgSetModTimeError, fieldPath(task.FilePath), fieldError(e))
+		}
+	}
+	info, e := os.Stat(task.FilePath)
+	if e != nil {
+		task.logger.warn(msgSaveMetadataError, fieldPath(task.FilePath), fieldError(e))
+		return
+	}
+	validators.Url = task.Url()
+	validators.Size = info.Size()
+	content, _ := json.Marshal(&validators)
+	if e = writeFileAtomic(content, metadataFile(task.FilePath), 0755); e != nil {
+		task.logger.warn(msgSaveMetadataError, fieldPath(task.FilePath), fieldError(e))
+	}
+}
\ No newline at end of file
@@ -0,0 +1,175 @@
+package gopher_fetch
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 创建一个给出 ETag 与 Last-Modified 并支持条件请求的测试文件服务器
+//
+//   - etag 当前文件的ETag
+//   - modified 文件的修改时间
+//
+// 返回服务器对象、文件内容与收到的GET请求次数
+func newValidatedServer(t *testing.T, etag *atomic.Value, modified time.Time) (*httptest.Server, []byte, *atomic.Int32) {
+	content := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	gets := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		writer.Header().Set("ETag", etag.Load().(string))
+		http.ServeContent(writer, request, "file.bin", modified, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	return server, content, gets
+}
+
+// 测试时间戳模式下，文件未修改时不再下载，且下载完成后设定文件的修改时间
+func TestParallelGetTask_Timestamping(t *testing.T) {
+	etag := &atomic.Value{}
+	etag.Store(`"v1"`)
+	modified := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	server, content, gets := newValidatedServer(t, etag, modified)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	// 首次下载
+	task := NewParallelGetTask(server.URL, filePath, "", 0, 4)
+	task.SetTimestamping(true)
+	if e := task.Run(); e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	// 元数据文件不应被当作进度文件
+	if keys, e := NewFileProgressStore(filepath.Dir(filePath)).List(); e != nil || len(keys) != 0 {
+		t.Errorf("元数据文件被当作进度文件：%v %v", keys, e)
+	}
+	info, e := os.Stat(filePath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !info.ModTime().Equal(modified) {
+		t.Errorf("下载文件的修改时间不正确：%s", info.ModTime())
+	}
+	// 文件未修改时不下载，也不改动本地文件
+	before := gets.Load()
+	task = NewParallelGetTask(server.URL, filePath, "", 0, 4)
+	task.SetTimestamping(true)
+	notModified := false
+	task.SubscribeEvent(func(event TaskEvent) {
+		notModified = true
+	}, EventTaskNotModified)
+	if e = task.Run(); !errors.Is(e, ErrNotModified) {
+		t.Fatalf("文件未修改时返回的结果不正确：%v", e)
+	}
+	if !notModified || gets.Load() != before {
+		t.Errorf("文件未修改时仍然发送了下载请求：%d，未修改事件：%t", gets.Load()-before, notModified)
+	}
+	info, e = os.Stat(filePath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !info.ModTime().Equal(modified) {
+		t.Error("文件未修改时本地文件被改动！")
+	}
+	// 文件修改后重新下载
+	etag.Store(`"v2"`)
+	task = NewParallelGetTask(server.URL, filePath, "", 0, 4)
+	task.SetTimestamping(true)
+	if e = task.Run(); e != nil {
+		t.Fatal(e)
+	}
+	if gets.Load() == before {
+		t.Error("文件修改后未重新下载！")
+	}
+	checkDownloadedFile(t, filePath, content)
+	// 下载地址不同时不发送条件请求
+	before = gets.Load()
+	task = NewParallelGetTask(server.URL+"/other.bin", filePath, "", 0, 4)
+	task.SetTimestamping(true)
+	if e = task.Run(); e != nil {
+		t.Fatal(e)
+	}
+	if gets.Load() == before {
+		t.Error("下载地址不同时仍然发送了条件请求！")
+	}
+	if _, e = os.Stat(metadataFile(filePath) + tempFileSuffix); !os.IsNotExist(e) {
+		t.Error("保存元数据文件后临时文件未被删除！")
+	}
+}
+
+// 测试自动选择下载方式时的时间戳模式，本地文件被改动后不发送条件请求
+func TestDownload_Timestamping(t *testing.T) {
+	etag := &atomic.Value{}
+	etag.Store(`"v1"`)
+	server, content, _ := newValidatedServer(t, etag, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC))
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	options := &DownloadOptions{Timestamping: true}
+	if _, e := Download(server.URL, filePath, options); e != nil {
+		t.Fatal(e)
+	}
+	task, e := Download(server.URL, filePath, options)
+	if !errors.Is(e, ErrNotModified) || task != nil {
+		t.Fatalf("文件未修改时返回的结果不正确：%v", e)
+	}
+	// 本地文件大小与记录不一致
+	if e = os.WriteFile(filePath, content[:1024], 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e = Download(server.URL, filePath, options); e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+}
+
+// 测试从进度文件恢复的多线程任务在时间戳模式下，使用进度文件中的校验信息保存元数据
+func TestParallelGetTask_TimestampingRecover(t *testing.T) {
+	etag := &atomic.Value{}
+	etag.Store(`"v1"`)
+	modified := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	server, content, _ := newValidatedServer(t, etag, modified)
+	dir := t.TempDir()
+	filePath, processFile := filepath.Join(dir, "file.bin"), filepath.Join(dir, "file.bin.process.json")
+	// 只完成探测与分配，模拟下载中断
+	task := NewParallelGetTask(server.URL, filePath, processFile, 0, 4)
+	task.SetTimestamping(true)
+	if e := task.getLength(); e != nil {
+		t.Fatal(e)
+	}
+	task.allocateTask()
+	if e := task.createFile(); e != nil {
+		t.Fatal(e)
+	}
+	task.saveCheckpoint(func() any {
+		return task.snapshot()
+	})
+	task.flushProgress()
+	// 恢复任务并完成下载
+	restored, e := NewParallelGetTaskFromFile(processFile)
+	if e != nil {
+		t.Fatal(e)
+	}
+	restored.SetTimestamping(true)
+	if e = restored.Run(); e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	info, e := os.Stat(filePath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !info.ModTime().Equal(modified) {
+		t.Errorf("恢复的任务未设定文件的修改时间：%s", info.ModTime())
+	}
+	if validators := loadFileValidators(filePath, server.URL); validators == nil || validators.ETag != `"v1"` {
+		t.Errorf("恢复的任务未保存校验信息：%+v", validators)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,163 @@
+package gopher_fetch
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrPublicKeyPinMismatch 服务器证书链中没有与固定公钥匹配的证书，可通过 errors.Is 判断
+var ErrPublicKeyPinMismatch = errors.New("服务器证书的公钥与固定的公钥不匹配！")
+
+// KeyPairFile 一对PEM格式的证书文件与私钥文件
+type KeyPairFile struct {
+	// 证书文件路径
+	CertFile string
+	// 私钥文件路径
+	KeyFile string
+}
+
+// TLSConfig 下载请求的TLS配置，零值表示使用系统默认配置
+type TLSConfig struct {
+	// 信任的根证书池，为nil时使用系统根证书池
+	RootCAs *x509.CertPool
+	// 额外信任的根证书文件（PEM格式）路径，其中的证书会被加入根证书池
+	RootCAFiles []string
+	// 双向TLS认证使用的客户端证书
+	ClientCertificates []tls.Certificate
+	// 双向TLS认证使用的客户端证书文件与私钥文件
+	ClientCertFiles []KeyPairFile
+	// 最低TLS版本，例如 tls.VersionTLS12 ，为0时使用默认值
+	MinVersion uint16
+	// 按主机固定的公钥，键为主机名（不含端口），值为证书公钥信息（SPKI）的SHA-256摘要的Base64编码
+	// 验证通过的证书链中至少有一个证书的公钥与其中之一匹配时才允许连接，未列出的主机不进行检查，主机名不区分大小写
+	// 键为"*"的公钥适用于未单独列出的全部主机，以IP地址访问时不会发送主机名，只能使用"*"固定
+	PinnedPublicKeys map[string][]string
+	// 是否跳过服务器证书校验，存在被窃听或篡改的风险，仅用于测试环境，开启时会输出警告日志
+	// 跳过校验时，固定公钥的检查仍然有效，此时检查服务器发送的全部证书
+	InsecureSkipVerify bool
+}
+
+// 根据TLS配置创建 tls.Config 对象，并校验配置是否正确
+//
+//   - config TLS配置
+//   - log 输出日志的日志对象
+func buildTLSConfig(config *TLSConfig, log *fetchLogger) (*tls.Config, error) {
+	switch config.MinVersion {
+	case 0, tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13:
+	default:
+		return nil, fmt.Errorf("不支持的最低TLS版本：0x%04x", config.MinVersion)
+	}
+	result := &tls.Config{
+		MinVersion:         config.MinVersion,
+		RootCAs:            config.RootCAs,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	// 加入额外的根证书
+	if len(config.RootCAFiles) > 0 {
+		if result.RootCAs == nil {
+			pool, e := x509.SystemCertPool()
+			if e != nil {
+				pool = x509.NewCertPool()
+			}
+			result.RootCAs = pool
+		} else {
+			result.RootCAs = result.RootCAs.Clone()
+		}
+		for _, file := range config.RootCAFiles {
+			content, e := os.ReadFile(file)
+			if e != nil {
+				return nil, fmt.Errorf("读取根证书文件%s失败！%w", file, e)
+			}
+			if !result.RootCAs.AppendCertsFromPEM(content) {
+				return nil, fmt.Errorf("根证书文件%s中没有有效的PEM格式证书！", file)
+			}
+		}
+	}
+	// 加载客户端证书
+	result.Certificates = append(result.Certificates, config.ClientCertificates...)
+	for _, pair := range config.ClientCertFiles {
+		certificate, e := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if e != nil {
+			return nil, fmt.Errorf("加载客户端证书%s失败！%w", pair.CertFile, e)
+		}
+		result.Certificates = append(result.Certificates, certificate)
+	}
+	// 固定公钥
+	if len(config.PinnedPublicKeys) > 0 {
+		// 主机名不区分大小写，统一转换为小写
+		pins := make(map[string]map[string]bool)
+		for host, keys := range config.PinnedPublicKeys {
+			host = strings.ToLower(host)
+			if pins[host] == nil {
+				pins[host] = make(map[string]bool)
+			}
+			for _, key := range keys {
+				digest, e := base64.StdEncoding.DecodeString(key)
+				if e != nil || len(digest) != sha256.Size {
+					return nil, fmt.Errorf("主机%s的固定公钥%s不是有效的SHA-256摘要Base64编码！", host, key)
+				}
+				pins[host][string(digest)] = true
+			}
+		}
+		insecure := config.InsecureSkipVerify
+		result.VerifyConnection = func(state tls.ConnectionState) error {
+			hostPins, ok := pins[strings.ToLower(state.ServerName)]
+			if !ok {
+				hostPins, ok = pins["*"]
+			}
+			if !ok {
+				return nil
+			}
+			// 服务器发送的证书列表未经验证，可能被附加任意的公开证书，因此只检查验证通过的证书链，跳过验证时才检查服务器发送的证书
+			var certificates []*x509.Certificate
+			if insecure {
+				certificates = state.PeerCertificates
+			} else {
+				for _, chain := range state.VerifiedChains {
+					certificates = append(certificates, chain...)
+				}
+			}
+			for _, certificate := range certificates {
+				digest := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+				if hostPins[string(digest[:])] {
+					return nil
+				}
+			}
+			return fmt.Errorf("%w 主机：%s", ErrPublicKeyPinMismatch, state.ServerName)
+		}
+	}
+	if config.InsecureSkipVerify {
+		log.warn(msgInsecureTLS)
+	}
+	return result, nil
+}
+
+// PublicKeyPin 计算证书的公钥固定值，即证书公钥信息（SPKI）的SHA-256摘要的Base64编码，可用于 TLSConfig 的 PinnedPublicKeys
+//
+//   - certificate 证书对象
+func PublicKeyPin(certificate *x509.Certificate) string {
+	digest := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// ConfigSetTLS 设定全局的TLS配置，配置有误时返回错误且不修改当前配置
+//
+//   - config TLS配置，传入nil表示恢复系统默认配置
+func ConfigSetTLS(config *TLSConfig) error {
+	if config == nil {
+		globalClient.setTLSConfig(nil)
+		return nil
+	}
+	tlsConfig, e := buildTLSConfig(config, logger)
+	if e != nil {
+		return e
+	}
+	globalClient.setTLSConfig(tlsConfig)
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,113 @@
+package gopher_fetch
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试使用自定义根证书与固定公钥下载
+func TestMonoGetTask_TLS(t *testing.T) {
+	retry := GlobalConfig.Retry
+	GlobalConfig.Retry = 0
+	defer func() {
+		GlobalConfig.Retry = retry
+	}()
+	content := bytes.Repeat([]byte("gopher-fetch"), 1024)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.ServeContent(writer, request, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	// 将服务器证书保存为根证书文件
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	e := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+	if e != nil {
+		t.Fatal(e)
+	}
+	pin := PublicKeyPin(server.Certificate())
+	cases := []struct {
+		name   string
+		config *TLSConfig
+		check  func(e error) bool
+	}{
+		{"untrusted", nil, func(e error) bool { return e != nil }},
+		{"rootCA", &TLSConfig{RootCAFiles: []string{caFile}}, func(e error) bool { return e == nil }},
+		{"pinned", &TLSConfig{RootCAFiles: []string{caFile}, PinnedPublicKeys: map[string][]string{"*": {pin}}}, func(e error) bool { return e == nil }},
+		{"pinMismatch", &TLSConfig{InsecureSkipVerify: true, PinnedPublicKeys: map[string][]string{"*": {"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}}}, func(e error) bool { return errors.Is(e, ErrPublicKeyPinMismatch) }},
+		{"insecure", &TLSConfig{InsecureSkipVerify: true}, func(e error) bool { return e == nil }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filePath := filepath.Join(dir, c.name+".bin")
+			task := NewMonoGetTask(server.URL, filePath, "")
+			if e := task.SetTLSConfig(c.config); e != nil {
+				t.Fatal(e)
+			}
+			e := task.Run()
+			if !c.check(e) {
+				t.Fatalf("下载结果不正确：%v", e)
+			}
+			if e != nil {
+				return
+			}
+			checkDownloadedFile(t, filePath, content)
+			if task.Status().Connections.HTTP2Requests == 0 {
+				t.Errorf("未使用HTTP/2：%+v", task.Status().Connections)
+			}
+		})
+	}
+}
+
+// 测试错误的TLS配置
+func TestSetTLSConfig_Invalid(t *testing.T) {
+	task := NewMonoGetTask("https://example.com/file.bin", filepath.Join(t.TempDir(), "file.bin"), "")
+	configs := []*TLSConfig{
+		{MinVersion: 0x0200},
+		{RootCAFiles: []string{filepath.Join(t.TempDir(), "missing.pem")}},
+		{PinnedPublicKeys: map[string][]string{"example.com": {"not-a-pin"}}},
+	}
+	for _, config := range configs {
+		if task.SetTLSConfig(config) == nil {
+			t.Errorf("未返回错误：%+v", config)
+		}
+	}
+}
+
+// 测试固定公钥只检查验证通过的证书链，且主机名不区分大小写
+func TestBuildTLSConfig_PinVerifiedChain(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+	certificate := server.Certificate()
+	pins := map[string][]string{"Example.COM": {PublicKeyPin(certificate)}}
+	cases := []struct {
+		name     string
+		insecure bool
+		state    tls.ConnectionState
+		match    bool
+	}{
+		{"verified", false, tls.ConnectionState{ServerName: "EXAMPLE.com", VerifiedChains: [][]*x509.Certificate{{certificate}}}, true},
+		{"unverified", false, tls.ConnectionState{ServerName: "example.com", PeerCertificates: []*x509.Certificate{certificate}}, false},
+		{"insecure", true, tls.ConnectionState{ServerName: "example.com", PeerCertificates: []*x509.Certificate{certificate}}, true},
+	}
+	for _, c := range cases {
+		config, e := buildTLSConfig(&TLSConfig{PinnedPublicKeys: pins, InsecureSkipVerify: c.insecure}, logger)
+		if e != nil {
+			t.Fatal(e)
+		}
+		e = config.VerifyConnection(c.state)
+		if (e == nil) != c.match {
+			t.Errorf("%s：固定公钥的检查结果不正确：%v", c.name, e)
+		}
+	}
+}
\ No newline at end of file
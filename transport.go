@@ -0,0 +1,252 @@
+package gopher_fetch
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 创建http请求客户端时使用的配置，配置变化时需要重新创建客户端
+type transportSettings struct {
+	// 建立TCP连接的超时时间
+	dialTimeout time.Duration
+	// TLS握手的超时时间
+	tlsHandshakeTimeout time.Duration
+	// 等待响应头的超时时间
+	responseHeaderTimeout time.Duration
+	// 是否禁用连接复用
+	disableKeepAlives bool
+	// 每个主机的最大连接数
+	maxConnsPerHost int
+	// 每个主机的最大空闲连接数
+	maxIdleConnsPerHost int
+	// HTTP协议版本
+	httpVersion HTTPVersion
+	// 代理服务器配置，为nil表示不使用代理
+	proxy *proxySetting
+	// TLS配置，为nil表示使用默认配置
+	tlsConfig *tls.Config
+	// 建立连接的配置，为nil表示使用默认配置
+	dialer *dialerSetting
+	// Cookie存储，为nil表示不处理Cookie
+	cookieJar *cookieJarSetting
+}
+
+// 从全局配置读取当前的客户端配置，不包括代理服务器、TLS、建立连接与Cookie存储的配置
+func currentTransportSettings() transportSettings {
+	return transportSettings{
+		dialTimeout:           GlobalConfig.DialTimeout,
+		tlsHandshakeTimeout:   GlobalConfig.TLSHandshakeTimeout,
+		responseHeaderTimeout: GlobalConfig.ResponseHeaderTimeout,
+		disableKeepAlives:     GlobalConfig.DisableKeepAlives,
+		maxConnsPerHost:       GlobalConfig.MaxConnsPerHost,
+		maxIdleConnsPerHost:   GlobalConfig.MaxIdleConnsPerHost,
+		httpVersion:           GlobalConfig.HTTPVersion,
+	}
+}
+
+// 代理服务器配置，以指针的形式保存在客户端配置中，使其可以比较
+type proxySetting struct {
+	// 根据请求返回代理服务器地址的函数，为nil表示不使用代理
+	proxy func(*http.Request) (*url.URL, error)
+}
+
+// http请求客户端的提供者，根据配置创建客户端并缓存，配置变化时重新创建
+// 全局提供者使用全局配置，下载任务可以创建自己的提供者，覆盖代理服务器与TLS配置，未覆盖的部分使用全局提供者的配置
+type clientProvider struct {
+	// 上级提供者，未覆盖的配置从上级获取，全局提供者为nil
+	parent *clientProvider
+	// 代理服务器配置，为nil表示使用上级的配置
+	proxy *proxySetting
+	// TLS配置，为nil表示使用上级的配置
+	tlsConfig *tls.Config
+	// 建立连接的配置，为nil表示使用上级的配置
+	dialer *dialerSetting
+	// Cookie存储，为nil表示使用上级的配置
+	cookieJar *cookieJarSetting
+	// 当前使用的客户端，为nil时将在下次请求时创建
+	client *http.Client
+	// 创建当前客户端时使用的配置
+	settings transportSettings
+	// 保护以上字段的锁
+	lock sync.Mutex
+}
+
+// 全局http请求客户端提供者
+var globalClient = &clientProvider{}
+
+// 将该提供者生效的代理服务器、TLS、连接与Cookie存储配置填入客户端配置，已填入的配置不会被覆盖，需要在持有锁时调用
+//
+//   - settings 客户端配置
+func (provider *clientProvider) resolve(settings *transportSettings) {
+	if settings.proxy == nil {
+		settings.proxy = provider.proxy
+	}
+	if settings.tlsConfig == nil {
+		settings.tlsConfig = provider.tlsConfig
+	}
+	if settings.dialer == nil {
+		settings.dialer = provider.dialer
+	}
+	if settings.cookieJar == nil {
+		settings.cookieJar = provider.cookieJar
+	}
+	if provider.parent != nil {
+		provider.parent.lock.Lock()
+		provider.parent.resolve(settings)
+		provider.parent.lock.Unlock()
+	}
+}
+
+// 获取http请求客户端，若配置在上次创建后发生变化，则重新创建
+func (provider *clientProvider) get() *http.Client {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	settings := currentTransportSettings()
+	provider.resolve(&settings)
+	if provider.client == nil || settings != provider.settings {
+		if provider.client != nil {
+			provider.client.CloseIdleConnections()
+		}
+		provider.client = newHttpClient(settings)
+		provider.settings = settings
+	}
+	return provider.client
+}
+
+// 设定代理服务器配置，下次请求时将重新创建客户端
+//
+//   - proxy 代理服务器配置，对于下载任务的提供者，nil表示使用全局配置
+func (provider *clientProvider) setProxy(proxy *proxySetting) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	provider.proxy = proxy
+}
+
+// 设定TLS配置，下次请求时将重新创建客户端
+//
+//   - tlsConfig TLS配置，对于下载任务的提供者，nil表示使用全局配置
+func (provider *clientProvider) setTLSConfig(tlsConfig *tls.Config) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	provider.tlsConfig = tlsConfig
+}
+
+// 设定建立连接的配置，下次请求时将重新创建客户端
+//
+//   - dialer 建立连接的配置，对于下载任务的提供者，nil表示使用全局配置
+func (provider *clientProvider) setDialer(dialer *dialerSetting) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	provider.dialer = dialer
+}
+
+// 设定Cookie存储，下次请求时将重新创建客户端
+//
+//   - cookieJar Cookie存储，对于下载任务的提供者，nil表示使用全局配置
+func (provider *clientProvider) setCookieJar(cookieJar *cookieJarSetting) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	provider.cookieJar = cookieJar
+}
+
+// 上下文中http请求客户端提供者的键类型
+type clientProviderKey struct{}
+
+// 返回一个携带http请求客户端提供者的上下文，使用该上下文发送的请求将使用该提供者的客户端
+//
+//   - ctx 父上下文
+//   - provider 客户端提供者，为nil时直接返回父上下文
+func withClientProvider(ctx context.Context, provider *clientProvider) context.Context {
+	if provider == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, clientProviderKey{}, provider)
+}
+
+// 从上下文中获取http请求客户端，上下文未携带提供者时使用全局提供者
+func clientFrom(ctx context.Context) *http.Client {
+	provider, ok := ctx.Value(clientProviderKey{}).(*clientProvider)
+	if !ok {
+		provider = globalClient
+	}
+	return provider.get()
+}
+
+// 根据配置创建http请求客户端
+//
+//   - settings 客户端配置
+func newHttpClient(settings transportSettings) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   settings.dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	dialContext := dialer.DialContext
+	if settings.dialer != nil {
+		dialContext = settings.dialer.dialContext(dialer)
+	}
+	transport := &http.Transport{
+		DialContext:            dialContext,
+		TLSHandshakeTimeout:    settings.tlsHandshakeTimeout,
+		ResponseHeaderTimeout:  settings.responseHeaderTimeout,
+		DisableKeepAlives:      settings.disableKeepAlives,
+		MaxConnsPerHost:        settings.maxConnsPerHost,
+		MaxIdleConnsPerHost:    settings.maxIdleConnsPerHost,
+		IdleConnTimeout:        90 * time.Second,
+		ForceAttemptHTTP2:      settings.httpVersion == HTTPVersionAuto,
+		OnProxyConnectResponse: checkProxyConnectResponse,
+	}
+	// 请求已从代理池选择代理服务器时，优先使用该代理服务器
+	transport.Proxy = func(request *http.Request) (*url.URL, error) {
+		if proxy, ok := selectedProxy(request); ok {
+			return proxy, nil
+		}
+		if settings.proxy != nil && settings.proxy.proxy != nil {
+			return settings.proxy.proxy(request)
+		}
+		return nil, nil
+	}
+	// 使用自定义TLS配置时需要复制一份，避免传输层修改原有配置
+	if settings.tlsConfig != nil {
+		transport.TLSClientConfig = settings.tlsConfig.Clone()
+	}
+	// 非空的TLSNextProto表示不启用HTTP/2
+	if settings.httpVersion == HTTPVersion1 {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	client := &http.Client{
+		Timeout:       0,
+		Transport:     newAuthTransport(transport),
+		CheckRedirect: checkRedirect,
+	}
+	if settings.cookieJar != nil {
+		client.Jar = settings.cookieJar.jar
+	}
+	return client
+}
+
+// 若错误表示请求超时，则将其转换为 TimeoutError ，否则原样返回
+//
+//   - e 发送请求时发生的错误
+//   - phase 发生错误时请求所处的阶段
+func classifyTimeout(e error, phase TimeoutPhase) error {
+	var netError net.Error
+	if !errors.As(e, &netError) || !netError.Timeout() {
+		return e
+	}
+	var timeout time.Duration
+	switch phase {
+	case TimeoutDial:
+		timeout = GlobalConfig.DialTimeout
+	case TimeoutTLSHandshake:
+		timeout = GlobalConfig.TLSHandshakeTimeout
+	case TimeoutResponseHeader:
+		timeout = GlobalConfig.ResponseHeaderTimeout
+	}
+	return &TimeoutError{Phase: phase, Timeout: timeout, Err: e}
+}
\ No newline at end of file
@@ -0,0 +1,221 @@
+package gopher_fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// UrlRefresher 刷新下载地址的回调函数，用于有效期有限的预签名地址，会在请求返回401或者403时，以及已知的过期时间之前被调用
+//
+//   - expired 当前已失效或者即将失效的下载地址
+//
+// 返回新的下载地址及其过期时间，过期时间未知时返回零值，返回错误表示无法刷新
+type UrlRefresher func(expired string) (string, time.Time, error)
+
+// 判断错误是否表示下载地址可能已失效，即服务器返回了401或者403
+//
+//   - e 判断的错误
+func isUrlExpiredError(e error) bool {
+	var statusError *StatusCodeError
+	return errors.As(e, &statusError) && (statusError.StatusCode == http.StatusUnauthorized || statusError.StatusCode == http.StatusForbidden)
+}
+
+// Url 获取任务当前的下载地址，下载地址可能在下载过程中被刷新或者通过 SetUrl 更换，下载过程中同样可以安全调用
+func (task *baseTask) Url() string {
+	task.urlLock.Lock()
+	defer task.urlLock.Unlock()
+	return task.url
+}
+
+// 设定任务的下载地址，需持有 urlLock 调用，释放锁后需调用 notifyUrlChanged
+//
+//   - url 新的下载地址
+//   - expiresAt 新地址的过期时间
+func (task *baseTask) setUrlLocked(url string, expiresAt time.Time) {
+	task.url = url
+	task.resolvedUrl = ""
+	task.urlExpiresAt = expiresAt
+}
+
+// 通知下载地址已变化，不能持有 urlLock 调用
+func (task *baseTask) notifyUrlChanged() {
+	if task.urlChanged != nil {
+		task.urlChanged()
+	}
+}
+
+// SetUrl 更换该任务的下载地址，例如为从进度文件恢复的任务提供新的预签名地址，全部未完成的分片都将使用新的地址，并在下次保存进度时写入进度文件
+// 传入的地址与原地址相同时只更新过期时间，继续使用已记录的重定向后的地址
+//
+//   - url 新的下载地址
+//   - expiresAt 新地址的过期时间，零值表示未知
+func (task *baseTask) SetUrl(url string, expiresAt time.Time) {
+	task.urlLock.Lock()
+	if url == task.url {
+		task.urlExpiresAt = expiresAt
+		task.urlLock.Unlock()
+		return
+	}
+	task.setUrlLocked(url, expiresAt)
+	task.urlLock.Unlock()
+	task.notifyUrlChanged()
+}
+
+// SetUrlRefresher 设定该任务刷新下载地址的回调函数，需要在开始下载之前调用
+// 请求返回401或者403时，或者当前地址将在 FetchConfig.UrlRefreshAhead 内过期时，会调用该函数获取新的地址，全部未完成的分片都将使用新的地址
+//
+//   - refresher 刷新下载地址的回调函数，传入nil表示不刷新
+//   - expiresAt 当前下载地址的过期时间，零值表示未知，此时只在请求返回401或者403时刷新
+func (task *baseTask) SetUrlRefresher(refresher UrlRefresher, expiresAt time.Time) {
+	task.urlLock.Lock()
+	defer task.urlLock.Unlock()
+	task.urlRefresher = refresher
+	task.urlExpiresAt = expiresAt
+}
+
+// 刷新被拒绝的下载地址，多个分片同时被拒绝时只刷新一次，其余分片等待并共享刷新的结果
+// 被拒绝的是重定向后的地址时，先回到原地址重新跟随重定向，原地址仍被拒绝时再调用刷新回调函数
+// 刷新回调函数在锁外调用，只在替换地址及其过期时间时持有锁
+//
+//   - rejected 被服务器拒绝的下载地址
+//
+// 返回新的下载地址，以及是否可以使用新的地址重试
+func (task *baseTask) refreshUrl(rejected string) (string, bool) {
+	task.urlLock.Lock()
+	// 其它分片正在刷新时，等待其完成，刷新后地址仍未变化表示刷新失败
+	if refreshing := task.urlRefreshing; refreshing != nil && task.targetUrlLocked() == rejected {
+		task.urlLock.Unlock()
+		<-refreshing
+		task.urlLock.Lock()
+		defer task.urlLock.Unlock()
+		current := task.targetUrlLocked()
+		return current, current != rejected
+	}
+	// 其它分片已刷新了地址
+	if current := task.targetUrlLocked(); current != rejected {
+		task.urlLock.Unlock()
+		return current, true
+	}
+	if task.resolvedUrl != "" {
+		task.resolvedUrl = ""
+		url := task.url
+		task.urlLock.Unlock()
+		task.notifyUrlChanged()
+		task.logger.warn(msgResolvedUrlRejected)
+		return url, true
+	}
+	refresher, expired := task.urlRefresher, task.url
+	if refresher == nil {
+		task.urlLock.Unlock()
+		return "", false
+	}
+	refreshing := make(chan struct{})
+	task.urlRefreshing = refreshing
+	task.urlLock.Unlock()
+	url, expiresAt, e := refresher(expired)
+	task.urlLock.Lock()
+	task.urlRefreshing = nil
+	// 刷新期间地址已被 SetUrl 更换时，使用更换后的地址
+	changed := task.url != expired
+	if e == nil && !changed {
+		task.setUrlLocked(url, expiresAt)
+	}
+	current := task.targetUrlLocked()
+	task.urlLock.Unlock()
+	close(refreshing)
+	if e != nil && !changed {
+		task.logger.warn(msgUrlRefreshFailed, fieldError(e))
+		return "", false
+	}
+	if !changed {
+		task.notifyUrlChanged()
+		task.logger.info(msgUrlRefreshed, field("expiresAt", expiresAt))
+	}
+	return current, true
+}
+
+// 获取发送请求时使用的下载地址，地址即将过期时先刷新
+func (task *baseTask) requestUrl() string {
+	task.urlLock.Lock()
+	url, refresher, expiresAt := task.targetUrlLocked(), task.urlRefresher, task.urlExpiresAt
+	task.urlLock.Unlock()
+	if refresher == nil || expiresAt.IsZero() || time.Until(expiresAt) > GlobalConfig.UrlRefreshAhead {
+		return url
+	}
+	if refreshed, ok := task.refreshUrl(url); ok {
+		return refreshed
+	}
+	return url
+}
+
+// 探测服务器的结果，自动选择下载方式时传递给创建的任务，避免任务运行时再次探测
+type probeResult struct {
+	// 文件长度，为-1表示未知
+	length int64
+	// 是否支持分片获取
+	supportRange bool
+	// 重定向后最终的请求地址
+	resolved string
+	// 时间戳模式下探测使用的条件探测，记录了服务器返回的校验信息，未启用时为nil
+	conditional *conditionalProbe
+}
+
+// 使用自动选择下载方式时探测服务器的结果，记录重定向后最终的下载地址，首次获取文件大小时不再请求服务器
+//
+//   - result 探测服务器的结果
+func (task *baseTask) useProbeResult(result *probeResult) {
+	task.setResolvedUrl(result.resolved)
+	task.probed = result
+}
+
+// 获取请求的文件大小，下载地址失效时刷新地址后再获取一次，并记录重定向后最终的下载地址
+//
+// 返回值分别是：获取到的长度、请求是否支持分片获取以及错误对象，同 getContentLength
+func (task *baseTask) probeLength() (int64, bool, error) {
+	// 已有自动选择下载方式时的探测结果，只使用一次，重试时仍重新探测
+	if probed := task.probed; probed != nil {
+		task.probed = nil
+		if task.timestamping {
+			task.conditional = probed.conditional
+		}
+		return probed.length, probed.supportRange, nil
+	}
+	url := task.requestUrl()
+	ctx := task.probeContext()
+	length, supportRange, resolved, e := getContentLength(ctx, url, task.logger)
+	if isUrlExpiredError(e) {
+		if refreshed, ok := task.refreshUrl(url); ok {
+			length, supportRange, resolved, e = getContentLength(ctx, refreshed, task.logger)
+		}
+	}
+	if e != nil {
+		return length, supportRange, e
+	}
+	task.setResolvedUrl(resolved)
+	return length, supportRange, nil
+}
+
+// 探测服务器，下载地址失效且设定了刷新回调时，刷新地址后再探测一次，用于自动选择下载方式
+//
+//   - ctx 探测请求的上下文
+//   - url 下载地址
+//   - refresher 刷新下载地址的回调函数，可以为nil
+//   - log 输出日志的日志对象
+//
+// 返回值分别是：最终使用的下载地址及其过期时间（未刷新时为零值）、文件长度、是否支持分片获取、重定向后最终的请求地址以及错误对象
+func probeWithUrlRefresh(ctx context.Context, url string, refresher UrlRefresher, log *fetchLogger) (string, time.Time, int64, bool, string, error) {
+	length, supportRange, resolved, e := getContentLength(ctx, url, log)
+	if refresher == nil || !isUrlExpiredError(e) {
+		return url, time.Time{}, length, supportRange, resolved, e
+	}
+	refreshed, expiresAt, refreshError := refresher(url)
+	if refreshError != nil {
+		log.warn(msgUrlRefreshFailed, fieldError(refreshError))
+		return url, time.Time{}, length, supportRange, resolved, e
+	}
+	log.info(msgUrlRefreshed, field("expiresAt", expiresAt))
+	length, supportRange, resolved, e = getContentLength(ctx, refreshed, log)
+	return refreshed, expiresAt, length, supportRange, resolved, e
+}
\ No newline at end of file
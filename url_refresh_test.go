@@ -0,0 +1,157 @@
+package gopher_fetch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 创建一个只接受当前签名的测试文件服务器，签名不正确时返回403
+//
+//   - signature 当前有效的签名
+//
+// 返回服务器对象、文件内容与返回403的次数
+func newSignedServer(t *testing.T, signature *atomic.Value) (*httptest.Server, []byte, *atomic.Int32) {
+	forbidden := &atomic.Int32{}
+	server, content := newWrappedFileServer(t, 256*1024, func(fileHandler http.Handler) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			if request.URL.Query().Get("sig") != signature.Load().(string) {
+				forbidden.Add(1)
+				writer.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fileHandler.ServeHTTP(writer, request)
+		}
+	})
+	return server, content, forbidden
+}
+
+// 测试下载过程中签名过期时刷新下载地址，刷新后的重试不计入重试次数
+func TestParallelGetTask_UrlRefresh(t *testing.T) {
+	retry := GlobalConfig.Retry
+	GlobalConfig.Retry = 0
+	defer func() {
+		GlobalConfig.Retry = retry
+	}()
+	signature := &atomic.Value{}
+	signature.Store("a")
+	server, content, forbidden := newSignedServer(t, signature)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	var refreshCount atomic.Int32
+	// 分片开始下载时签名过期
+	task := NewParallelGetTask(server.URL+"/file.bin?sig=a", filePath, "", 0, 4)
+	task.SubscribeEvent(func(event TaskEvent) {
+		signature.Store("b")
+	}, EventFilePreallocated)
+	// 刷新回调函数在锁外调用，可以访问任务对象，刷新期间被拒绝的其它分片等待其结果
+	task.SetUrlRefresher(func(expired string) (string, time.Time, error) {
+		refreshCount.Add(1)
+		if task.ResolvedUrl() != expired {
+			t.Errorf("刷新时的下载地址不正确：%s", task.ResolvedUrl())
+		}
+		time.Sleep(50 * time.Millisecond)
+		return server.URL + "/file.bin?sig=" + signature.Load().(string), time.Time{}, nil
+	}, time.Time{})
+	e := task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	if refreshCount.Load() != 1 || forbidden.Load() == 0 {
+		t.Errorf("刷新下载地址的次数不正确：%d，403次数：%d", refreshCount.Load(), forbidden.Load())
+	}
+	for _, shard := range task.ShardList {
+		if shard.url() != server.URL+"/file.bin?sig=b" {
+			t.Errorf("分片%d的下载地址未更新：%s", shard.Config.Order, shard.url())
+		}
+	}
+}
+
+// 测试下载地址在已知的过期时间之前被提前刷新
+func TestMonoGetTask_UrlRefreshBeforeExpiry(t *testing.T) {
+	signature := &atomic.Value{}
+	signature.Store("b")
+	server, content, forbidden := newSignedServer(t, signature)
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewMonoGetTask(server.URL+"/file.bin?sig=a", filePath, "")
+	task.SetUrlRefresher(func(expired string) (string, time.Time, error) {
+		return server.URL + "/file.bin?sig=b", time.Now().Add(time.Hour), nil
+	}, time.Now().Add(time.Second))
+	e := task.Run()
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+	if forbidden.Load() != 0 {
+		t.Errorf("下载地址未在过期前刷新，403次数：%d", forbidden.Load())
+	}
+}
+
+// 测试为从进度文件恢复的任务提供新的下载地址，新地址会被写入进度文件
+func TestParallelGetTask_RecoverWithNewUrl(t *testing.T) {
+	signature := &atomic.Value{}
+	signature.Store("a")
+	server, content, _ := newSignedServer(t, signature)
+	store := NewMemoryProgressStore()
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	task := NewParallelGetTask(server.URL+"/file.bin?sig=a", filePath, "task-1", 0, 4)
+	task.SetProgressStore(store)
+	if e := task.getLength(); e != nil {
+		t.Fatal(e)
+	}
+	task.allocateTask()
+	if e := task.createFile(); e != nil {
+		t.Fatal(e)
+	}
+	// 第一个分片已下载完成
+	first := task.ShardList[0]
+	first.Status.setState(ShardDone)
+	first.Status.downloadSize.Store(first.Config.RangeEnd + 1)
+	partial := make([]byte, len(content))
+	copy(partial, content[:first.Config.RangeEnd+1])
+	if e := os.WriteFile(filePath, partial, 0644); e != nil {
+		t.Fatal(e)
+	}
+	task.saveCheckpoint(func() any {
+		return task.snapshot()
+	})
+	// 签名过期后恢复任务
+	signature.Store("b")
+	recovered, e := NewParallelGetTaskFromStore(store, "task-1")
+	if e != nil {
+		t.Fatal(e)
+	}
+	recovered.SetUrl(server.URL+"/file.bin?sig=b", time.Time{})
+	recovered.saveCheckpoint(func() any {
+		return recovered.snapshot()
+	})
+	envelope, e := loadProcessFile(store, "task-1")
+	if e != nil {
+		t.Fatal(e)
+	}
+	snapshot, e := decodeProcessTask[parallelTaskSnapshot](envelope, taskKindParallel)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if snapshot.Url != server.URL+"/file.bin?sig=b" {
+		t.Errorf("进度文件中的下载地址未更新：%s", snapshot.Url)
+	}
+	for _, shard := range snapshot.ShardList {
+		// 已完成的分片保持原有地址
+		excepted := server.URL + "/file.bin?sig=b"
+		if shard.Status.TaskDone {
+			excepted = server.URL + "/file.bin?sig=a"
+		}
+		if shard.Config.Url != excepted {
+			t.Errorf("进度文件中分片%d的下载地址不正确：%s", shard.Config.Order, shard.Config.Url)
+		}
+	}
+	if e = recovered.Run(); e != nil {
+		t.Fatal(e)
+	}
+	checkDownloadedFile(t, filePath, content)
+}
\ No newline at end of file